@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKey struct {
+	MongoID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	Hash       string             `bson:"hash"`
+	Scopes     []string           `bson:"scopes"`
+	Role       string             `bson:"role"`
+	RateLimit  float64            `bson:"ratelimit"`
+	Burst      int                `bson:"burst"`
+	CreatedBy  string             `bson:"createdby,omitempty"`
+	CreatedAt  time.Time          `bson:"createdat"`
+	RotatedAt  time.Time          `bson:"rotatedat,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedat,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastusedat,omitempty"`
+}
+
+type KeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Role      string   `json:"role"`
+	RateLimit float64  `json:"rateLimit"`
+	Burst     int      `json:"burst"`
+}
+
+type KeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Role       string     `json:"role"`
+	RateLimit  float64    `json:"rateLimit"`
+	Burst      int        `json:"burst"`
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+var allowedScopes = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *mongo.Collection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coll := client.Database("exercise-1").Collection("apikeys")
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, coll, nil
+}
+
+// generateKey returns a new random key. Keys carry 256 bits of entropy, so
+// a plain SHA-256 hash is enough to store them safely.
+func generateKey() (key, prefix, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key = "bsk_" + base64.RawURLEncoding.EncodeToString(secret)
+	sum := sha256.Sum256([]byte(key))
+	return key, key[:12], hex.EncodeToString(sum[:]), nil
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := map[string]bool{}
+	var result []string
+	for _, scope := range scopes {
+		scope = strings.ToUpper(strings.TrimSpace(scope))
+		valid := false
+		for _, allowed := range allowedScopes {
+			if scope == allowed {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid scope %q, allowed scopes are %s", scope, strings.Join(allowedScopes, ", "))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return result, nil
+}
+
+// normalizeRole checks the role of a new key, reader by default. A key
+// cannot hold a role above that of whoever issues it.
+func normalizeRole(role string, issuer *Principal) (string, error) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if role == "" {
+		role = RoleReader
+	}
+	if roleRank[role] == 0 {
+		return "", fmt.Errorf("invalid role %q, allowed roles are %s, %s and %s", role, RoleReader, RoleEditor, RoleAdmin)
+	}
+	if issuer == nil || !issuer.HasRole(role) {
+		return "", fmt.Errorf("cannot issue a key with the %s role", role)
+	}
+	return role, nil
+}
+
+func toKeyResponse(k APIKey) KeyResponse {
+	resp := KeyResponse{
+		ID:         k.MongoID.Hex(),
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		Role:       k.Role,
+		RateLimit:  k.RateLimit,
+		Burst:      k.Burst,
+		CreatedBy:  k.CreatedBy,
+		CreatedAt:  k.CreatedAt,
+		RevokedAt:  k.RevokedAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+	if !k.RotatedAt.IsZero() {
+		rotated := k.RotatedAt
+		resp.RotatedAt = &rotated
+	}
+	return resp
+}
+
+func issueKey(ctx context.Context, coll *mongo.Collection, req KeyRequest, actor string) (KeyResponse, error) {
+	key, prefix, hash, err := generateKey()
+	if err != nil {
+		return KeyResponse{}, err
+	}
+	apiKey := APIKey{
+		MongoID:   primitive.NewObjectID(),
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    req.Scopes,
+		Role:      req.Role,
+		RateLimit: req.RateLimit,
+		Burst:     req.Burst,
+		CreatedBy: actor,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := coll.InsertOne(ctx, apiKey); err != nil {
+		return KeyResponse{}, err
+	}
+	resp := toKeyResponse(apiKey)
+	resp.Key = key
+	return resp, nil
+}
+
+func listKeys(ctx context.Context, coll *mongo.Collection) ([]KeyResponse, error) {
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	ret := []KeyResponse{}
+	for _, k := range keys {
+		ret = append(ret, toKeyResponse(k))
+	}
+	return ret, nil
+}
+
+// rotateKey replaces the secret of an active key; the old secret stops
+// working immediately.
+func rotateKey(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID) (KeyResponse, error) {
+	key, prefix, hash, err := generateKey()
+	if err != nil {
+		return KeyResponse{}, err
+	}
+	var apiKey APIKey
+	err = coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "revokedat": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"prefix": prefix, "hash": hash, "rotatedat": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&apiKey)
+	if err != nil {
+		return KeyResponse{}, err
+	}
+	resp := toKeyResponse(apiKey)
+	resp.Key = key
+	return resp, nil
+}
+
+func revokeKey(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID) error {
+	result, err := coll.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedat": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedat": time.Now().UTC()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func keyError(c echo.Context, err error) error {
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "API key not found or already revoked",
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var coll *mongo.Collection
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, coll, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	admin := e.Group("/api/keys", requireRole(auth, RoleAdmin))
+
+	admin.POST("", func(c echo.Context) error {
+		var req KeyRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "name is a required field",
+			})
+		}
+		if req.RateLimit < 0 || req.Burst < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "rateLimit and burst must not be negative",
+			})
+		}
+		scopes, err := normalizeScopes(req.Scopes)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		req.Scopes = scopes
+		principal, _ := c.Get("principal").(*Principal)
+		role, err := normalizeRole(req.Role, principal)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		req.Role = role
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		resp, err := issueKey(ctx, coll, req, actor(c))
+		if err != nil {
+			return keyError(c, err)
+		}
+		return c.JSON(http.StatusCreated, resp)
+	})
+
+	admin.GET("", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		keys, err := listKeys(ctx, coll)
+		if err != nil {
+			return keyError(c, err)
+		}
+		return c.JSON(http.StatusOK, keys)
+	})
+
+	admin.POST("/:id/rotate", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return keyError(c, mongo.ErrNoDocuments)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "rotate")
+		defer cancel()
+		resp, err := rotateKey(ctx, coll, id)
+		if err != nil {
+			return keyError(c, err)
+		}
+		return c.JSON(http.StatusOK, resp)
+	})
+
+	admin.DELETE("/:id", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return keyError(c, mongo.ErrNoDocuments)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "revoke")
+		defer cancel()
+		if err := revokeKey(ctx, coll, id); err != nil {
+			return keyError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "API key revoked successfully",
+		})
+	})
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("API keys service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
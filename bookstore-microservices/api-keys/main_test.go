@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	got, err := normalizeScopes([]string{"get", " POST ", "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"GET", "POST"}) {
+		t.Errorf("scopes = %v", got)
+	}
+
+	if _, err := normalizeScopes([]string{"TRACE"}); err == nil {
+		t.Error("TRACE should be rejected")
+	}
+	if _, err := normalizeScopes(nil); err == nil {
+		t.Error("empty scopes should be rejected")
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, prefix, hash, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "bsk_") || !strings.HasPrefix(key, prefix) {
+		t.Errorf("key %q / prefix %q", key, prefix)
+	}
+	sum := sha256.Sum256([]byte(key))
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Error("hash is not the SHA-256 of the key")
+	}
+
+	other, _, _, _ := generateKey()
+	if other == key {
+		t.Error("generated the same key twice")
+	}
+}
+
+func TestNormalizeRole(t *testing.T) {
+	editor := &Principal{Subject: "erin", Roles: []string{RoleEditor}}
+	if role, err := normalizeRole("", editor); err != nil || role != RoleReader {
+		t.Errorf("normalizeRole(\"\") = %q, %v, want a reader key", role, err)
+	}
+	if role, err := normalizeRole(" Editor ", editor); err != nil || role != RoleEditor {
+		t.Errorf("normalizeRole(editor) = %q, %v", role, err)
+	}
+	if _, err := normalizeRole(RoleAdmin, editor); err == nil {
+		t.Error("an editor should not issue admin keys")
+	}
+	if _, err := normalizeRole("owner", editor); err == nil {
+		t.Error("unknown roles should be rejected")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuthorKeyMergesNameForms(t *testing.T) {
+	forms := []string{"Edgar Allan Poe", "Poe, Edgar Allan", "  edgar  allan POE "}
+	for _, form := range forms {
+		if got := authorKey(form); got != "edgar allan poe" {
+			t.Errorf("authorKey(%q) = %q", form, got)
+		}
+	}
+	if authorKey("J.R.R. Tolkien") != authorKey("J R R Tolkien") {
+		t.Error("periods should not matter")
+	}
+}
+
+func TestNormalizeAuthorName(t *testing.T) {
+	cases := map[string]string{
+		"Poe, Edgar Allan":        "Edgar Allan Poe",
+		"Mary  Shelley":           "Mary Shelley",
+		"Martin Luther King, Jr.": "Martin Luther King, Jr.",
+		"Dumas, Alexandre, père":  "Dumas, Alexandre, père",
+		"Plato":                   "Plato",
+	}
+	for in, want := range cases {
+		if got := normalizeAuthorName(in); got != want {
+			t.Errorf("normalizeAuthorName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSortNameFor(t *testing.T) {
+	if got := sortNameFor("Edgar Allan Poe"); got != "Poe, Edgar Allan" {
+		t.Errorf("sortNameFor = %q", got)
+	}
+	if got := sortNameFor("Plato"); got != "Plato" {
+		t.Errorf("sortNameFor = %q", got)
+	}
+}
+
+func TestSplitAuthors(t *testing.T) {
+	got := splitAuthors("Neil Gaiman & Pratchett, Terry; ")
+	want := []string{"Neil Gaiman", "Terry Pratchett"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitAuthors = %v, want %v", got, want)
+	}
+}
+
+func TestAuthorFields(t *testing.T) {
+	birth, death := 1809, 1849
+	fields, err := authorFields(AuthorRequest{
+		Name:      "Poe, Edgar Allan",
+		BirthYear: &birth,
+		DeathYear: &death,
+		Aliases:   []string{"Edgar Allan Poe", "E. A. Poe", "e a poe"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["name"] != "Edgar Allan Poe" || fields["sortname"] != "Poe, Edgar Allan" {
+		t.Errorf("unexpected name fields %v", fields)
+	}
+	if !reflect.DeepEqual(fields["aliases"], []string{"E. A. Poe"}) {
+		t.Errorf("aliases = %v", fields["aliases"])
+	}
+
+	if _, err := authorFields(AuthorRequest{Name: "Poe", BirthYear: &death, DeathYear: &birth}); err == nil {
+		t.Error("death before birth should be rejected")
+	}
+	if _, err := authorFields(AuthorRequest{Name: " "}); err == nil {
+		t.Error("empty names should be rejected")
+	}
+}
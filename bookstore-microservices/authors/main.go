@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AuthorRequest struct {
+	Name        string   `json:"name"`
+	SortName    string   `json:"sortName"`
+	BirthYear   *int     `json:"birthYear"`
+	DeathYear   *int     `json:"deathYear"`
+	Nationality string   `json:"nationality"`
+	Aliases     []string `json:"aliases"`
+}
+
+type AuthorResponse struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	SortName    string   `json:"sortName"`
+	BirthYear   *int     `json:"birthYear"`
+	DeathYear   *int     `json:"deathYear"`
+	Nationality string   `json:"nationality"`
+	Aliases     []string `json:"aliases"`
+}
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *authorStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authors := &authorStore{coll: client.Database("exercise-1").Collection("authors")}
+	if err := authors.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, authors, nil
+}
+
+// pagination reads limit and offset from the query string.
+func pagination(c echo.Context, defaultLimit, maxLimit int64) (int64, int64) {
+	limit, err := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func toAuthorResponse(a Author) AuthorResponse {
+	aliases := a.Aliases
+	if aliases == nil {
+		aliases = []string{}
+	}
+	return AuthorResponse{
+		ID:          a.MongoID.Hex(),
+		Name:        a.Name,
+		SortName:    a.SortName,
+		BirthYear:   a.BirthYear,
+		DeathYear:   a.DeathYear,
+		Nationality: a.Nationality,
+		Aliases:     aliases,
+	}
+}
+
+// authorFields validates a request and returns the stored fields it sets.
+func authorFields(req AuthorRequest) (bson.M, error) {
+	name := normalizeAuthorName(req.Name)
+	if name == "" {
+		return nil, fmt.Errorf("name is a required field")
+	}
+	if req.BirthYear != nil && req.DeathYear != nil && *req.DeathYear < *req.BirthYear {
+		return nil, fmt.Errorf("deathYear cannot be before birthYear")
+	}
+	sortName := strings.TrimSpace(req.SortName)
+	if sortName == "" {
+		sortName = sortNameFor(name)
+	}
+
+	key := authorKey(name)
+	aliases := []string{}
+	aliasKeys := []string{}
+	seen := map[string]bool{key: true}
+	for _, alias := range req.Aliases {
+		alias = strings.Join(strings.Fields(alias), " ")
+		aliasKey := authorKey(alias)
+		if alias == "" || seen[aliasKey] {
+			continue
+		}
+		seen[aliasKey] = true
+		aliases = append(aliases, alias)
+		aliasKeys = append(aliasKeys, aliasKey)
+	}
+
+	return bson.M{
+		"name":        name,
+		"sortname":    sortName,
+		"key":         key,
+		"birthyear":   req.BirthYear,
+		"deathyear":   req.DeathYear,
+		"nationality": strings.TrimSpace(req.Nationality),
+		"aliases":     aliases,
+		"aliaskeys":   aliasKeys,
+	}, nil
+}
+
+func listAuthors(ctx context.Context, coll *mongo.Collection, q string, limit, offset int64) ([]AuthorResponse, error) {
+	filter := bson.M{}
+	if q = strings.TrimSpace(q); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"name": pattern},
+			bson.M{"sortname": pattern},
+			bson.M{"aliases": pattern},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sortname", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var authors []Author
+	if err := cursor.All(ctx, &authors); err != nil {
+		return nil, err
+	}
+	ret := []AuthorResponse{}
+	for _, a := range authors {
+		ret = append(ret, toAuthorResponse(a))
+	}
+	return ret, nil
+}
+
+func createAuthor(ctx context.Context, coll *mongo.Collection, fields bson.M, actor string) (Author, error) {
+	fields["_id"] = primitive.NewObjectID()
+	fields["createdby"] = actor
+	fields["createdat"] = time.Now().UTC()
+	if _, err := coll.InsertOne(ctx, fields); err != nil {
+		return Author{}, err
+	}
+	var author Author
+	err := coll.FindOne(ctx, bson.M{"_id": fields["_id"]}).Decode(&author)
+	return author, err
+}
+
+func updateAuthor(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, fields bson.M, actor string) (Author, error) {
+	fields["updatedby"] = actor
+	fields["updatedat"] = time.Now().UTC()
+	var author Author
+	err := coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&author)
+	return author, err
+}
+
+// deleteAuthor removes an author no book links to any more.
+func deleteAuthor(ctx context.Context, authors, books *mongo.Collection, id primitive.ObjectID) error {
+	linked, err := books.CountDocuments(ctx, bson.M{
+		"authorids": id.Hex(),
+		"deletedat": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	if linked > 0 {
+		return errAuthorInUse
+	}
+	result, err := authors.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+var errAuthorInUse = errors.New("author is still linked to books")
+
+// migrateBookAuthors links books that only carry an author string to
+// author records, creating them as needed. Books already linked are left
+// alone, so running it again only picks up new books.
+func migrateBookAuthors(ctx context.Context, authors *authorStore, books *mongo.Collection) (int, error) {
+	filter := bson.M{"authorids": bson.M{"$exists": false}}
+	cursor, err := books.Find(ctx, filter, options.Find().SetProjection(bson.M{"bookauthor": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var book struct {
+			MongoID    primitive.ObjectID `bson:"_id"`
+			BookAuthor string             `bson:"bookauthor"`
+		}
+		if err := cursor.Decode(&book); err != nil {
+			return migrated, err
+		}
+		ids, _, err := authors.resolveBookAuthors(ctx, book.BookAuthor, nil, "migration")
+		if err != nil {
+			return migrated, err
+		}
+		_, err = books.UpdateOne(ctx, bson.M{"_id": book.MongoID}, bson.M{"$set": bson.M{"authorids": ids}})
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+func authorError(c echo.Context, err error) error {
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Author not found",
+		})
+	}
+	if err == errAuthorInUse {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "An author with this name already exists",
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func bindAuthor(c echo.Context) (bson.M, error) {
+	var req AuthorRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, errors.New("Invalid request body")
+	}
+	return authorFields(req)
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var authors *authorStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, authors, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		authors = &authorStore{}
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	books := collection(client, "information")
+	keys := newKeyStore(collection(client, "apikeys"))
+
+	if client != nil {
+		ctx, cancel := dbContext(context.Background(), "migrate")
+		migrated, err := migrateBookAuthors(ctx, authors, books)
+		cancel()
+		if err != nil {
+			fmt.Printf("Warning: Failed to migrate book authors: %v\n", err)
+		} else if migrated > 0 {
+			fmt.Printf("Linked %d books to author records\n", migrated)
+		}
+	}
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	e.GET("/api/authors", func(c echo.Context) error {
+		limit, offset := pagination(c, 100, 1000)
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		list, err := listAuthors(ctx, authors.coll, c.QueryParam("q"), limit, offset)
+		if err != nil {
+			return authorError(c, err)
+		}
+		return c.JSON(http.StatusOK, list)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/authors/:id", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return authorError(c, mongo.ErrNoDocuments)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		var author Author
+		if err := authors.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&author); err != nil {
+			return authorError(c, err)
+		}
+		return c.JSON(http.StatusOK, toAuthorResponse(author))
+	}, optionalAPIKey(keys))
+
+	e.POST("/api/authors", func(c echo.Context) error {
+		fields, err := bindAuthor(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		author, err := createAuthor(ctx, authors.coll, fields, actor(c))
+		if err != nil {
+			return authorError(c, err)
+		}
+		return c.JSON(http.StatusCreated, toAuthorResponse(author))
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/authors/:id", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return authorError(c, mongo.ErrNoDocuments)
+		}
+		fields, err := bindAuthor(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		author, err := updateAuthor(ctx, authors.coll, id, fields, actor(c))
+		if err != nil {
+			return authorError(c, err)
+		}
+		return c.JSON(http.StatusOK, toAuthorResponse(author))
+	}, authorize(auth, keys, RoleEditor))
+
+	e.DELETE("/api/authors/:id", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return authorError(c, mongo.ErrNoDocuments)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		if err := deleteAuthor(ctx, authors.coll, books, id); err != nil {
+			return authorError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Author deleted successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/authors/migrate", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "migrate")
+		defer cancel()
+		migrated, err := migrateBookAuthors(ctx, authors, books)
+		if err != nil {
+			return authorError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]int{"migrated": migrated})
+	}, requireRole(auth, RoleAdmin))
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("Authors service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
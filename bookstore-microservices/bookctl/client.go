@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Book struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Pages   string `json:"pages,omitempty"`
+	Edition string `json:"edition,omitempty"`
+	Year    string `json:"year,omitempty"`
+}
+
+// BookResponse is the shape bookctl prints as JSON. Unlike Book it keeps
+// empty fields so the output always has the same keys as the CSV columns.
+type BookResponse struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Pages   string `json:"pages"`
+	Edition string `json:"edition"`
+	Year    string `json:"year"`
+}
+
+// APIError is returned when the API answers with a non-2xx status.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("request failed with status %d", e.Status)
+	}
+	return fmt.Sprintf("request failed with status %d: %s", e.Status, e.Message)
+}
+
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) do(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeAPIError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeAPIError extracts the "error" message the services send on failure.
+func decodeAPIError(resp *http.Response) error {
+	apiErr := &APIError{Status: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(raw, &body); err == nil {
+		for _, key := range []string{"error", "detail", "message"} {
+			if msg, ok := body[key].(string); ok && msg != "" {
+				apiErr.Message = msg
+				break
+			}
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(raw))
+	}
+	return apiErr
+}
+
+func (c *Client) ListBooks() ([]Book, error) {
+	var books []Book
+	if err := c.do(http.MethodGet, "/api/books", nil, &books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// GetBook looks a book up by ID.
+func (c *Client) GetBook(id string) (*Book, error) {
+	var book Book
+	if err := c.do(http.MethodGet, "/api/books/"+url.PathEscape(id), nil, &book); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+func (c *Client) CreateBook(book Book) error {
+	return c.do(http.MethodPost, "/api/books", book, nil)
+}
+
+func (c *Client) UpdateBook(book Book) error {
+	return c.do(http.MethodPut, "/api/books/"+url.PathEscape(book.ID), book, nil)
+}
+
+func (c *Client) DeleteBook(id string) error {
+	return c.do(http.MethodDelete, "/api/books/"+url.PathEscape(id), nil, nil)
+}
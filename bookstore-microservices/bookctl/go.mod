@@ -0,0 +1,3 @@
+module bookctl
+
+go 1.22.0
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `bookctl - manage the bookstore catalog through its API
+
+Usage:
+  bookctl [-url URL] [-token TOKEN] <command> [flags]
+
+Commands:
+  list     [-o table|json|csv]                 list all books
+  get      <id> [-o table|json|csv]            show a single book
+  add      -id ID -title T -author A [...]     create a book
+  edit     <id> [-title T] [-author A] [...]   change fields of a book
+  delete   <id>                                delete a book
+  import   [-format json|csv] [-upsert] FILE   create books from a file ("-" for stdin)
+  export   [-format json|csv] [-out FILE]      write all books to a file or stdout
+
+Global flags may also be set through BOOKCTL_URL and BOOKCTL_TOKEN.
+`
+
+var csvHeader = []string{"id", "title", "author", "pages", "edition", "year"}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	global := flag.NewFlagSet("bookctl", flag.ContinueOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	baseURL := global.String("url", getEnv("BOOKCTL_URL", "http://localhost:3030"), "base URL of the bookstore API")
+	token := global.String("token", os.Getenv("BOOKCTL_TOKEN"), "bearer token sent with every request")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	args := global.Args()
+	if len(args) == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	client := NewClient(*baseURL, *token)
+	commands := map[string]func(*Client, []string) error{
+		"list":   runList,
+		"get":    runGet,
+		"add":    runAdd,
+		"edit":   runEdit,
+		"delete": runDelete,
+		"import": runImport,
+		"export": runExport,
+	}
+
+	run, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		global.Usage()
+		os.Exit(2)
+	}
+	if err := run(client, args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseWithID parses a command that takes a single positional ID, accepting
+// the ID either before or after the flags.
+func parseWithID(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return "", fmt.Errorf("%s: missing book ID", fs.Name())
+	}
+	id := rest[0]
+	if err := fs.Parse(rest[1:]); err != nil {
+		return "", err
+	}
+	if fs.NArg() > 0 {
+		return "", fmt.Errorf("%s: unexpected arguments %v", fs.Name(), fs.Args())
+	}
+	return id, nil
+}
+
+func writeBooks(w io.Writer, format string, books []Book) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tAUTHOR\tPAGES\tEDITION\tYEAR")
+		for _, b := range books {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", b.ID, b.Title, b.Author, b.Pages, b.Edition, b.Year)
+		}
+		return tw.Flush()
+	case "json":
+		out := make([]BookResponse, 0, len(books))
+		for _, b := range books {
+			out = append(out, BookResponse(b))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, b := range books {
+			if err := cw.Write([]string{b.ID, b.Title, b.Author, b.Pages, b.Edition, b.Year}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown output format %q (want table, json or csv)", format)
+	}
+}
+
+// importRecord is a book read from an import file together with the set of
+// fields the file actually provided, so upserts can leave the rest untouched.
+type importRecord struct {
+	Book   Book
+	Fields map[string]bool
+}
+
+func readBooks(r io.Reader, format string) ([]importRecord, error) {
+	switch format {
+	case "json":
+		var raw []map[string]json.RawMessage
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		records := make([]importRecord, 0, len(raw))
+		for i, obj := range raw {
+			rec := importRecord{Fields: make(map[string]bool)}
+			for _, name := range csvHeader {
+				value, ok := obj[name]
+				if !ok {
+					continue
+				}
+				var s string
+				if err := json.Unmarshal(value, &s); err != nil {
+					return nil, fmt.Errorf("book %d: field %q must be a string", i+1, name)
+				}
+				rec.Fields[name] = true
+				setField(&rec.Book, name, s)
+			}
+			records = append(records, rec)
+		}
+		return records, nil
+	case "csv":
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV header: %w", err)
+		}
+		columns := make(map[string]int)
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, required := range []string{"id", "title", "author"} {
+			if _, ok := columns[required]; !ok {
+				return nil, fmt.Errorf("CSV header is missing the %q column", required)
+			}
+		}
+
+		var records []importRecord
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			rec := importRecord{Fields: make(map[string]bool)}
+			for _, name := range csvHeader {
+				i, ok := columns[name]
+				if !ok {
+					continue
+				}
+				rec.Fields[name] = true
+				if i < len(record) {
+					setField(&rec.Book, name, strings.TrimSpace(record[i]))
+				}
+			}
+			records = append(records, rec)
+		}
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q (want json or csv)", format)
+	}
+}
+
+func setField(book *Book, name, value string) {
+	switch name {
+	case "id":
+		book.ID = value
+	case "title":
+		book.Title = value
+	case "author":
+		book.Author = value
+	case "pages":
+		book.Pages = value
+	case "edition":
+		book.Edition = value
+	case "year":
+		book.Year = value
+	}
+}
+
+// mergeBook overlays the fields present in rec onto the current record.
+func mergeBook(current Book, rec importRecord) Book {
+	merged := current
+	for _, name := range csvHeader {
+		if name != "id" && rec.Fields[name] {
+			setField(&merged, name, fieldValue(rec.Book, name))
+		}
+	}
+	return merged
+}
+
+func fieldValue(book Book, name string) string {
+	switch name {
+	case "id":
+		return book.ID
+	case "title":
+		return book.Title
+	case "author":
+		return book.Author
+	case "pages":
+		return book.Pages
+	case "edition":
+		return book.Edition
+	case "year":
+		return book.Year
+	}
+	return ""
+}
+
+// formatFromPath picks json or csv from a file extension, defaulting to json.
+func formatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+func runList(client *Client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	output := fs.String("o", "table", "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	books, err := client.ListBooks()
+	if err != nil {
+		return err
+	}
+	return writeBooks(os.Stdout, *output, books)
+}
+
+func runGet(client *Client, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	output := fs.String("o", "table", "output format: table, json or csv")
+	id, err := parseWithID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	book, err := client.GetBook(id)
+	if err != nil {
+		return err
+	}
+	return writeBooks(os.Stdout, *output, []Book{*book})
+}
+
+func runAdd(client *Client, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	var book Book
+	fs.StringVar(&book.ID, "id", "", "book ID (required)")
+	fs.StringVar(&book.Title, "title", "", "title (required)")
+	fs.StringVar(&book.Author, "author", "", "author (required)")
+	fs.StringVar(&book.Pages, "pages", "", "number of pages")
+	fs.StringVar(&book.Edition, "edition", "", "edition / ISBN")
+	fs.StringVar(&book.Year, "year", "", "publication year")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if book.ID == "" || book.Title == "" || book.Author == "" {
+		return errors.New("add: -id, -title and -author are required")
+	}
+
+	if err := client.CreateBook(book); err != nil {
+		return err
+	}
+	fmt.Printf("Created book %s\n", book.ID)
+	return nil
+}
+
+func runEdit(client *Client, args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	title := fs.String("title", "", "new title")
+	author := fs.String("author", "", "new author")
+	pages := fs.String("pages", "", "new number of pages")
+	edition := fs.String("edition", "", "new edition / ISBN")
+	year := fs.String("year", "", "new publication year")
+	id, err := parseWithID(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NFlag() == 0 {
+		return errors.New("edit: nothing to change, pass at least one field flag")
+	}
+
+	// PUT replaces the whole record, so start from the current values and
+	// only overwrite the fields that were passed explicitly.
+	book, err := client.GetBook(id)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			book.Title = *title
+		case "author":
+			book.Author = *author
+		case "pages":
+			book.Pages = *pages
+		case "edition":
+			book.Edition = *edition
+		case "year":
+			book.Year = *year
+		}
+	})
+
+	if err := client.UpdateBook(*book); err != nil {
+		return err
+	}
+	fmt.Printf("Updated book %s\n", id)
+	return nil
+}
+
+func runDelete(client *Client, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	id, err := parseWithID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := client.DeleteBook(id); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted book %s\n", id)
+	return nil
+}
+
+func runImport(client *Client, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "input format: json or csv (default: from file extension)")
+	upsert := fs.Bool("upsert", false, "update books whose ID already exists instead of failing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("import: expected exactly one file argument")
+	}
+
+	path := fs.Arg(0)
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if *format == "" {
+		*format = formatFromPath(path)
+	}
+
+	records, err := readBooks(in, *format)
+	if err != nil {
+		return err
+	}
+
+	var created, updated, failed int
+	for _, rec := range records {
+		wasUpdated, err := importBook(client, rec, *upsert)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "Failed to import book %q: %v\n", rec.Book.ID, err)
+		case wasUpdated:
+			updated++
+		default:
+			created++
+		}
+	}
+
+	fmt.Printf("Imported %d books: %d created, %d updated, %d failed\n", len(records), created, updated, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d books could not be imported", failed)
+	}
+	return nil
+}
+
+// importBook creates the book, or with upsert updates it when it already
+// exists, and reports whether it updated one. books-post answers 409 for any failure, so existence is confirmed
+// with a lookup before falling back to PUT, and only the fields present in
+// the import file are overwritten.
+func importBook(client *Client, rec importRecord, upsert bool) (updated bool, err error) {
+	err = client.CreateBook(rec.Book)
+	var apiErr *APIError
+	if err == nil || !upsert || !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		return false, err
+	}
+
+	current, getErr := client.GetBook(rec.Book.ID)
+	if getErr != nil {
+		if errors.As(getErr, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return false, err
+		}
+		return false, getErr
+	}
+	if err := client.UpdateBook(mergeBook(*current, rec)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func runExport(client *Client, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "output format: json or csv (default: from -out extension, else json)")
+	out := fs.String("out", "-", "output file, \"-\" for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatFromPath(*out)
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("export: unknown format %q (want json or csv)", *format)
+	}
+
+	books, err := client.ListBooks()
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		return writeBooks(os.Stdout, *format, books)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := writeBooks(f, *format, books); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d books to %s\n", len(books), *out)
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseWithID(t *testing.T) {
+	for _, args := range [][]string{
+		{"abc", "-o", "json"},
+		{"-o", "json", "abc"},
+	} {
+		fs := flag.NewFlagSet("get", flag.ContinueOnError)
+		output := fs.String("o", "table", "")
+		id, err := parseWithID(fs, args)
+		if err != nil {
+			t.Fatalf("parseWithID(%v): %v", args, err)
+		}
+		if id != "abc" || *output != "json" {
+			t.Errorf("parseWithID(%v) = %q, -o %q", args, id, *output)
+		}
+	}
+
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	if _, err := parseWithID(fs, nil); err == nil {
+		t.Error("expected error for missing ID")
+	}
+	fs = flag.NewFlagSet("get", flag.ContinueOnError)
+	if _, err := parseWithID(fs, []string{"a", "b"}); err == nil {
+		t.Error("expected error for extra arguments")
+	}
+}
+
+func TestReadBooksCSV(t *testing.T) {
+	in := "Author, ID ,title\nMary Shelley,b1,Frankenstein\n"
+	records, err := readBooks(strings.NewReader(in), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	rec := records[0]
+	want := Book{ID: "b1", Title: "Frankenstein", Author: "Mary Shelley"}
+	if rec.Book != want {
+		t.Errorf("book = %+v, want %+v", rec.Book, want)
+	}
+	if rec.Fields["pages"] || !rec.Fields["author"] {
+		t.Errorf("fields = %v", rec.Fields)
+	}
+
+	if _, err := readBooks(strings.NewReader("id,title\nx,y\n"), "csv"); err == nil {
+		t.Error("expected error for missing author column")
+	}
+}
+
+func TestReadBooksJSON(t *testing.T) {
+	in := `[{"id":"b1","title":"T","author":"A","year":""}]`
+	records, err := readBooks(strings.NewReader(in), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !records[0].Fields["year"] || records[0].Fields["pages"] {
+		t.Errorf("fields = %v", records[0].Fields)
+	}
+	if _, err := readBooks(strings.NewReader(`[{"id":1}]`), "json"); err == nil {
+		t.Error("expected error for non-string field")
+	}
+}
+
+func TestWriteBooksJSONKeepsEmptyFields(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeBooks(&buf, "json", []Book{{ID: "b1", Title: "T", Author: "A"}}); err != nil {
+		t.Fatal(err)
+	}
+	var out []map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range csvHeader {
+		if _, ok := out[0][key]; !ok {
+			t.Errorf("JSON output is missing %q", key)
+		}
+	}
+
+	buf.Reset()
+	if err := writeBooks(&buf, "json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("empty list = %q, want []", buf.String())
+	}
+}
+
+func TestDecodeAPIError(t *testing.T) {
+	resp := &http.Response{StatusCode: 404, Body: io.NopCloser(strings.NewReader(`{"error":"book with ID x not found"}`))}
+	err := decodeAPIError(resp).(*APIError)
+	if err.Status != 404 || err.Message != "book with ID x not found" {
+		t.Errorf("got %+v", err)
+	}
+
+	resp = &http.Response{StatusCode: 502, Body: io.NopCloser(strings.NewReader("Bad Gateway\n"))}
+	err = decodeAPIError(resp).(*APIError)
+	if err.Message != "Bad Gateway" {
+		t.Errorf("got %+v", err)
+	}
+}
+
+// fakeAPI answers POST with 409 for known IDs, mimicking books-post, and
+// records every PUT body.
+type fakeAPI struct {
+	mu    sync.Mutex
+	books map[string]BookResponse
+	puts  []map[string]string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/books" && r.Method == http.MethodGet:
+		list := []BookResponse{}
+		for _, b := range f.books {
+			list = append(list, b)
+		}
+		json.NewEncoder(w).Encode(list)
+	case strings.HasPrefix(r.URL.Path, "/api/books/") && r.Method == http.MethodGet:
+		b, ok := f.books[strings.TrimPrefix(r.URL.Path, "/api/books/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Book not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(b)
+	case r.URL.Path == "/api/books" && r.Method == http.MethodPost:
+		var b Book
+		json.NewDecoder(r.Body).Decode(&b)
+		if _, ok := f.books[b.ID]; ok || b.ID == "broken" {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "something went wrong"})
+			return
+		}
+		f.books[b.ID] = BookResponse(b)
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(r.URL.Path, "/api/books/") && r.Method == http.MethodPut:
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		f.puts = append(f.puts, body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestImportUpsertKeepsMissingColumns(t *testing.T) {
+	api := &fakeAPI{books: map[string]BookResponse{
+		"1": {ID: "1", Title: "Old", Author: "Old", Pages: "100", Edition: "isbn", Year: "1900"},
+	}}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	client := NewClient(srv.URL, "")
+
+	records, err := readBooks(strings.NewReader("id,title,author\n1,X,Y\n"), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := importBook(client, records[0], true); err != nil || !updated {
+		t.Fatalf("importBook = %v, %v, want an update", updated, err)
+	}
+
+	if len(api.puts) != 1 {
+		t.Fatalf("got %d PUTs, want 1", len(api.puts))
+	}
+	want := map[string]string{"id": "1", "title": "X", "author": "Y", "pages": "100", "edition": "isbn", "year": "1900"}
+	for k, v := range want {
+		if api.puts[0][k] != v {
+			t.Errorf("PUT %s = %q, want %q", k, api.puts[0][k], v)
+		}
+	}
+}
+
+func TestImportUpsertDoesNotUpdateOnOtherConflicts(t *testing.T) {
+	api := &fakeAPI{books: map[string]BookResponse{}}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	client := NewClient(srv.URL, "")
+
+	rec := importRecord{Book: Book{ID: "broken", Title: "T", Author: "A"}, Fields: map[string]bool{"id": true, "title": true, "author": true}}
+	updated, err := importBook(client, rec, true)
+	if err == nil || updated {
+		t.Fatalf("importBook = %v, %v, want the create error", updated, err)
+	}
+	if len(api.puts) != 0 {
+		t.Errorf("got %d PUTs, want none", len(api.puts))
+	}
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -41,19 +45,116 @@ func connectToMongoDB() (*mongo.Client, *mongo.Collection, error) {
 	return client, coll, nil
 }
 
-func deleteBook(coll *mongo.Collection, id string) error {
-	filter := bson.M{"id": id}
-	result, err := coll.DeleteOne(context.TODO(), filter)
+// deleteBook marks the book as deleted rather than removing the document,
+// so the record of who deleted it is kept. It returns the book as it was
+// before the delete.
+func deleteBook(ctx context.Context, coll *mongo.Collection, id string, actor string) (bson.M, error) {
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set": bson.M{
+			"deletedby": actor,
+			"deletedat": time.Now().UTC(),
+		},
+	}
+	var before bson.M
+	err := coll.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	return before, err
+}
+
+var errSubjectInUse = errors.New("subject still has child subjects or books")
+
+// deleteSubject removes a subject from the vocabulary once nothing refers
+// to it any more.
+func deleteSubject(ctx context.Context, subjects, books *mongo.Collection, code string) error {
+	children, err := subjects.CountDocuments(ctx, bson.M{"parent": code})
+	if err != nil {
+		return err
+	}
+	tagged, err := books.CountDocuments(ctx, bson.M{"subjects": code, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if children > 0 || tagged > 0 {
+		return errSubjectInUse
+	}
+	result, err := subjects.DeleteOne(ctx, bson.M{"_id": code})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("subject %s not found", code)
+	}
+	return nil
+}
+
+var errPublisherInUse = errors.New("publisher is still referenced by books")
+
+// deletePublisher removes a publisher no book refers to any more.
+func deletePublisher(ctx context.Context, publishers, books *mongo.Collection, code string) error {
+	linked, err := books.CountDocuments(ctx, bson.M{"publisherid": code, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if linked > 0 {
+		return errPublisherInUse
+	}
+	result, err := publishers.DeleteOne(ctx, bson.M{"_id": code})
 	if err != nil {
 		return err
 	}
 	if result.DeletedCount == 0 {
-		return fmt.Errorf("book with ID %s not found", id)
+		return fmt.Errorf("publisher %s not found", code)
 	}
+	return nil
+}
+
+var errWorkInUse = errors.New("work still has editions")
 
+// deleteWork removes a work once none of its editions is left.
+func deleteWork(ctx context.Context, works, books *mongo.Collection, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("work %s not found", id)
+	}
+	editions, err := books.CountDocuments(ctx, bson.M{"workid": id, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if editions > 0 {
+		return errWorkInUse
+	}
+	result, err := works.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("work %s not found", id)
+	}
 	return nil
 }
 
+func writeError(c echo.Context, err error) error {
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	if err == errSubjectInUse || err == errPublisherInUse || err == errWorkInUse {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if strings.HasSuffix(err.Error(), "not found") {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
 func main() {
 	// Wait for MongoDB to be ready
 	fmt.Println("Waiting for MongoDB to be ready...")
@@ -84,14 +185,40 @@ func main() {
 		}
 	}()
 
+	keys := newKeyStore(collection(client, "apikeys"))
+	audit := &auditLog{coll: collection(client, "audit")}
+	subjects := collection(client, "subjects")
+	publishers := collection(client, "publishers")
+	works := collection(client, "works")
+	revisions := &revisionLog{coll: collection(client, "revisions")}
+	if client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := revisions.ensureIndexes(ctx); err != nil {
+			fmt.Printf("Warning: Failed to create revision indexes: %v\n", err)
+		}
+		cancel()
+	}
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
 	e := echo.New()
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
 
 	e.DELETE("/api/books/:id", func(c echo.Context) error {
 		id := c.Param("id")
 
-		if err := deleteBook(coll, id); err != nil {
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		before, err := deleteBook(ctx, coll, id, actor(c))
+		if err != nil {
+			if isTimeout(err) {
+				return dbTimeoutProblem(c)
+			}
 			if err.Error() == fmt.Sprintf("book with ID %s not found", id) {
 				return c.JSON(http.StatusNotFound, map[string]string{
 					"error": err.Error(),
@@ -102,10 +229,82 @@ func main() {
 			})
 		}
 
+		audit.record(c, AuditDelete, id, before, nil)
+		revisions.save(c, AuditDelete, id, before, before, true)
+
 		return c.JSON(http.StatusOK, map[string]string{
 			"message": "Book deleted successfully",
 		})
-	})
+	}, authorize(auth, keys, RoleAdmin))
+
+	e.DELETE("/api/books/:id/tags/:tag", func(c echo.Context) error {
+		id := c.Param("id")
+
+		ctx, cancel := dbContext(c.Request().Context(), "tag")
+		defer cancel()
+		before, after, err := updateBookList(ctx, coll, id, "tags", normalizeTag(c.Param("tag")), false, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message": "Tag removed successfully",
+			"tags":    after["tags"],
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.DELETE("/api/books/:id/subjects/:subject", func(c echo.Context) error {
+		id := c.Param("id")
+
+		ctx, cancel := dbContext(c.Request().Context(), "tag")
+		defer cancel()
+		before, after, err := updateBookList(ctx, coll, id, "subjects", normalizeSubjectCode(c.Param("subject")), false, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":  "Subject removed successfully",
+			"subjects": after["subjects"],
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.DELETE("/api/subjects/:code", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		if err := deleteSubject(ctx, subjects, coll, normalizeSubjectCode(c.Param("code"))); err != nil {
+			return writeError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Subject deleted successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.DELETE("/api/works/:id", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		if err := deleteWork(ctx, works, coll, c.Param("id")); err != nil {
+			return writeError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Work deleted successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.DELETE("/api/publishers/:code", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		if err := deletePublisher(ctx, publishers, coll, normalizePublisherCode(c.Param("code"))); err != nil {
+			return writeError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Publisher deleted successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
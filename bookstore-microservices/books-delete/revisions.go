@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevisionBaseline marks the first revision kept for a book that existed
+// before revisions were recorded.
+const RevisionBaseline = "baseline"
+
+// Revision is a full copy of a book as it was after one write. Revisions
+// are numbered from 1 per book.
+type Revision struct {
+	BookID    string    `bson:"bookid"`
+	Revision  int64     `bson:"revision"`
+	Action    string    `bson:"action"`
+	Actor     string    `bson:"actor"`
+	Timestamp time.Time `bson:"timestamp"`
+	Deleted   bool      `bson:"deleted,omitempty"`
+	Book      bson.M    `bson:"book"`
+}
+
+type revisionLog struct {
+	coll *mongo.Collection
+}
+
+func (r *revisionLog) ensureIndexes(ctx context.Context) error {
+	_, err := r.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "bookid", Value: 1}, {Key: "revision", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *revisionLog) latest(ctx context.Context, bookID string) (int64, error) {
+	var last Revision
+	opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: -1}})
+	err := r.coll.FindOne(ctx, bson.M{"bookid": bookID}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Revision, err
+}
+
+func (r *revisionLog) insert(ctx context.Context, rev Revision) error {
+	// Two writers can pick the same number; the unique index rejects the
+	// second, which then tries the next one.
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var last int64
+		last, err = r.latest(ctx, rev.BookID)
+		if err != nil {
+			return err
+		}
+		rev.Revision = last + 1
+		_, err = r.coll.InsertOne(ctx, rev)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// snapshotTime is when doc was last written, for baselines.
+func snapshotTime(doc bson.M) time.Time {
+	for _, field := range []string{"updatedat", "createdat"} {
+		if t, ok := doc[field].(primitive.DateTime); ok {
+			return t.Time().UTC()
+		}
+		if t, ok := doc[field].(time.Time); ok {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// save stores the book as it is after a write. When the book has no
+// revisions yet and before is known, before is kept first as a baseline
+// so the history also covers the state prior to this write. Like audit
+// entries, failures are logged rather than failing the request.
+func (r *revisionLog) save(c echo.Context, action, bookID string, before, after interface{}, deleted bool) {
+	ctx, cancel := dbContext(context.WithoutCancel(c.Request().Context()), "revision")
+	defer cancel()
+
+	err := func() error {
+		beforeDoc, err := toDocument(before)
+		if err != nil {
+			return err
+		}
+		afterDoc, err := toDocument(after)
+		if err != nil {
+			return err
+		}
+		if beforeDoc != nil {
+			last, err := r.latest(ctx, bookID)
+			if err != nil {
+				return err
+			}
+			if last == 0 {
+				err = r.insert(ctx, Revision{
+					BookID:    bookID,
+					Action:    RevisionBaseline,
+					Actor:     "unknown",
+					Timestamp: snapshotTime(beforeDoc),
+					Book:      snapshotDocument(beforeDoc),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return r.insert(ctx, Revision{
+			BookID:    bookID,
+			Action:    action,
+			Actor:     actor(c),
+			Timestamp: time.Now().UTC(),
+			Deleted:   deleted,
+			Book:      snapshotDocument(afterDoc),
+		})
+	}()
+	if err != nil {
+		fmt.Printf("Error saving revision for %s of book %s: %v\n", action, bookID, err)
+	}
+}
+
+// snapshotDocument drops the storage-specific fields from a copy of a book.
+func snapshotDocument(doc bson.M) bson.M {
+	out := bson.M{}
+	for k, v := range doc {
+		if k == "_id" || k == "deletedat" || k == "deletedby" {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Subject is an entry of the controlled subject vocabulary. Subjects form a
+// tree through Parent.
+type Subject struct {
+	Code      string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	Parent    string    `bson:"parent,omitempty"`
+	CreatedBy string    `bson:"createdby,omitempty"`
+	CreatedAt time.Time `bson:"createdat"`
+	UpdatedBy string    `bson:"updatedby,omitempty"`
+	UpdatedAt time.Time `bson:"updatedat,omitempty"`
+}
+
+var errUnknownSubject = errors.New("unknown subject")
+
+// normalizeTag lower-cases a free-form tag and tidies its whitespace.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// normalizeSubjectCode turns a subject code into its canonical form, e.g.
+// "Science Fiction" into "science-fiction".
+func normalizeSubjectCode(code string) string {
+	return strings.Join(strings.Fields(strings.ToLower(code)), "-")
+}
+
+// normalizeList applies normalize to every value, dropping empty values and
+// duplicates. The result is never nil.
+func normalizeList(values []string, normalize func(string) string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		v = normalize(v)
+		if v != "" && !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// checkSubjects verifies that every code is part of the vocabulary.
+func checkSubjects(ctx context.Context, subjects *mongo.Collection, codes []string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	count, err := subjects.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": codes}})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(codes) {
+		return fmt.Errorf("%w in %s", errUnknownSubject, strings.Join(codes, ", "))
+	}
+	return nil
+}
+
+// stringList converts an array field of a decoded document to strings.
+func stringList(v interface{}) []string {
+	result := []string{}
+	if values, ok := v.(primitive.A); ok {
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// updateBookList adds value to, or removes it from, the array field of a
+// book. It returns the book before and after the change.
+func updateBookList(ctx context.Context, coll *mongo.Collection, id, field, value string, add bool, actor string) (bson.M, bson.M, error) {
+	op := "$pull"
+	if add {
+		op = "$addToSet"
+	}
+	update := bson.M{
+		op:     bson.M{field: value},
+		"$set": bson.M{"updatedby": actor, "updatedat": time.Now().UTC()},
+	}
+
+	var before bson.M
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": false}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list := primitive.A{}
+	present := false
+	for _, v := range stringList(before[field]) {
+		if v == value {
+			present = true
+			if !add {
+				continue
+			}
+		}
+		list = append(list, v)
+	}
+	if add && !present {
+		list = append(list, value)
+	}
+	after := applySet(before, update["$set"].(bson.M))
+	after[field] = list
+	return before, after, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+type AuditEntry struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    string             `bson:"bookid"`
+	Action    string             `bson:"action"`
+	Actor     string             `bson:"actor"`
+	RequestID string             `bson:"requestid,omitempty"`
+	Timestamp time.Time          `bson:"timestamp"`
+	Changes   []FieldChange      `bson:"changes,omitempty"`
+}
+
+type AuditResponse struct {
+	ID        string        `json:"id"`
+	BookID    string        `json:"bookId"`
+	Action    string        `json:"action"`
+	Actor     string        `json:"actor"`
+	RequestID string        `json:"requestId,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// pagination reads limit and offset from the query string.
+func pagination(c echo.Context, defaultLimit, maxLimit int64) (int64, int64) {
+	limit, err := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// auditFilter builds the audit query from bookId, actor, action and the
+// RFC 3339 from/to bounds of the time range.
+func auditFilter(c echo.Context) (bson.M, error) {
+	filter := bson.M{}
+	if bookID := strings.TrimSpace(c.QueryParam("bookId")); bookID != "" {
+		filter["bookid"] = bookID
+	}
+	if actor := strings.TrimSpace(c.QueryParam("actor")); actor != "" {
+		filter["actor"] = actor
+	}
+	if action := strings.TrimSpace(c.QueryParam("action")); action != "" {
+		filter["action"] = action
+	}
+
+	timeRange := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, err
+		}
+		timeRange[op] = t
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+	return filter, nil
+}
+
+func getAuditEntries(c echo.Context, coll *mongo.Collection) error {
+	filter, err := auditFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "from and to must be RFC 3339 timestamps",
+		})
+	}
+	limit, offset := pagination(c, 100, 1000)
+
+	ctx, cancel := dbContext(c.Request().Context(), "audit")
+	defer cancel()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		if isTimeout(err) {
+			return dbTimeoutProblem(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch audit entries",
+		})
+	}
+
+	var entries []AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		if isTimeout(err) {
+			return dbTimeoutProblem(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to decode audit entries",
+		})
+	}
+
+	ret := []AuditResponse{}
+	for _, entry := range entries {
+		changes := entry.Changes
+		if changes == nil {
+			changes = []FieldChange{}
+		}
+		ret = append(ret, AuditResponse{
+			ID:        entry.MongoID.Hex(),
+			BookID:    entry.BookID,
+			Action:    entry.Action,
+			Actor:     entry.Actor,
+			RequestID: entry.RequestID,
+			Timestamp: entry.Timestamp,
+			Changes:   changes,
+		})
+	}
+	return c.JSON(http.StatusOK, ret)
+}
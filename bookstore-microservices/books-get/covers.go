@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cover describes the cover image of a book. books-post and books-put keep
+// the image and its thumbnails in the "covers" GridFS bucket, tagged with
+// the book ID and the ETag.
+type Cover struct {
+	ETag        string    `bson:"etag"`
+	ContentType string    `bson:"contenttype"`
+	Width       int       `bson:"width"`
+	Height      int       `bson:"height"`
+	Sizes       []string  `bson:"sizes"`
+	UploadedAt  time.Time `bson:"uploadedat"`
+}
+
+const (
+	coverBucketName = "covers"
+	CoverOriginal   = "original"
+)
+
+// coverSizeNames are the sizes that can be asked for; covers that are
+// narrower than a size are served as they were uploaded instead.
+var coverSizeNames = map[string]bool{"large": true, "medium": true, "small": true, CoverOriginal: true}
+
+var errUnknownCoverSize = errors.New("size must be small, medium, large or original")
+
+// coverURL is where the cover of a book is served. The ETag in the URL
+// changes with the cover, so responses to it can be cached for good.
+func coverURL(bookID string, cover *Cover) string {
+	if cover == nil {
+		return ""
+	}
+	return "/api/books/" + url.PathEscape(bookID) + "/cover?v=" + url.QueryEscape(cover.ETag)
+}
+
+// coverSize picks the file to serve for the requested size.
+func coverSize(cover *Cover, size string) (string, error) {
+	if size == "" {
+		return CoverOriginal, nil
+	}
+	if !coverSizeNames[size] {
+		return "", errUnknownCoverSize
+	}
+	for _, s := range cover.Sizes {
+		if s == size {
+			return size, nil
+		}
+	}
+	return CoverOriginal, nil
+}
+
+// coverCacheControl lets clients keep a cover for a day, or for good when
+// they asked for it by its current ETag.
+func coverCacheControl(cover *Cover, version string) string {
+	if version != "" && version == cover.ETag {
+		return "public, max-age=31536000, immutable"
+	}
+	return "public, max-age=86400"
+}
+
+// coverBucket opens the covers bucket for one request. GridFS takes
+// deadlines rather than contexts, so the bucket gets the deadline of ctx.
+func coverBucket(ctx context.Context, db *mongo.Database) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(coverBucketName))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// getCover serves the cover of a book at ?size= (small, medium, large or
+// the original). Requests carrying the current ETag in If-None-Match get
+// 304 Not Modified.
+func getCover(c echo.Context, coll *mongo.Collection) error {
+	id := c.Param("id")
+	ctx, cancel := dbContext(c.Request().Context(), "cover")
+	defer cancel()
+
+	var book BookStore
+	err := coll.FindOne(ctx, bson.M{"id": id, "deletedat": bson.M{"$exists": false}},
+		options.FindOne().SetProjection(bson.M{"id": 1, "cover": 1})).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book not found",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+	if book.Cover == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book has no cover",
+		})
+	}
+	size, err := coverSize(book.Cover, c.QueryParam("size"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	etag := strconv.Quote(book.Cover.ETag + "-" + size)
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", coverCacheControl(book.Cover, c.QueryParam("v")))
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	bucket, err := coverBucket(ctx, coll.Database())
+	if err != nil {
+		return filterError(c, err)
+	}
+	var file struct {
+		ID       interface{} `bson:"_id"`
+		Length   int64       `bson:"length"`
+		Metadata struct {
+			ContentType string `bson:"contenttype"`
+		} `bson:"metadata"`
+	}
+	err = bucket.GetFilesCollection().FindOne(ctx, bson.M{
+		"metadata.bookid": id,
+		"metadata.etag":   book.Cover.ETag,
+		"metadata.size":   size,
+	}).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book has no cover",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+	download, err := bucket.OpenDownloadStream(file.ID)
+	if err != nil {
+		return filterError(c, err)
+	}
+	defer download.Close()
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(file.Length, 10))
+	return c.Stream(http.StatusOK, file.Metadata.ContentType, download)
+}
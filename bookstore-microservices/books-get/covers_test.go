@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestCoverSize(t *testing.T) {
+	cover := &Cover{ETag: "abc", Sizes: []string{"medium", "small"}}
+	for size, want := range map[string]string{
+		"":         CoverOriginal,
+		"small":    "small",
+		"large":    CoverOriginal,
+		"original": CoverOriginal,
+	} {
+		if got, err := coverSize(cover, size); err != nil || got != want {
+			t.Errorf("coverSize(%q) = %q, %v; want %q", size, got, err, want)
+		}
+	}
+	if _, err := coverSize(cover, "huge"); err != errUnknownCoverSize {
+		t.Errorf("unknown sizes are refused, got %v", err)
+	}
+}
+
+func TestCoverURLAndCaching(t *testing.T) {
+	cover := &Cover{ETag: "abc"}
+	if got := coverURL("b 1", cover); got != "/api/books/b%201/cover?v=abc" {
+		t.Errorf("coverURL() = %q", got)
+	}
+	if got := coverURL("b1", nil); got != "" {
+		t.Errorf("books without a cover have no URL, got %q", got)
+	}
+	if got := coverCacheControl(cover, "abc"); got != "public, max-age=31536000, immutable" {
+		t.Errorf("a versioned URL is cached for good, got %q", got)
+	}
+	if got := coverCacheControl(cover, "old"); got != "public, max-age=86400" {
+		t.Errorf("other requests are cached for a day, got %q", got)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	for header, want := range map[string]bool{
+		`"abc-small"`:        true,
+		`"x", W/"abc-small"`: true,
+		`*`:                  true,
+		`"abc-medium"`:       false,
+		``:                   false,
+	} {
+		if got := etagMatches(header, `"abc-small"`); got != want {
+			t.Errorf("etagMatches(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
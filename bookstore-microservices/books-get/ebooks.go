@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ebook is a DRM-free file of a book, at most one per format. books-post
+// and books-put keep the file in the "ebooks" GridFS bucket.
+type Ebook struct {
+	Format     string    `bson:"format"`
+	ETag       string    `bson:"etag"`
+	Filename   string    `bson:"filename"`
+	Bytes      int64     `bson:"bytes"`
+	UploadedAt time.Time `bson:"uploadedat"`
+}
+
+// EbookResponse lists a file of a book; URL only serves it to staff and to
+// customers who bought the book.
+type EbookResponse struct {
+	Format   string `json:"format"`
+	Filename string `json:"filename"`
+	Bytes    int64  `json:"bytes"`
+	URL      string `json:"url"`
+}
+
+const ebookBucketName = "ebooks"
+
+var ebookContentTypes = map[string]string{
+	"epub": "application/epub+zip",
+	"pdf":  "application/pdf",
+}
+
+func toEbookResponses(bookID string, ebooks []Ebook) []EbookResponse {
+	ret := []EbookResponse{}
+	for _, ebook := range ebooks {
+		ret = append(ret, EbookResponse{
+			Format:   ebook.Format,
+			Filename: ebook.Filename,
+			Bytes:    ebook.Bytes,
+			URL:      "/api/books/" + url.PathEscape(bookID) + "/ebooks/" + ebook.Format,
+		})
+	}
+	return ret
+}
+
+// mayDownload reports whether the caller may download the e-books of a
+// book: editors and admins always, readers once they have a paid or
+// shipped order with the book on it.
+func mayDownload(ctx context.Context, orders *mongo.Collection, principal *Principal, bookID string) (bool, error) {
+	if principal.HasRole(RoleEditor) {
+		return true, nil
+	}
+	count, err := orders.CountDocuments(ctx, bson.M{
+		"userid":       principal.Subject,
+		"items.bookid": bookID,
+		"status":       bson.M{"$in": bson.A{"paid", "shipped"}},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// getEbook sends the e-book of a book in a format to a caller who may
+// download it.
+func getEbook(c echo.Context, coll, orders *mongo.Collection) error {
+	id, format := c.Param("id"), c.Param("format")
+	ctx, cancel := dbContext(c.Request().Context(), "ebook")
+	defer cancel()
+
+	var book BookStore
+	err := coll.FindOne(ctx, bson.M{"id": id, "deletedat": bson.M{"$exists": false}},
+		options.FindOne().SetProjection(bson.M{"id": 1, "ebooks": 1})).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book not found",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+	var ebook *Ebook
+	for i := range book.Ebooks {
+		if book.Ebooks[i].Format == format {
+			ebook = &book.Ebooks[i]
+		}
+	}
+	if ebook == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book has no e-book in this format",
+		})
+	}
+
+	allowed, err := mayDownload(ctx, orders, c.Get("principal").(*Principal), id)
+	if err != nil {
+		return filterError(c, err)
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "Only customers who bought this book can download it",
+		})
+	}
+
+	bucket, err := gridfs.NewBucket(coll.Database(), options.GridFSBucket().SetName(ebookBucketName))
+	if err != nil {
+		return filterError(c, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+	}
+	var file struct {
+		ID     interface{} `bson:"_id"`
+		Length int64       `bson:"length"`
+	}
+	err = bucket.GetFilesCollection().FindOne(ctx, bson.M{
+		"metadata.bookid": id,
+		"metadata.format": format,
+		"metadata.etag":   ebook.ETag,
+	}).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book has no e-book in this format",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+	download, err := bucket.OpenDownloadStream(file.ID)
+	if err != nil {
+		return filterError(c, err)
+	}
+	defer download.Close()
+
+	header := c.Response().Header()
+	header.Set("ETag", strconv.Quote(ebook.ETag))
+	header.Set("Cache-Control", "private, no-store")
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": ebook.Filename}))
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(file.Length, 10))
+	return c.Stream(http.StatusOK, ebookContentTypes[format], download)
+}
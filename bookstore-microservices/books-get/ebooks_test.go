@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToEbookResponses(t *testing.T) {
+	if got := toEbookResponses("b1", nil); got == nil || len(got) != 0 {
+		t.Errorf("a book without e-books lists none, got %#v", got)
+	}
+	got := toEbookResponses("a b", []Ebook{
+		{Format: "epub", Filename: "book.epub", Bytes: 1024},
+		{Format: "pdf", Filename: "book.pdf", Bytes: 2048},
+	})
+	want := []EbookResponse{
+		{Format: "epub", Filename: "book.epub", Bytes: 1024, URL: "/api/books/a%20b/ebooks/epub"},
+		{Format: "pdf", Filename: "book.pdf", Bytes: 2048, URL: "/api/books/a%20b/ebooks/pdf"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toEbookResponses() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var exportHeader = []string{"id", "title", "author", "pages", "edition", "year"}
+
+// rowWriter is implemented by each export format.
+type rowWriter interface {
+	WriteBook(book BookResponse) error
+	Close() error
+}
+
+type csvExport struct {
+	w *csv.Writer
+}
+
+func newCSVExport(w io.Writer) (rowWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return nil, err
+	}
+	return &csvExport{w: cw}, nil
+}
+
+func (e *csvExport) WriteBook(b BookResponse) error {
+	return e.w.Write([]string{b.ID, b.Title, b.Author, b.Pages, b.Edition, b.Year})
+}
+
+func (e *csvExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func newNDJSONExport(w io.Writer) (rowWriter, error) {
+	return &ndjsonExport{enc: json.NewEncoder(w)}, nil
+}
+
+func (e *ndjsonExport) WriteBook(b BookResponse) error {
+	return e.enc.Encode(b)
+}
+
+func (e *ndjsonExport) Close() error {
+	return nil
+}
+
+type xlsxExport struct {
+	w *xlsxWriter
+}
+
+func newXLSXExport(w io.Writer) (rowWriter, error) {
+	xw, err := newXLSXWriter(w, "Books")
+	if err != nil {
+		return nil, err
+	}
+	if err := xw.WriteRow(exportHeader); err != nil {
+		return nil, err
+	}
+	return &xlsxExport{w: xw}, nil
+}
+
+func (e *xlsxExport) WriteBook(b BookResponse) error {
+	return e.w.WriteRow([]string{b.ID, b.Title, b.Author, b.Pages, b.Edition, b.Year})
+}
+
+func (e *xlsxExport) Close() error {
+	return e.w.Close()
+}
+
+type exportFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(io.Writer) (rowWriter, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {"text/csv; charset=utf-8", "csv", newCSVExport},
+	"ndjson": {"application/x-ndjson", "ndjson", newNDJSONExport},
+	"xlsx":   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", newXLSXExport},
+}
+
+// exportBooks streams the filtered catalog in the requested format. Books
+// are decoded one at a time from the cursor so memory does not grow with
+// the size of the collection.
+func exportBooks(c echo.Context, coll *mongo.Collection, filter bson.M, sort bson.D) error {
+	name := c.QueryParam("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "format must be one of csv, ndjson or xlsx",
+		})
+	}
+
+	// As for the list, the deadline only covers the query and its first
+	// batch.
+	ctx := c.Request().Context()
+	queryCtx, cancel := dbContext(ctx, "export")
+	defer cancel()
+	cursor, err := findBooks(queryCtx, coll, filter, sort)
+	if err != nil {
+		fmt.Printf("Error finding books for export: %v\n", err)
+		if isTimeout(err) {
+			return dbTimeoutProblem(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to export books",
+		})
+	}
+	defer cursor.Close(context.Background())
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, format.contentType)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"books.%s\"", format.extension))
+	res.WriteHeader(http.StatusOK)
+
+	// Failures after the header went out abort the connection, so that a
+	// client does not take a truncated export for a complete one.
+	out, err := format.newWriter(res)
+	if err != nil {
+		fmt.Printf("Error starting %s export: %v\n", name, err)
+		panic(http.ErrAbortHandler)
+	}
+	for cursor.Next(ctx) {
+		var book BookStore
+		if err := cursor.Decode(&book); err != nil {
+			fmt.Printf("Error decoding book during export: %v\n", err)
+			continue
+		}
+		if err := out.WriteBook(toBookResponse(book)); err != nil {
+			fmt.Printf("Error writing %s export: %v\n", name, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		fmt.Printf("Error iterating books during export: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Printf("Error finishing %s export: %v\n", name, err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
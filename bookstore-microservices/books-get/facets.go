@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// facetSize is how many values the tag and author facets list.
+const facetSize = 20
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int64  `json:"count"`
+}
+
+type Facets struct {
+	Tags    []FacetValue `json:"tags"`
+	Authors []FacetValue `json:"authors"`
+	Decades []FacetValue `json:"decades"`
+}
+
+type FacetedBooks struct {
+	Books  []BookResponse `json:"books"`
+	Total  int64          `json:"total"`
+	Facets Facets         `json:"facets"`
+}
+
+// facetPipeline pages through the books matching filter and, in the same
+// pass, counts them by tag, author and decade.
+func facetPipeline(filter bson.M, limit, offset int64) mongo.Pipeline {
+	countBy := func(field interface{}) bson.D {
+		return bson.D{{Key: "$group", Value: bson.M{"_id": field, "count": bson.M{"$sum": 1}}}}
+	}
+	byCount := bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}}
+	top := bson.D{{Key: "$limit", Value: facetSize}}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"books": append([]bson.D{{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}}}, paginate(limit, offset)...),
+			"total": bson.A{bson.D{{Key: "$count", Value: "count"}}},
+			"tags": bson.A{
+				bson.D{{Key: "$unwind", Value: "$tags"}},
+				countBy("$tags"), byCount, top,
+			},
+			"authors": bson.A{
+				bson.D{{Key: "$unwind", Value: "$authorids"}},
+				countBy("$authorids"), byCount, top,
+				bson.D{{Key: "$addFields", Value: bson.M{
+					"authoroid": bson.M{"$convert": bson.M{"input": "$_id", "to": "objectId", "onError": nil}},
+				}}},
+				bson.D{{Key: "$lookup", Value: bson.M{
+					"from":         "authors",
+					"localField":   "authoroid",
+					"foreignField": "_id",
+					"as":           "author",
+				}}},
+				bson.D{{Key: "$addFields", Value: bson.M{"label": bson.M{"$first": "$author.name"}}}},
+			},
+			"decades": bson.A{
+				bson.D{{Key: "$project", Value: bson.M{"year": asInt("bookyear")}}},
+				bson.D{{Key: "$match", Value: bson.M{"year": bson.M{"$ne": nil}}}},
+				countBy(bson.M{"$multiply": bson.A{bson.M{"$floor": bson.M{"$divide": bson.A{"$year", 10}}}, 10}}),
+				bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			},
+		}}},
+	}
+}
+
+type facetRow struct {
+	Value interface{} `bson:"_id"`
+	Label string      `bson:"label"`
+	Count int64       `bson:"count"`
+}
+
+func toFacetValues(rows []facetRow) []FacetValue {
+	values := []FacetValue{}
+	for _, row := range rows {
+		value := ""
+		switch v := row.Value.(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatInt(int64(v), 10)
+		case int32:
+			value = strconv.FormatInt(int64(v), 10)
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		}
+		values = append(values, FacetValue{Value: value, Label: row.Label, Count: row.Count})
+	}
+	return values
+}
+
+// getFacetedBooks answers a list request with facets=true: one page of the
+// matching books together with their total and facet counts.
+func getFacetedBooks(c echo.Context, coll *mongo.Collection, filter bson.M) error {
+	limit, offset := pagination(c, 50, 1000)
+
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	cursor, err := coll.Aggregate(ctx, facetPipeline(filter, limit, offset))
+	if err != nil {
+		return filterError(c, err)
+	}
+	var results []struct {
+		Books []BookStore `bson:"books"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Tags    []facetRow `bson:"tags"`
+		Authors []facetRow `bson:"authors"`
+		Decades []facetRow `bson:"decades"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return filterError(c, err)
+	}
+
+	ret := FacetedBooks{Books: []BookResponse{}, Facets: Facets{Tags: []FacetValue{}, Authors: []FacetValue{}, Decades: []FacetValue{}}}
+	if len(results) > 0 {
+		result := results[0]
+		for _, book := range result.Books {
+			ret.Books = append(ret.Books, toBookResponse(book))
+		}
+		if len(result.Total) > 0 {
+			ret.Total = result.Total[0].Count
+		}
+		ret.Facets = Facets{
+			Tags:    toFacetValues(result.Tags),
+			Authors: toFacetValues(result.Authors),
+			Decades: toFacetValues(result.Decades),
+		}
+	}
+	return c.JSON(http.StatusOK, ret)
+}
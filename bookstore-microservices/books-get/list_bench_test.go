@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const benchDocuments = 100000
+
+// benchCollection connects to BENCH_MONGODB_URI and makes sure a scratch
+// collection holds benchDocuments books. The benchmarks are skipped when no
+// database is configured.
+//
+//	BENCH_MONGODB_URI=mongodb://localhost:27017 go test -run '^$' -bench List -benchmem
+func benchCollection(b *testing.B) *mongo.Collection {
+	uri := os.Getenv("BENCH_MONGODB_URI")
+	if uri == "" {
+		b.Skip("BENCH_MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { client.Disconnect(ctx) })
+
+	coll := client.Database("exercise-1-bench").Collection("information")
+	count, err := coll.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if count == benchDocuments {
+		return coll
+	}
+
+	if err := coll.Drop(ctx); err != nil {
+		b.Fatal(err)
+	}
+	docs := make([]interface{}, 0, 1000)
+	for i := 0; i < benchDocuments; i++ {
+		docs = append(docs, BookStore{
+			ID:          fmt.Sprintf("bench%06d", i),
+			BookName:    fmt.Sprintf("Benchmark Book %d", i),
+			BookAuthor:  fmt.Sprintf("Author %d", i%500),
+			BookEdition: "978-3-16-148410-0",
+			BookPages:   "320",
+			BookYear:    fmt.Sprintf("%d", 1800+i%220),
+		})
+		if len(docs) == cap(docs) {
+			if _, err := coll.InsertMany(ctx, docs); err != nil {
+				b.Fatal(err)
+			}
+			docs = docs[:0]
+		}
+	}
+	return coll
+}
+
+// loadAllBooks is the previous list implementation: decode everything with
+// cursor.All, copy into responses and marshal the whole slice.
+func loadAllBooks(ctx context.Context, coll *mongo.Collection, w io.Writer) error {
+	cursor, err := coll.Find(ctx, bson.D{{}})
+	if err != nil {
+		return err
+	}
+	var results []BookStore
+	if err := cursor.All(ctx, &results); err != nil {
+		return err
+	}
+	var ret []BookResponse
+	for _, res := range results {
+		ret = append(ret, toBookResponse(res))
+	}
+	return json.NewEncoder(w).Encode(ret)
+}
+
+func BenchmarkListCursorAll(b *testing.B) {
+	coll := benchCollection(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := loadAllBooks(context.Background(), coll, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListStreaming(b *testing.B) {
+	coll := benchCollection(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := context.Background()
+		cursor, err := findBooks(ctx, coll, bson.M{}, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := streamBooks(ctx, cursor, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+		cursor.Close(ctx)
+	}
+}
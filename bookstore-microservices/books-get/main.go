@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -23,15 +29,54 @@ type BookStore struct {
 	BookEdition string             `bson:"bookedition"`
 	BookPages   string             `bson:"bookpages"`
 	BookYear    string             `bson:"bookyear"`
+	AuthorIDs   []string           `bson:"authorids,omitempty"`
+	Tags        []string           `bson:"tags,omitempty"`
+	Subjects    []string           `bson:"subjects,omitempty"`
+	PublisherID string             `bson:"publisherid,omitempty"`
+	Series      string             `bson:"series,omitempty"`
+	SeriesPos   *float64           `bson:"seriesposition,omitempty"`
+	WorkID      string             `bson:"workid,omitempty"`
+	Format      string             `bson:"format,omitempty"`
+	Language    string             `bson:"language,omitempty"`
+	Price       *Money             `bson:"price,omitempty"`
+	Discounts   []Discount         `bson:"discounts,omitempty"`
+	Rating      *Rating            `bson:"rating,omitempty"`
+	Cover       *Cover             `bson:"cover,omitempty"`
+	Ebooks      []Ebook            `bson:"ebooks,omitempty"`
+	CreatedBy   string             `bson:"createdby,omitempty"`
+	CreatedAt   time.Time          `bson:"createdat,omitempty"`
+	UpdatedBy   string             `bson:"updatedby,omitempty"`
+	UpdatedAt   time.Time          `bson:"updatedat,omitempty"`
+	DeletedBy   string             `bson:"deletedby,omitempty"`
+	DeletedAt   *time.Time         `bson:"deletedat,omitempty"`
 }
 
+// BookResponse is a book flattened with its work: every book is an edition,
+// and Edition still carries the ISBN as it did before works existed.
+// EffectivePrice is the price at the time of the request, after Discount.
 type BookResponse struct {
-	ID      string `json:"id"`
-	Title   string `json:"title"`
-	Author  string `json:"author"`
-	Pages   string `json:"pages"`
-	Edition string `json:"edition"`
-	Year    string `json:"year"`
+	ID             string          `json:"id"`
+	Title          string          `json:"title"`
+	Author         string          `json:"author"`
+	AuthorIDs      []string        `json:"authorIds"`
+	Pages          string          `json:"pages"`
+	Edition        string          `json:"edition"`
+	Year           string          `json:"year"`
+	Tags           []string        `json:"tags"`
+	Subjects       []string        `json:"subjects"`
+	PublisherID    string          `json:"publisherId"`
+	Series         string          `json:"series"`
+	SeriesPosition *float64        `json:"seriesPosition"`
+	WorkID         string          `json:"workId"`
+	Format         string          `json:"format"`
+	Language       string          `json:"language"`
+	Price          *Money          `json:"price"`
+	EffectivePrice *Money          `json:"effectivePrice"`
+	Discount       *Discount       `json:"discount"`
+	Discounts      []Discount      `json:"discounts"`
+	Rating         Rating          `json:"rating"`
+	CoverURL       string          `json:"coverUrl"`
+	Ebooks         []EbookResponse `json:"ebooks"`
 }
 
 func getMongoURI() string {
@@ -62,32 +107,216 @@ func connectToMongoDB() (*mongo.Client, *mongo.Collection, error) {
 	return client, coll, nil
 }
 
-func getAllBooksAPI(coll *mongo.Collection) []BookResponse {
-	cursor, err := coll.Find(context.TODO(), bson.D{{}})
+// bookFilter builds the Mongo filter shared by the list and export endpoints
+// from the query string: exact author, author ID, publisher, series, work,
+// format, language and year matches, books of a decade, books carrying
+// every given tag, and case-insensitive substring matches on title or, with
+// q, on title and author. Deleted books are always excluded. Subjects need
+// the vocabulary and are added by catalogFilter.
+func bookFilter(c echo.Context) bson.M {
+	filter := bson.M{"deletedat": bson.M{"$exists": false}}
+	if author := strings.TrimSpace(c.QueryParam("author")); author != "" {
+		filter["bookauthor"] = author
+	}
+	if authorID := strings.TrimSpace(c.QueryParam("authorId")); authorID != "" {
+		filter["authorids"] = authorID
+	}
+	if publisherID := strings.TrimSpace(c.QueryParam("publisherId")); publisherID != "" {
+		filter["publisherid"] = publisherID
+	}
+	if series := normalizeSeries(c.QueryParam("series")); series != "" {
+		filter["series"] = series
+	}
+	if workID := strings.TrimSpace(c.QueryParam("workId")); workID != "" {
+		filter["workid"] = workID
+	}
+	if format := strings.TrimSpace(c.QueryParam("format")); format != "" {
+		filter["format"] = strings.ToLower(format)
+	}
+	if language := strings.TrimSpace(c.QueryParam("language")); language != "" {
+		filter["language"] = strings.ToLower(language)
+	}
+	if year := strings.TrimSpace(c.QueryParam("year")); year != "" {
+		filter["bookyear"] = year
+	}
+	if decade, err := strconv.Atoi(c.QueryParam("decade")); err == nil && decade >= 0 && decade%10 == 0 {
+		filter["bookyear"] = primitive.Regex{Pattern: fmt.Sprintf("^%d[0-9]$", decade/10)}
+	}
+	if tags := normalizeList(c.QueryParams()["tag"], normalizeTag); len(tags) > 0 {
+		filter["tags"] = bson.M{"$all": tags}
+	}
+	if title := strings.TrimSpace(c.QueryParam("title")); title != "" {
+		filter["bookname"] = containsPattern(title)
+	}
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		filter["$or"] = bson.A{
+			bson.M{"bookname": containsPattern(q)},
+			bson.M{"bookauthor": containsPattern(q)},
+		}
+	}
+	return filter
+}
+
+func containsPattern(s string) primitive.Regex {
+	return primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"}
+}
+
+// nonNil returns list, or an empty list when it is nil, so JSON has [].
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func toBookResponse(res BookStore) BookResponse {
+	return toBookResponseAt(res, time.Now())
+}
+
+// toBookResponseAt builds the response for a book with its price at the
+// given time.
+func toBookResponseAt(res BookStore, now time.Time) BookResponse {
+	ret := BookResponse{
+		ID:             res.ID,
+		Title:          res.BookName,
+		Author:         res.BookAuthor,
+		AuthorIDs:      nonNil(res.AuthorIDs),
+		Pages:          res.BookPages,
+		Edition:        res.BookEdition,
+		Year:           res.BookYear,
+		Tags:           nonNil(res.Tags),
+		Subjects:       nonNil(res.Subjects),
+		PublisherID:    res.PublisherID,
+		Series:         res.Series,
+		SeriesPosition: res.SeriesPos,
+		WorkID:         res.WorkID,
+		Format:         res.Format,
+		Language:       res.Language,
+		Price:          res.Price,
+		Discounts:      res.Discounts,
+		CoverURL:       coverURL(res.ID, res.Cover),
+		Ebooks:         toEbookResponses(res.ID, res.Ebooks),
+	}
+	if ret.Discounts == nil {
+		ret.Discounts = []Discount{}
+	}
+	if res.Rating != nil {
+		ret.Rating = *res.Rating
+	}
+	if res.Price != nil {
+		price, discount := effectivePrice(*res.Price, res.Discounts, now)
+		ret.EffectivePrice, ret.Discount = &price, discount
+	}
+	return ret
+}
+
+// listBatchSize is how many documents the driver fetches per round trip
+// while streaming. It can be tuned with BOOKS_BATCH_SIZE.
+func listBatchSize() int32 {
+	size, err := strconv.Atoi(os.Getenv("BOOKS_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		return 500
+	}
+	return int32(size)
+}
+
+func findBooks(ctx context.Context, coll *mongo.Collection, filter bson.M, sort bson.D) (*mongo.Cursor, error) {
+	opts := options.Find().SetBatchSize(listBatchSize())
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	return coll.Find(ctx, filter, opts)
+}
+
+// streamBooks writes the cursor to w as a JSON array one document at a time,
+// so only a single batch is held in memory regardless of collection size.
+// It stops as soon as ctx is cancelled, e.g. when the client disconnects.
+func streamBooks(ctx context.Context, cursor *mongo.Cursor, w io.Writer) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	first := true
+	for cursor.Next(ctx) {
+		var book BookStore
+		if err := cursor.Decode(&book); err != nil {
+			return err
+		}
+		payload, err := json.Marshal(toBookResponse(book))
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+func getAllBooksAPI(c echo.Context, coll *mongo.Collection, filter bson.M, sort bson.D) error {
+	// The deadline covers the query and its first batch. Streaming the rest
+	// can take longer than any query on a large catalog, and stops when the
+	// client goes away.
+	queryCtx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	cursor, err := findBooks(queryCtx, coll, filter, sort)
 	if err != nil {
 		fmt.Printf("Error finding books: %v\n", err)
-		return []BookResponse{}
-	}
-	
-	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		fmt.Printf("Error decoding books: %v\n", err)
-		return []BookResponse{}
-	}
-
-	var ret []BookResponse
-	for _, res := range results {
-		ret = append(ret, BookResponse{
-			ID:      res.ID,
-			Title:   res.BookName,
-			Author:  res.BookAuthor,
-			Pages:   res.BookPages,
-			Edition: res.BookEdition,
-			Year:    res.BookYear,
+		if isTimeout(err) {
+			return dbTimeoutProblem(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch books",
 		})
 	}
+	defer cursor.Close(context.Background())
 
-	return ret
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	res.WriteHeader(http.StatusOK)
+
+	// Once streaming has started the status can no longer change; on failure
+	// the connection is aborted so clients see a broken response rather
+	// than a silently truncated list.
+	if err := streamBooks(c.Request().Context(), cursor, res); err != nil {
+		fmt.Printf("Error streaming books: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+// getBookAPI answers a single book by its ID, so callers that need one
+// book do not have to page through the catalog. Deleted books are not
+// found.
+func getBookAPI(c echo.Context, coll *mongo.Collection) error {
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	var book BookStore
+	err := coll.FindOne(ctx, bson.M{"id": c.Param("id"), "deletedat": bson.M{"$exists": false}}).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book not found",
+		})
+	}
+	if err != nil {
+		fmt.Printf("Error finding book: %v\n", err)
+		if isTimeout(err) {
+			return dbTimeoutProblem(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch book",
+		})
+	}
+	return c.JSON(http.StatusOK, toBookResponse(book))
 }
 
 func main() {
@@ -120,14 +349,153 @@ func main() {
 		}
 	}()
 
+	keys := newKeyStore(collection(client, "apikeys"))
+	auditColl := collection(client, "audit")
+	revisionColl := collection(client, "revisions")
+	subjectColl := collection(client, "subjects")
+	publisherColl := collection(client, "publishers")
+	workColl := collection(client, "works")
+	priceColl := collection(client, "pricehistory")
+	recommendationColl := collection(client, "recommendations")
+	orderColl := collection(client, "orders")
+
+	if client != nil {
+		job := &recommender{
+			books:           coll,
+			lists:           collection(client, "lists"),
+			orders:          orderColl,
+			recommendations: recommendationColl,
+		}
+		job.start(context.Background(), recommendationInterval())
+	}
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
 
 	e.GET("/api/books", func(c echo.Context) error {
-		books := getAllBooksAPI(coll)
-		return c.JSON(http.StatusOK, books)
-	})
+		filter, err := catalogFilter(c, subjectColl)
+		if err != nil {
+			return filterError(c, err)
+		}
+		if c.QueryParam("facets") == "true" {
+			return getFacetedBooks(c, coll, filter)
+		}
+		sort, err := bookSort(c)
+		if err != nil {
+			return filterError(c, err)
+		}
+		return getAllBooksAPI(c, coll, filter, sort)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/books/export", func(c echo.Context) error {
+		filter, err := catalogFilter(c, subjectColl)
+		if err != nil {
+			return filterError(c, err)
+		}
+		sort, err := bookSort(c)
+		if err != nil {
+			return filterError(c, err)
+		}
+		return exportBooks(c, coll, filter, sort)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/books/:id", func(c echo.Context) error {
+		return getBookAPI(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/tags", func(c echo.Context) error {
+		return getTagCounts(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/subjects", func(c echo.Context) error {
+		return getSubjects(c, subjectColl, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/works", func(c echo.Context) error {
+		return getWorks(c, workColl, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/works/:id", func(c echo.Context) error {
+		return getWork(c, workColl, coll, true)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/works/:id/editions", func(c echo.Context) error {
+		return getWork(c, workColl, coll, false)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/publishers", func(c echo.Context) error {
+		return getPublishers(c, publisherColl, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/publishers/:code", func(c echo.Context) error {
+		return getPublisher(c, publisherColl, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/books/:id/series", func(c echo.Context) error {
+		return getBookSeries(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/books/:id/cover", func(c echo.Context) error {
+		return getCover(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/books/:id/ebooks/:format", func(c echo.Context) error {
+		return getEbook(c, coll, orderColl)
+	}, opdsChallenge, authorize(auth, keys, RoleReader))
+
+	e.GET("/api/books/:id/recommendations", func(c echo.Context) error {
+		return getRecommendations(c, coll, recommendationColl)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/books/:id/prices", func(c echo.Context) error {
+		return getPriceHistory(c, priceColl)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/stats/authors", func(c echo.Context) error {
+		return getAuthorCounts(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/stats/years", func(c echo.Context) error {
+		return getPeriodCounts(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/stats/pages", func(c echo.Context) error {
+		return getPageStats(c, coll)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/audit", func(c echo.Context) error {
+		return getAuditEntries(c, auditColl)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.GET("/api/books/:id/revisions", func(c echo.Context) error {
+		return listRevisions(c, revisionColl)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.GET("/api/books/:id/revisions/:rev", func(c echo.Context) error {
+		return getRevision(c, revisionColl)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.GET("/api/books/:id/asof", func(c echo.Context) error {
+		return getBookAsOf(c, revisionColl)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.GET("/api/books/:id/diff", func(c echo.Context) error {
+		return diffRevisions(c, revisionColl)
+	}, authorize(auth, keys, RoleEditor))
+
+	opds := &opdsCatalog{books: coll, authors: collection(client, "authors")}
+	e.GET(opdsRoot+"/opensearch.xml", opds.openSearch, optionalAPIKey(keys))
+	e.GET(opdsRoot+opdsAuthPath, opds.authentication, optionalAPIKey(keys))
+	for path, feed := range opds.feeds() {
+		e.GET(opdsRoot+path, opds.serveAtom(feed), optionalAPIKey(keys))
+		e.GET(opds2Root+path, opds.serveJSON(feed), optionalAPIKey(keys))
+	}
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
@@ -0,0 +1,769 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The catalog is served twice from the same feeds: as OPDS 1.2 (Atom) under
+// opdsRoot and as OPDS 2.0 (JSON) under opds2Root. It only lists books that
+// have an e-book attached.
+const (
+	opdsRoot  = "/api/opds"
+	opds2Root = "/api/opds/v2"
+
+	opdsNavigation  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisition = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opds2Type       = "application/opds+json"
+	openSearchType  = "application/opensearchdescription+xml"
+	opdsAuthType    = "application/opds-authentication+json"
+
+	// opdsAuthPath is the Authentication Document, which tells e-reader
+	// apps how to sign in before following acquisition links.
+	opdsAuthPath = "/auth"
+
+	opdsRelAcquisition = "http://opds-spec.org/acquisition"
+	opdsRelImage       = "http://opds-spec.org/image"
+	opdsRelThumbnail   = "http://opds-spec.org/image/thumbnail"
+	opdsRelNew         = "http://opds-spec.org/sort/new"
+	opdsRelAuth        = "http://opds-spec.org/auth/document"
+	opdsAuthBasic      = "http://opds-spec.org/auth/basic"
+
+	defaultOPDSPageSize = 50
+)
+
+var (
+	errOPDSNotFound  = errors.New("feed not found")
+	errMissingSearch = errors.New("q is required")
+)
+
+// opdsFeed is a catalog feed before it is rendered as Atom or JSON. Paths
+// are relative to the catalog root, which is "" itself.
+type opdsFeed struct {
+	Path  string
+	Query url.Values
+	Title string
+	// Kind is opdsNavigation for feeds of Entries and opdsAcquisition for
+	// feeds of Books.
+	Kind    string
+	Page    opdsPage
+	Entries []opdsEntry
+	Books   []BookStore
+}
+
+// opdsEntry links a navigation feed to another feed.
+type opdsEntry struct {
+	Path  string
+	Title string
+	Rel   string
+	Kind  string
+	Count int64
+}
+
+// opdsPage is one page of a feed. Feeds with a zero Size are not paged.
+type opdsPage struct {
+	Number int64
+	Size   int64
+	Total  int64
+}
+
+func (p opdsPage) offset() int64 {
+	return (p.Number - 1) * p.Size
+}
+
+// last is the number of the last page, which is 1 for an empty feed.
+func (p opdsPage) last() int64 {
+	if p.Size == 0 || p.Total <= p.Size {
+		return 1
+	}
+	return (p.Total + p.Size - 1) / p.Size
+}
+
+// opdsPageSize is the number of entries per page, OPDS_PAGE_SIZE (default
+// 50).
+func opdsPageSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("OPDS_PAGE_SIZE"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultOPDSPageSize
+}
+
+func opdsPageOf(c echo.Context) opdsPage {
+	number, err := strconv.ParseInt(c.QueryParam("page"), 10, 64)
+	if err != nil || number < 1 {
+		number = 1
+	}
+	return opdsPage{Number: number, Size: opdsPageSize()}
+}
+
+type opdsPageLink struct {
+	Rel    string
+	Number int64
+}
+
+// pageLinks are the first, previous, next and last links of a paged feed,
+// leaving out those that would point at the current page.
+func pageLinks(p opdsPage) []opdsPageLink {
+	links := []opdsPageLink{}
+	if p.Size == 0 {
+		return links
+	}
+	last := p.last()
+	if p.Number > 1 {
+		links = append(links, opdsPageLink{"first", 1}, opdsPageLink{"previous", min(p.Number-1, last)})
+	}
+	if p.Number < last {
+		links = append(links, opdsPageLink{"next", p.Number + 1}, opdsPageLink{"last", last})
+	}
+	return links
+}
+
+// href is the URL of a page of the feed under root.
+func (f *opdsFeed) href(root string, page int64) string {
+	query := url.Values{}
+	for key, values := range f.Query {
+		query[key] = values
+	}
+	if page > 1 {
+		query.Set("page", strconv.FormatInt(page, 10))
+	}
+	if len(query) == 0 {
+		return root + f.Path
+	}
+	return root + f.Path + "?" + query.Encode()
+}
+
+// up is the path of the feed above this one, and false for the root.
+func (f *opdsFeed) up() (string, bool) {
+	if f.Path == "" {
+		return "", false
+	}
+	return f.Path[:strings.LastIndex(f.Path, "/")], true
+}
+
+func opdsBookID(book BookStore) string {
+	return "urn:bookstore:book:" + book.ID
+}
+
+// opdsUpdated is when a book last changed, for catalogs that sync.
+func opdsUpdated(book BookStore, now time.Time) time.Time {
+	switch {
+	case !book.UpdatedAt.IsZero():
+		return book.UpdatedAt
+	case !book.CreatedAt.IsZero():
+		return book.CreatedAt
+	}
+	return now
+}
+
+// coverThumbnail is the smallest size made of a cover, with its type.
+func coverThumbnail(bookID string, cover *Cover) (string, string) {
+	for _, size := range cover.Sizes {
+		if size == "small" {
+			return coverURL(bookID, cover) + "&size=small", "image/jpeg"
+		}
+	}
+	return coverURL(bookID, cover), cover.ContentType
+}
+
+type atomFeed struct {
+	XMLName      xml.Name    `xml:"feed"`
+	Xmlns        string      `xml:"xmlns,attr"`
+	XmlnsDC      string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS    string      `xml:"xmlns:opds,attr"`
+	XmlnsSearch  string      `xml:"xmlns:opensearch,attr"`
+	XmlnsThread  string      `xml:"xmlns:thr,attr"`
+	ID           string      `xml:"id"`
+	Title        string      `xml:"title"`
+	Updated      string      `xml:"updated"`
+	Author       atomAuthor  `xml:"author"`
+	TotalResults int64       `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int64       `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   int64       `xml:"opensearch:startIndex,omitempty"`
+	Links        []atomLink  `xml:"link"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	Count int64  `xml:"thr:count,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomContent   `xml:"content"`
+	Links      []atomLink     `xml:"link"`
+}
+
+// toAtomFeed renders a feed as OPDS 1.2.
+func toAtomFeed(feed *opdsFeed, now time.Time) atomFeed {
+	updated := now.UTC().Format(time.RFC3339)
+	ret := atomFeed{
+		Xmlns:       "http://www.w3.org/2005/Atom",
+		XmlnsDC:     "http://purl.org/dc/terms/",
+		XmlnsOPDS:   "http://opds-spec.org/2010/catalog",
+		XmlnsSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		XmlnsThread: "http://purl.org/syndication/thread/1.0",
+		ID:          "urn:bookstore:opds" + feed.Path,
+		Title:       feed.Title,
+		Updated:     updated,
+		Author:      atomAuthor{Name: "Bookstore"},
+		Links: []atomLink{
+			{Rel: "self", Href: feed.href(opdsRoot, feed.Page.Number), Type: feed.Kind},
+			{Rel: "start", Href: opdsRoot, Type: opdsNavigation},
+			{Rel: "search", Href: opdsRoot + "/opensearch.xml", Type: openSearchType},
+			{Rel: "alternate", Href: feed.href(opds2Root, feed.Page.Number), Type: opds2Type},
+			{Rel: opdsRelAuth, Href: opdsRoot + opdsAuthPath, Type: opdsAuthType},
+		},
+		Entries: []atomEntry{},
+	}
+	if up, ok := feed.up(); ok {
+		ret.Links = append(ret.Links, atomLink{Rel: "up", Href: opdsRoot + up, Type: opdsNavigation})
+	}
+	if feed.Page.Size > 0 {
+		ret.TotalResults = feed.Page.Total
+		ret.ItemsPerPage = feed.Page.Size
+		ret.StartIndex = feed.Page.offset() + 1
+		for _, link := range pageLinks(feed.Page) {
+			ret.Links = append(ret.Links, atomLink{Rel: link.Rel, Href: feed.href(opdsRoot, link.Number), Type: feed.Kind})
+		}
+	}
+
+	for _, entry := range feed.Entries {
+		atom := atomEntry{
+			Title:   entry.Title,
+			ID:      "urn:bookstore:opds" + entry.Path,
+			Updated: updated,
+			Links:   []atomLink{{Rel: entry.Rel, Href: opdsRoot + entry.Path, Type: entry.Kind, Count: entry.Count}},
+		}
+		if entry.Count > 0 {
+			atom.Content = &atomContent{Type: "text", Text: booksCount(entry.Count)}
+		}
+		ret.Entries = append(ret.Entries, atom)
+	}
+	for _, book := range feed.Books {
+		ret.Entries = append(ret.Entries, toAtomEntry(book, now))
+	}
+	return ret
+}
+
+func toAtomEntry(book BookStore, now time.Time) atomEntry {
+	entry := atomEntry{
+		Title:    book.BookName,
+		ID:       opdsBookID(book),
+		Updated:  opdsUpdated(book, now).UTC().Format(time.RFC3339),
+		Language: book.Language,
+		Issued:   book.BookYear,
+	}
+	if book.BookAuthor != "" {
+		entry.Authors = []atomAuthor{{Name: book.BookAuthor}}
+	}
+	for _, tag := range book.Tags {
+		entry.Categories = append(entry.Categories, atomCategory{Term: tag, Label: tag})
+	}
+	for _, ebook := range toEbookResponses(book.ID, book.Ebooks) {
+		entry.Links = append(entry.Links, atomLink{Rel: opdsRelAcquisition, Href: ebook.URL, Type: ebookContentTypes[ebook.Format]})
+	}
+	if book.Cover != nil {
+		thumbnail, thumbnailType := coverThumbnail(book.ID, book.Cover)
+		entry.Links = append(entry.Links,
+			atomLink{Rel: opdsRelImage, Href: coverURL(book.ID, book.Cover), Type: book.Cover.ContentType},
+			atomLink{Rel: opdsRelThumbnail, Href: thumbnail, Type: thumbnailType})
+	}
+	return entry
+}
+
+func booksCount(n int64) string {
+	if n == 1 {
+		return "1 book"
+	}
+	return strconv.FormatInt(n, 10) + " books"
+}
+
+type opds2Link struct {
+	Rel        string           `json:"rel,omitempty"`
+	Href       string           `json:"href"`
+	Type       string           `json:"type,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Templated  bool             `json:"templated,omitempty"`
+	Properties *opds2Properties `json:"properties,omitempty"`
+}
+
+type opds2Properties struct {
+	NumberOfItems int64 `json:"numberOfItems"`
+}
+
+type opds2Metadata struct {
+	Title         string `json:"title"`
+	NumberOfItems int64  `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int64  `json:"itemsPerPage,omitempty"`
+	CurrentPage   int64  `json:"currentPage,omitempty"`
+}
+
+type opds2Feed struct {
+	Metadata opds2Metadata `json:"metadata"`
+	Links    []opds2Link   `json:"links"`
+}
+
+// A feed carries either navigation or publications, and either one is
+// always there, even when it is empty.
+type opds2NavigationFeed struct {
+	opds2Feed
+	Navigation []opds2Link `json:"navigation"`
+}
+
+type opds2PublicationFeed struct {
+	opds2Feed
+	Publications []opds2Publication `json:"publications"`
+}
+
+type opds2Publication struct {
+	Metadata opds2PublicationMetadata `json:"metadata"`
+	Links    []opds2Link              `json:"links"`
+	Images   []opds2Link              `json:"images,omitempty"`
+}
+
+type opds2PublicationMetadata struct {
+	Type       string    `json:"@type"`
+	Identifier string    `json:"identifier"`
+	Title      string    `json:"title"`
+	Author     string    `json:"author,omitempty"`
+	Language   string    `json:"language,omitempty"`
+	Published  string    `json:"published,omitempty"`
+	Modified   time.Time `json:"modified"`
+	Subject    []string  `json:"subject,omitempty"`
+}
+
+// toOPDS2Feed renders a feed as OPDS 2.0.
+func toOPDS2Feed(feed *opdsFeed, now time.Time) interface{} {
+	ret := opds2Feed{
+		Metadata: opds2Metadata{Title: feed.Title},
+		Links: []opds2Link{
+			{Rel: "self", Href: feed.href(opds2Root, feed.Page.Number), Type: opds2Type},
+			{Rel: "start", Href: opds2Root, Type: opds2Type},
+			{Rel: "search", Href: opds2Root + "/search{?q}", Type: opds2Type, Templated: true},
+			{Rel: "alternate", Href: feed.href(opdsRoot, feed.Page.Number), Type: feed.Kind},
+			{Rel: opdsRelAuth, Href: opdsRoot + opdsAuthPath, Type: opdsAuthType},
+		},
+	}
+	if up, ok := feed.up(); ok {
+		ret.Links = append(ret.Links, opds2Link{Rel: "up", Href: opds2Root + up, Type: opds2Type})
+	}
+	if feed.Page.Size > 0 {
+		ret.Metadata.NumberOfItems = feed.Page.Total
+		ret.Metadata.ItemsPerPage = feed.Page.Size
+		ret.Metadata.CurrentPage = feed.Page.Number
+		for _, link := range pageLinks(feed.Page) {
+			ret.Links = append(ret.Links, opds2Link{Rel: link.Rel, Href: feed.href(opds2Root, link.Number), Type: opds2Type})
+		}
+	}
+
+	if feed.Kind == opdsNavigation {
+		navigation := opds2NavigationFeed{opds2Feed: ret, Navigation: []opds2Link{}}
+		for _, entry := range feed.Entries {
+			link := opds2Link{Rel: entry.Rel, Href: opds2Root + entry.Path, Type: opds2Type, Title: entry.Title}
+			if entry.Count > 0 {
+				link.Properties = &opds2Properties{NumberOfItems: entry.Count}
+			}
+			navigation.Navigation = append(navigation.Navigation, link)
+		}
+		return navigation
+	}
+	publications := opds2PublicationFeed{opds2Feed: ret, Publications: []opds2Publication{}}
+	for _, book := range feed.Books {
+		publications.Publications = append(publications.Publications, toOPDS2Publication(book, now))
+	}
+	return publications
+}
+
+func toOPDS2Publication(book BookStore, now time.Time) opds2Publication {
+	publication := opds2Publication{
+		Metadata: opds2PublicationMetadata{
+			Type:       "http://schema.org/Book",
+			Identifier: opdsBookID(book),
+			Title:      book.BookName,
+			Author:     book.BookAuthor,
+			Language:   book.Language,
+			Published:  book.BookYear,
+			Modified:   opdsUpdated(book, now).UTC(),
+			Subject:    book.Tags,
+		},
+		Links: []opds2Link{},
+	}
+	for _, ebook := range toEbookResponses(book.ID, book.Ebooks) {
+		publication.Links = append(publication.Links, opds2Link{Rel: opdsRelAcquisition, Href: ebook.URL, Type: ebookContentTypes[ebook.Format]})
+	}
+	if book.Cover != nil {
+		thumbnail, thumbnailType := coverThumbnail(book.ID, book.Cover)
+		publication.Images = []opds2Link{
+			{Href: coverURL(book.ID, book.Cover), Type: book.Cover.ContentType},
+			{Href: thumbnail, Type: thumbnailType},
+		}
+	}
+	return publication
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	URLs           []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// toOpenSearch describes the search of the catalog at base, the scheme and
+// host it is reached at; OpenSearch templates must be absolute.
+func toOpenSearch(base string) openSearchDescription {
+	return openSearchDescription{
+		Xmlns:          "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:      "Bookstore",
+		Description:    "Search the e-books of the bookstore by title or author",
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: opdsAcquisition, Template: base + opdsRoot + "/search?q={searchTerms}&page={startPage?}"},
+			{Type: opds2Type, Template: base + opds2Root + "/search?q={searchTerms}&page={startPage?}"},
+		},
+	}
+}
+
+type opdsAuthDocument struct {
+	ID             string         `json:"id"`
+	Title          string         `json:"title"`
+	Description    string         `json:"description"`
+	Authentication []opdsAuthFlow `json:"authentication"`
+	Links          []opds2Link    `json:"links"`
+}
+
+type opdsAuthFlow struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+}
+
+// toAuthDocument describes how to sign in to the catalog at base, the
+// scheme and host it is reached at: with Basic auth, using an API key as
+// the password, since that is what e-reader apps can send.
+func toAuthDocument(base string) opdsAuthDocument {
+	return opdsAuthDocument{
+		ID:          base + opdsRoot + opdsAuthPath,
+		Title:       "Bookstore",
+		Description: "Sign in with any user name and an API key as the password to download the e-books you bought",
+		Authentication: []opdsAuthFlow{{
+			Type:   opdsAuthBasic,
+			Labels: map[string]string{"login": "User name", "password": "API key"},
+		}},
+		Links: []opds2Link{{Rel: "start", Href: base + opdsRoot, Type: opdsNavigation}},
+	}
+}
+
+// opdsChallenge points clients refused with 401, such as an e-reader app
+// following an acquisition link, to Basic auth and the Authentication
+// Document.
+func opdsChallenge(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		res := c.Response()
+		res.Before(func() {
+			if res.Status == http.StatusUnauthorized {
+				res.Header().Set("WWW-Authenticate", `Basic realm="Bookstore"`)
+				res.Header().Add("Link", "<"+opdsRoot+opdsAuthPath+`>; rel="`+opdsRelAuth+`"; type="`+opdsAuthType+`"`)
+			}
+		})
+		return next(c)
+	}
+}
+
+// opdsCatalog builds the feeds of the catalog.
+type opdsCatalog struct {
+	books   *mongo.Collection
+	authors *mongo.Collection
+}
+
+type opdsHandler func(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error)
+
+// feeds are the feeds of the catalog by path.
+func (o *opdsCatalog) feeds() map[string]opdsHandler {
+	return map[string]opdsHandler{
+		"":             o.root,
+		"/new":         o.newArrivals,
+		"/authors":     o.authorList,
+		"/authors/:id": o.author,
+		"/years":       o.yearList,
+		"/years/:year": o.year,
+		"/search":      o.search,
+	}
+}
+
+// opdsFilter matches the books in the catalog, narrowed down by extra.
+func opdsFilter(extra bson.M) bson.M {
+	filter := bson.M{"deletedat": bson.M{"$exists": false}, "ebooks.0": bson.M{"$exists": true}}
+	for key, value := range extra {
+		filter[key] = value
+	}
+	return filter
+}
+
+var opdsByTitle = bson.D{{Key: "bookname", Value: 1}, {Key: "id", Value: 1}}
+
+func (o *opdsCatalog) root(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	return &opdsFeed{
+		Title: "Bookstore",
+		Kind:  opdsNavigation,
+		Entries: []opdsEntry{
+			{Path: "/new", Title: "New arrivals", Rel: opdsRelNew, Kind: opdsAcquisition},
+			{Path: "/authors", Title: "Authors", Rel: "subsection", Kind: opdsNavigation},
+			{Path: "/years", Title: "Publication years", Rel: "subsection", Kind: opdsNavigation},
+		},
+	}, nil
+}
+
+func (o *opdsCatalog) newArrivals(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	sort := bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}
+	return o.acquisition(ctx, &opdsFeed{Path: "/new", Title: "New arrivals", Page: page}, opdsFilter(nil), sort)
+}
+
+func (o *opdsCatalog) author(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	id := c.Param("id")
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errOPDSNotFound
+	}
+	var author struct {
+		Name string `bson:"name"`
+	}
+	err = o.authors.FindOne(ctx, bson.M{"_id": oid}, options.FindOne().SetProjection(bson.M{"name": 1})).Decode(&author)
+	if err == mongo.ErrNoDocuments {
+		return nil, errOPDSNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	feed := &opdsFeed{Path: "/authors/" + url.PathEscape(id), Title: author.Name, Page: page}
+	return o.acquisition(ctx, feed, opdsFilter(bson.M{"authorids": id}), opdsByTitle)
+}
+
+func (o *opdsCatalog) year(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return nil, errOPDSNotFound
+	}
+	feed := &opdsFeed{Path: "/years/" + strconv.Itoa(year), Title: "Published in " + strconv.Itoa(year), Page: page}
+	return o.acquisition(ctx, feed, opdsFilter(bson.M{"bookyear": strconv.Itoa(year)}), opdsByTitle)
+}
+
+// search matches q against titles and authors, like q on /api/books.
+func (o *opdsCatalog) search(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if q == "" {
+		return nil, errMissingSearch
+	}
+	feed := &opdsFeed{Path: "/search", Query: url.Values{"q": {q}}, Title: "Search results for " + q, Page: page}
+	filter := opdsFilter(bson.M{"$or": bson.A{
+		bson.M{"bookname": containsPattern(q)},
+		bson.M{"bookauthor": containsPattern(q)},
+	}})
+	return o.acquisition(ctx, feed, filter, opdsByTitle)
+}
+
+// acquisition fills feed with the page of books matching filter.
+func (o *opdsCatalog) acquisition(ctx context.Context, feed *opdsFeed, filter bson.M, sort bson.D) (*opdsFeed, error) {
+	feed.Kind = opdsAcquisition
+	total, err := o.books.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	feed.Page.Total = total
+	opts := options.Find().SetSort(sort).SetSkip(feed.Page.offset()).SetLimit(feed.Page.Size)
+	cursor, err := o.books.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &feed.Books); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// groupPage runs a grouping pipeline for one page of groups along with the
+// number of groups.
+func (o *opdsCatalog) groupPage(ctx context.Context, groups mongo.Pipeline, page *opdsPage, rows interface{}) error {
+	pipeline := append(groups, bson.D{{Key: "$facet", Value: bson.M{
+		"rows":  paginate(page.Size, page.offset()),
+		"total": bson.A{bson.M{"$count": "count"}},
+	}}})
+	cursor, err := o.books.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var results []struct {
+		Rows  bson.RawValue `bson:"rows"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	if len(results[0].Total) > 0 {
+		page.Total = results[0].Total[0].Count
+	}
+	return results[0].Rows.Unmarshal(rows)
+}
+
+func (o *opdsCatalog) authorList(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	feed := &opdsFeed{Path: "/authors", Title: "Authors", Kind: opdsNavigation, Page: page, Entries: []opdsEntry{}}
+	var rows []struct {
+		ID     string `bson:"_id"`
+		Books  int64  `bson:"books"`
+		Author struct {
+			Name string `bson:"name"`
+		} `bson:"author"`
+	}
+	match := bson.D{{Key: "$match", Value: opdsFilter(nil)}}
+	if err := o.groupPage(ctx, authorGroups(match, "name"), &feed.Page, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		feed.Entries = append(feed.Entries, opdsEntry{
+			Path:  "/authors/" + url.PathEscape(row.ID),
+			Title: row.Author.Name,
+			Rel:   "subsection",
+			Kind:  opdsAcquisition,
+			Count: row.Books,
+		})
+	}
+	return feed, nil
+}
+
+func (o *opdsCatalog) yearList(ctx context.Context, c echo.Context, page opdsPage) (*opdsFeed, error) {
+	feed := &opdsFeed{Path: "/years", Title: "Publication years", Kind: opdsNavigation, Page: page, Entries: []opdsEntry{}}
+	var rows []struct {
+		Year  int64 `bson:"_id"`
+		Books int64 `bson:"books"`
+	}
+	match := bson.D{{Key: "$match", Value: opdsFilter(nil)}}
+	if err := o.groupPage(ctx, periodGroups(match, false), &feed.Page, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		year := strconv.FormatInt(row.Year, 10)
+		feed.Entries = append(feed.Entries, opdsEntry{
+			Path:  "/years/" + year,
+			Title: year,
+			Rel:   "subsection",
+			Kind:  opdsAcquisition,
+			Count: row.Books,
+		})
+	}
+	return feed, nil
+}
+
+func opdsError(c echo.Context, err error) error {
+	switch err {
+	case errOPDSNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Feed not found",
+		})
+	case errMissingSearch:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return filterError(c, err)
+}
+
+// serveAtom serves a feed as OPDS 1.2.
+func (o *opdsCatalog) serveAtom(handler opdsHandler) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "opds")
+		defer cancel()
+		feed, err := handler(ctx, c, opdsPageOf(c))
+		if err != nil {
+			return opdsError(c, err)
+		}
+		body, err := xml.MarshalIndent(toAtomFeed(feed, time.Now()), "", "  ")
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, feed.Kind+";charset=utf-8", append([]byte(xml.Header), body...))
+	}
+}
+
+// serveJSON serves a feed as OPDS 2.0.
+func (o *opdsCatalog) serveJSON(handler opdsHandler) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "opds")
+		defer cancel()
+		feed, err := handler(ctx, c, opdsPageOf(c))
+		if err != nil {
+			return opdsError(c, err)
+		}
+		body, err := json.Marshal(toOPDS2Feed(feed, time.Now()))
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, opds2Type, body)
+	}
+}
+
+func (o *opdsCatalog) openSearch(c echo.Context) error {
+	body, err := xml.MarshalIndent(toOpenSearch(c.Scheme()+"://"+c.Request().Host), "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, openSearchType, append([]byte(xml.Header), body...))
+}
+
+func (o *opdsCatalog) authentication(c echo.Context) error {
+	body, err := json.Marshal(toAuthDocument(c.Scheme() + "://" + c.Request().Host))
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, opdsAuthType, body)
+}
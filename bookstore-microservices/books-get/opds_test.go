@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestPageLinks(t *testing.T) {
+	for _, tt := range []struct {
+		page opdsPage
+		want []opdsPageLink
+	}{
+		{opdsPage{Number: 1, Size: 50, Total: 0}, []opdsPageLink{}},
+		{opdsPage{Number: 1, Size: 50, Total: 50}, []opdsPageLink{}},
+		{opdsPage{Number: 1, Size: 50, Total: 120}, []opdsPageLink{{"next", 2}, {"last", 3}}},
+		{opdsPage{Number: 2, Size: 50, Total: 120}, []opdsPageLink{{"first", 1}, {"previous", 1}, {"next", 3}, {"last", 3}}},
+		{opdsPage{Number: 3, Size: 50, Total: 120}, []opdsPageLink{{"first", 1}, {"previous", 2}}},
+		{opdsPage{Number: 9, Size: 50, Total: 120}, []opdsPageLink{{"first", 1}, {"previous", 3}}},
+		{opdsPage{}, []opdsPageLink{}},
+	} {
+		if got := pageLinks(tt.page); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pageLinks(%+v) = %v, want %v", tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestOPDSFeedHref(t *testing.T) {
+	feed := &opdsFeed{Path: "/search", Query: url.Values{"q": {"le guin"}}}
+	if got := feed.href(opdsRoot, 1); got != "/api/opds/search?q=le+guin" {
+		t.Errorf("href() = %q", got)
+	}
+	if got := feed.href(opds2Root, 3); got != "/api/opds/v2/search?page=3&q=le+guin" {
+		t.Errorf("href() = %q", got)
+	}
+	if up, ok := (&opdsFeed{Path: "/authors/a1"}).up(); !ok || up != "/authors" {
+		t.Errorf("up() = %q, %v", up, ok)
+	}
+	if up, ok := (&opdsFeed{Path: "/new"}).up(); !ok || up != "" {
+		t.Errorf("up() = %q, %v, want the root", up, ok)
+	}
+	if _, ok := (&opdsFeed{}).up(); ok {
+		t.Error("the root has nothing above it")
+	}
+}
+
+func testOPDSFeed() *opdsFeed {
+	return &opdsFeed{
+		Path:  "/new",
+		Title: "New arrivals",
+		Kind:  opdsAcquisition,
+		Page:  opdsPage{Number: 2, Size: 1, Total: 3},
+		Books: []BookStore{{
+			ID:         "b1",
+			BookName:   "The Dispossessed",
+			BookAuthor: "Ursula K. Le Guin",
+			BookYear:   "1974",
+			Language:   "en",
+			Tags:       []string{"science-fiction"},
+			Cover:      &Cover{ETag: "c1", ContentType: "image/png", Sizes: []string{"medium", "small"}},
+			Ebooks:     []Ebook{{Format: "epub", Filename: "dispossessed.epub"}},
+			CreatedAt:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		}},
+	}
+}
+
+func TestToAtomFeed(t *testing.T) {
+	body, err := xml.Marshal(toAtomFeed(testOPDSFeed(), time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Total string `xml:"totalResults"`
+		Links []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Entries []struct {
+			Updated string `xml:"updated"`
+			Links   []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(body, &parsed); err != nil {
+		t.Fatalf("feed is not well-formed: %v\n%s", err, body)
+	}
+	if parsed.Total != "3" {
+		t.Errorf("totalResults = %q, want 3", parsed.Total)
+	}
+	links := map[string]string{}
+	for _, link := range parsed.Links {
+		links[link.Rel] = link.Href
+	}
+	if links["self"] != "/api/opds/new?page=2" || links["next"] != "/api/opds/new?page=3" || links["up"] != "/api/opds" {
+		t.Errorf("unexpected feed links %v", links)
+	}
+	if links[opdsRelAuth] != "/api/opds/auth" {
+		t.Errorf("the feed should link the authentication document, got %v", links)
+	}
+	if len(parsed.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(parsed.Entries))
+	}
+	entry := parsed.Entries[0]
+	if entry.Updated != "2026-01-02T03:04:05Z" {
+		t.Errorf("updated = %q, want the creation time", entry.Updated)
+	}
+	acquisition := entry.Links[0]
+	if acquisition.Rel != opdsRelAcquisition || acquisition.Href != "/api/books/b1/ebooks/epub" || acquisition.Type != "application/epub+zip" {
+		t.Errorf("unexpected acquisition link %+v", acquisition)
+	}
+	if thumbnail := entry.Links[2]; thumbnail.Rel != opdsRelThumbnail || !strings.HasSuffix(thumbnail.Href, "&size=small") {
+		t.Errorf("unexpected thumbnail link %+v", thumbnail)
+	}
+}
+
+func TestToOPDS2Feed(t *testing.T) {
+	body, err := json.Marshal(toOPDS2Feed(testOPDSFeed(), time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Metadata     opds2Metadata      `json:"metadata"`
+		Navigation   []opds2Link        `json:"navigation"`
+		Publications []opds2Publication `json:"publications"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Metadata != (opds2Metadata{Title: "New arrivals", NumberOfItems: 3, ItemsPerPage: 1, CurrentPage: 2}) {
+		t.Errorf("metadata = %+v", parsed.Metadata)
+	}
+	if parsed.Navigation != nil || len(parsed.Publications) != 1 {
+		t.Fatalf("an acquisition feed has publications only, got %s", body)
+	}
+	publication := parsed.Publications[0]
+	if publication.Links[0].Href != "/api/books/b1/ebooks/epub" || len(publication.Images) != 2 {
+		t.Errorf("unexpected publication %+v", publication)
+	}
+
+	empty, _ := json.Marshal(toOPDS2Feed(&opdsFeed{Path: "/search", Kind: opdsAcquisition, Page: opdsPage{Number: 1, Size: 50}}, time.Now()))
+	if !strings.Contains(string(empty), `"publications":[]`) {
+		t.Errorf("an empty feed still has publications, got %s", empty)
+	}
+	root, _ := json.Marshal(toOPDS2Feed(&opdsFeed{Kind: opdsNavigation, Entries: []opdsEntry{{Path: "/authors", Title: "Authors", Rel: "subsection"}}}, time.Now()))
+	if !strings.Contains(string(root), `"navigation":[{"rel":"subsection","href":"/api/opds/v2/authors"`) {
+		t.Errorf("unexpected navigation feed %s", root)
+	}
+}
+
+func TestToAuthDocument(t *testing.T) {
+	body, err := json.Marshal(toAuthDocument("https://books.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		ID             string `json:"id"`
+		Authentication []struct {
+			Type string `json:"type"`
+		} `json:"authentication"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != "https://books.example/api/opds/auth" {
+		t.Errorf("id = %q, want the URL of the document", doc.ID)
+	}
+	if len(doc.Authentication) != 1 || doc.Authentication[0].Type != opdsAuthBasic {
+		t.Errorf("unexpected authentication flows %+v", doc.Authentication)
+	}
+}
+
+func TestOPDSChallenge(t *testing.T) {
+	e := echo.New()
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/books/b1/ebooks/epub", nil), rec)
+		handler := opdsChallenge(func(c echo.Context) error { return c.NoContent(status) })
+		if err := handler(c); err != nil {
+			t.Fatal(err)
+		}
+		challenged := rec.Header().Get("WWW-Authenticate") != ""
+		if challenged != (status == http.StatusUnauthorized) {
+			t.Errorf("status %d: WWW-Authenticate = %q", status, rec.Header().Get("WWW-Authenticate"))
+		}
+		if challenged && !strings.Contains(rec.Header().Get("Link"), opdsRelAuth) {
+			t.Errorf("a 401 should link the authentication document, got %q", rec.Header().Get("Link"))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Money is an amount in the minor units of an ISO 4217 currency.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+// Discount lowers the price of a book while it runs, by a percentage or to
+// a fixed promotional price.
+type Discount struct {
+	Label    string     `bson:"label,omitempty" json:"label,omitempty"`
+	Percent  int64      `bson:"percent,omitempty" json:"percent,omitempty"`
+	Price    *int64     `bson:"price,omitempty" json:"price,omitempty"`
+	StartsAt *time.Time `bson:"startsat,omitempty" json:"startsAt,omitempty"`
+	EndsAt   *time.Time `bson:"endsat,omitempty" json:"endsAt,omitempty"`
+}
+
+// PriceChange is an entry of the price history of a book, as stored by the
+// write services.
+type PriceChange struct {
+	BookID    string     `bson:"bookid"`
+	Price     *Money     `bson:"price,omitempty"`
+	Discounts []Discount `bson:"discounts,omitempty"`
+	Actor     string     `bson:"actor"`
+	Timestamp time.Time  `bson:"timestamp"`
+}
+
+type PriceChangeResponse struct {
+	Price     *Money     `json:"price"`
+	Discounts []Discount `json:"discounts"`
+	Actor     string     `json:"actor"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// currencyDigits maps currencies to their number of minor unit digits.
+var currencyDigits = map[string]int{
+	"AUD": 2, "CAD": 2, "CHF": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2,
+	"HUF": 2, "JPY": 0, "KRW": 0, "NOK": 2, "PLN": 2, "SEK": 2, "USD": 2,
+}
+
+// active reports whether a discount runs at the given time.
+func (d Discount) active(now time.Time) bool {
+	if d.StartsAt != nil && now.Before(*d.StartsAt) {
+		return false
+	}
+	return d.EndsAt == nil || now.Before(*d.EndsAt)
+}
+
+// apply returns the amount after the discount, rounding halves up.
+func (d Discount) apply(amount int64) int64 {
+	if d.Price != nil {
+		return *d.Price
+	}
+	return (amount*(100-d.Percent) + 50) / 100
+}
+
+// effectivePrice is the price of a book at the given time: the lowest of
+// the list price and the prices after its running discounts. It returns
+// the discount that applies, if any.
+func effectivePrice(price Money, discounts []Discount, now time.Time) (Money, *Discount) {
+	ret := price
+	var applied *Discount
+	for i, d := range discounts {
+		if !d.active(now) {
+			continue
+		}
+		if amount := d.apply(price.Amount); amount >= 0 && amount < ret.Amount {
+			ret.Amount = amount
+			applied = &discounts[i]
+		}
+	}
+	return ret, applied
+}
+
+// formatMoney writes an amount in major units, e.g. "12.99 EUR".
+func formatMoney(m *Money) string {
+	if m == nil {
+		return ""
+	}
+	digits, ok := currencyDigits[m.Currency]
+	if !ok || digits == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, digits, amount%scale, m.Currency)
+}
+
+// formatDiscounts describes discounts for diffs.
+func formatDiscounts(discounts []Discount) string {
+	var parts []string
+	for _, d := range discounts {
+		part := fmt.Sprintf("%d%%", d.Percent)
+		if d.Price != nil {
+			part = fmt.Sprintf("at %d", *d.Price)
+		}
+		if d.Label != "" {
+			part = d.Label + " " + part
+		}
+		if d.StartsAt != nil {
+			part += " from " + d.StartsAt.Format(time.RFC3339)
+		}
+		if d.EndsAt != nil {
+			part += " until " + d.EndsAt.Format(time.RFC3339)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// getPriceHistory lists the prices a book has had, newest first.
+func getPriceHistory(c echo.Context, coll *mongo.Collection) error {
+	limit, offset := pagination(c, 100, 1000)
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := coll.Find(ctx, bson.M{"bookid": c.Param("id")}, opts)
+	if err != nil {
+		return filterError(c, err)
+	}
+	var changes []PriceChange
+	if err := cursor.All(ctx, &changes); err != nil {
+		return filterError(c, err)
+	}
+
+	ret := []PriceChangeResponse{}
+	for _, change := range changes {
+		discounts := change.Discounts
+		if discounts == nil {
+			discounts = []Discount{}
+		}
+		ret = append(ret, PriceChangeResponse{
+			Price:     change.Price,
+			Discounts: discounts,
+			Actor:     change.Actor,
+			Timestamp: change.Timestamp,
+		})
+	}
+	return c.JSON(http.StatusOK, ret)
+}
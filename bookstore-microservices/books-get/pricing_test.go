@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEffectivePrice(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	promo := int64(800)
+	list := Money{Amount: 1299, Currency: "EUR"}
+
+	cases := []struct {
+		name      string
+		discounts []Discount
+		want      int64
+		label     string
+	}{
+		{"no discounts", nil, 1299, ""},
+		{"percent", []Discount{{Label: "summer", Percent: 10}}, 1169, "summer"},
+		{"not started", []Discount{{Percent: 10, StartsAt: &after}}, 1299, ""},
+		{"ended", []Discount{{Percent: 10, EndsAt: &now}}, 1299, ""},
+		{"lowest wins", []Discount{{Label: "a", Percent: 10, StartsAt: &before, EndsAt: &after}, {Label: "b", Price: &promo}}, 800, "b"},
+	}
+	for _, tc := range cases {
+		got, applied := effectivePrice(list, tc.discounts, now)
+		if got.Amount != tc.want || got.Currency != "EUR" {
+			t.Errorf("%s: got %+v, want %d EUR", tc.name, got, tc.want)
+		}
+		label := ""
+		if applied != nil {
+			label = applied.Label
+		}
+		if label != tc.label {
+			t.Errorf("%s: applied discount %q, want %q", tc.name, label, tc.label)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	cases := map[string]Money{
+		"12.99 EUR": {Amount: 1299, Currency: "EUR"},
+		"0.05 USD":  {Amount: 5, Currency: "USD"},
+		"1500 JPY":  {Amount: 1500, Currency: "JPY"},
+	}
+	for want, m := range cases {
+		if got := formatMoney(&m); got != want {
+			t.Errorf("formatMoney(%+v) = %q, want %q", m, got, want)
+		}
+	}
+	if formatMoney(nil) != "" {
+		t.Error("a missing price is not empty")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Rating sums up the approved reviews of a book. The reviews service keeps
+// it on the book; books without approved reviews have none.
+type Rating struct {
+	Average float64 `bson:"average" json:"average"`
+	Count   int64   `bson:"count" json:"count"`
+}
+
+var errUnknownSort = errors.New("sort must be rating")
+
+// bookSort reads the order of the book list from ?sort=. By default books
+// come in storage order; rating puts the best rated first, with more
+// reviews breaking ties and unrated books last.
+func bookSort(c echo.Context) (bson.D, error) {
+	switch c.QueryParam("sort") {
+	case "":
+		return nil, nil
+	case "rating":
+		return bson.D{{Key: "rating.average", Value: -1}, {Key: "rating.count", Value: -1}, {Key: "id", Value: 1}}, nil
+	}
+	return nil, errUnknownSort
+}
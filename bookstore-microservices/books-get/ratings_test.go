@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestBookSort(t *testing.T) {
+	sortFor := func(query string) ([]string, error) {
+		req := httptest.NewRequest("GET", "/api/books"+query, nil)
+		sort, err := bookSort(echo.New().NewContext(req, httptest.NewRecorder()))
+		keys := []string{}
+		for _, e := range sort {
+			keys = append(keys, e.Key)
+		}
+		return keys, err
+	}
+
+	if keys, err := sortFor(""); err != nil || len(keys) != 0 {
+		t.Errorf("default sort = %v, %v; want storage order", keys, err)
+	}
+	keys, err := sortFor("?sort=rating")
+	if err != nil || len(keys) != 3 || keys[0] != "rating.average" || keys[1] != "rating.count" {
+		t.Errorf("rating sort = %v, %v", keys, err)
+	}
+	if _, err := sortFor("?sort=price"); err != errUnknownSort {
+		t.Errorf("unknown sort gave %v", err)
+	}
+}
+
+func TestBookResponseCarriesRating(t *testing.T) {
+	if got := toBookResponse(BookStore{ID: "b1"}).Rating; got != (Rating{}) {
+		t.Errorf("unrated book has rating %+v", got)
+	}
+	rating := &Rating{Average: 4.25, Count: 4}
+	if got := toBookResponse(BookStore{ID: "b1", Rating: rating}).Rating; got != *rating {
+		t.Errorf("rating = %+v, want %+v", got, *rating)
+	}
+}
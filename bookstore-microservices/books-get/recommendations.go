@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Weights of the signals that relate two books. A shared author counts
+// most; co-occurrences are capped so a few heavy users cannot outweigh
+// everything else.
+const (
+	authorWeight   = 6
+	tagWeight      = 2
+	maxSharedTags  = 3
+	decadeWeight   = 1
+	togetherWeight = 2
+	maxTogether    = 5
+)
+
+const (
+	maxRecommended = 12
+	// Tags and authors with more books than this relate too much to tell
+	// anything apart.
+	maxTagGroup = 1000
+	maxBasket   = 500
+)
+
+// Reasons a book is recommended, as the response lists them.
+const (
+	ReasonAuthor = "author"
+	ReasonTags   = "tags"
+	ReasonDecade = "decade"
+	ReasonBought = "together"
+)
+
+// Recommendation is a book related to another one with its score.
+type Recommendation struct {
+	BookID  string   `bson:"bookid"`
+	Score   int      `bson:"score"`
+	Reasons []string `bson:"reasons"`
+}
+
+// Recommendations are the related books of one book as the background job
+// last computed them, best first.
+type Recommendations struct {
+	BookID     string           `bson:"_id"`
+	Items      []Recommendation `bson:"items"`
+	ComputedAt time.Time        `bson:"computedat"`
+}
+
+type RecommendationResponse struct {
+	Book    BookResponse `json:"book"`
+	Score   int          `json:"score"`
+	Reasons []string     `json:"reasons"`
+}
+
+// candidate is what the job needs to know about a book.
+type candidate struct {
+	ID        string   `bson:"id"`
+	Author    string   `bson:"bookauthor"`
+	AuthorIDs []string `bson:"authorids"`
+	Tags      []string `bson:"tags"`
+	Year      string   `bson:"bookyear"`
+	WorkID    string   `bson:"workid"`
+}
+
+// decade returns the decade of a four digit year, or -1.
+func decade(year string) int {
+	y, err := strconv.Atoi(year)
+	if err != nil || len(year) != 4 {
+		return -1
+	}
+	return y / 10
+}
+
+// pairKey orders two book IDs so a pair has one key either way round.
+func pairKey(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// coOccurrences counts in how many baskets, the books of a list or of a
+// customer's orders, each pair of books appears together. Baskets above
+// maxBasket books say little about any one pair and are skipped.
+func coOccurrences(baskets [][]string) map[[2]string]int {
+	counts := map[[2]string]int{}
+	for _, basket := range baskets {
+		seen := map[string]bool{}
+		books := []string{}
+		for _, id := range basket {
+			if !seen[id] {
+				seen[id] = true
+				books = append(books, id)
+			}
+		}
+		if len(books) > maxBasket {
+			continue
+		}
+		for i := range books {
+			for j := i + 1; j < len(books); j++ {
+				counts[pairKey(books[i], books[j])]++
+			}
+		}
+	}
+	return counts
+}
+
+// scorePair rates how related two books are and says why.
+func scorePair(a, b candidate, together int) (int, []string) {
+	score, reasons := 0, []string{}
+	if sharesAuthor(a, b) {
+		score += authorWeight
+		reasons = append(reasons, ReasonAuthor)
+	}
+	if shared := min(sharedTags(a.Tags, b.Tags), maxSharedTags); shared > 0 {
+		score += shared * tagWeight
+		reasons = append(reasons, ReasonTags)
+	}
+	if together > 0 {
+		score += min(together, maxTogether) * togetherWeight
+		reasons = append(reasons, ReasonBought)
+	}
+	// The decade only sharpens a relation found otherwise; on its own it
+	// would relate half the catalog.
+	if score > 0 && decade(a.Year) >= 0 && decade(a.Year) == decade(b.Year) {
+		score += decadeWeight
+		reasons = append(reasons, ReasonDecade)
+	}
+	return score, reasons
+}
+
+func sharesAuthor(a, b candidate) bool {
+	for _, x := range a.AuthorIDs {
+		for _, y := range b.AuthorIDs {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return len(a.AuthorIDs) == 0 && len(b.AuthorIDs) == 0 && a.Author != "" && a.Author == b.Author
+}
+
+func sharedTags(a, b []string) int {
+	n := 0
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// recommend ranks the related books of every book. Candidates are only
+// the books sharing an author or a tag or appearing together in a basket,
+// so the work grows with the relations rather than the catalog squared.
+// Other editions of the same work are left out; the book page lists them
+// already.
+func recommend(books []candidate, pairs map[[2]string]int) map[string][]Recommendation {
+	byID := map[string]candidate{}
+	related := map[string]map[string]bool{}
+	relate := func(a, b string) {
+		if a == b {
+			return
+		}
+		if related[a] == nil {
+			related[a] = map[string]bool{}
+		}
+		related[a][b] = true
+	}
+
+	groups := map[string][]string{}
+	for _, book := range books {
+		byID[book.ID] = book
+		keys := []string{}
+		for _, id := range book.AuthorIDs {
+			keys = append(keys, "author:"+id)
+		}
+		if len(book.AuthorIDs) == 0 && book.Author != "" {
+			keys = append(keys, "name:"+book.Author)
+		}
+		for _, tag := range book.Tags {
+			keys = append(keys, "tag:"+tag)
+		}
+		for _, key := range keys {
+			groups[key] = append(groups[key], book.ID)
+		}
+	}
+	for _, ids := range groups {
+		if len(ids) > maxTagGroup {
+			continue
+		}
+		for _, a := range ids {
+			for _, b := range ids {
+				relate(a, b)
+			}
+		}
+	}
+	for pair := range pairs {
+		relate(pair[0], pair[1])
+		relate(pair[1], pair[0])
+	}
+
+	ret := map[string][]Recommendation{}
+	for id, others := range related {
+		book, ok := byID[id]
+		if !ok {
+			continue
+		}
+		items := []Recommendation{}
+		for otherID := range others {
+			other, ok := byID[otherID]
+			if !ok || (book.WorkID != "" && book.WorkID == other.WorkID) {
+				continue
+			}
+			if score, reasons := scorePair(book, other, pairs[pairKey(id, otherID)]); score > 0 {
+				items = append(items, Recommendation{BookID: otherID, Score: score, Reasons: reasons})
+			}
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Score != items[j].Score {
+				return items[i].Score > items[j].Score
+			}
+			return items[i].BookID < items[j].BookID
+		})
+		if len(items) > maxRecommended {
+			items = items[:maxRecommended]
+		}
+		if len(items) > 0 {
+			ret[id] = items
+		}
+	}
+	return ret
+}
+
+// recommender recomputes the recommendations collection in the background.
+type recommender struct {
+	books           *mongo.Collection
+	lists           *mongo.Collection
+	orders          *mongo.Collection
+	recommendations *mongo.Collection
+}
+
+// recommendationInterval is how often the job runs, RECOMMENDATIONS_INTERVAL
+// in Go duration syntax (default 1h). "0" turns the job off, e.g. for all
+// but one replica.
+func recommendationInterval() time.Duration {
+	value := os.Getenv("RECOMMENDATIONS_INTERVAL")
+	if value == "" {
+		return time.Hour
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// start runs the job right away and then every interval until ctx ends.
+func (r *recommender) start(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			started := time.Now()
+			if n, err := r.run(ctx); err != nil {
+				fmt.Printf("Error computing recommendations: %v\n", err)
+			} else {
+				fmt.Printf("Computed recommendations for %d books in %v\n", n, time.Since(started).Round(time.Millisecond))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// run computes the recommendations of every book and replaces the stored
+// ones. Books that no longer have any lose their entry.
+func (r *recommender) run(parent context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(parent, dbTimeout("recommendations"))
+	defer cancel()
+
+	cursor, err := r.books.Find(ctx, bson.M{"deletedat": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"id": 1, "bookauthor": 1, "authorids": 1, "tags": 1, "bookyear": 1, "workid": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var books []candidate
+	if err := cursor.All(ctx, &books); err != nil {
+		return 0, err
+	}
+
+	baskets, err := r.baskets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	computed := recommend(books, coOccurrences(baskets))
+
+	now := time.Now().UTC()
+	writes := []mongo.WriteModel{}
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := r.recommendations.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		writes = writes[:0]
+		return err
+	}
+	for id, items := range computed {
+		doc := Recommendations{BookID: id, Items: items, ComputedAt: now}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": id}).SetReplacement(doc).SetUpsert(true))
+		if len(writes) == 500 {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	_, err = r.recommendations.DeleteMany(ctx, bson.M{"computedat": bson.M{"$lt": now}})
+	return len(computed), err
+}
+
+// baskets collects the books of every list and of every customer's orders
+// that were not cancelled.
+func (r *recommender) baskets(ctx context.Context) ([][]string, error) {
+	var lists []struct {
+		Items []struct {
+			BookID string `bson:"bookid"`
+		} `bson:"items"`
+	}
+	cursor, err := r.lists.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"items.bookid": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &lists); err != nil {
+		return nil, err
+	}
+	baskets := [][]string{}
+	for _, list := range lists {
+		basket := []string{}
+		for _, item := range list.Items {
+			basket = append(basket, item.BookID)
+		}
+		baskets = append(baskets, basket)
+	}
+
+	cursor, err = r.orders.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$ne": "cancelled"}}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$group", Value: bson.M{"_id": "$userid", "books": bson.M{"$addToSet": "$items.bookid"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var customers []struct {
+		Books []string `bson:"books"`
+	}
+	if err := cursor.All(ctx, &customers); err != nil {
+		return nil, err
+	}
+	for _, customer := range customers {
+		baskets = append(baskets, customer.Books)
+	}
+	return baskets, nil
+}
+
+// getRecommendations answers with the books related to a book, best first,
+// as the background job last computed them. The books are looked up again
+// so deleted ones drop out and changes show right away.
+func getRecommendations(c echo.Context, coll, recommendations *mongo.Collection) error {
+	id := c.Param("id")
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > maxRecommended {
+		limit = maxRecommended
+	}
+
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	count, err := coll.CountDocuments(ctx, bson.M{"id": id, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return filterError(c, err)
+	}
+	if count == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book not found",
+		})
+	}
+
+	var stored Recommendations
+	err = recommendations.FindOne(ctx, bson.M{"_id": id}).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return filterError(c, err)
+	}
+	ret := []RecommendationResponse{}
+	if len(stored.Items) == 0 {
+		return c.JSON(http.StatusOK, ret)
+	}
+
+	ids := []string{}
+	for _, item := range stored.Items {
+		ids = append(ids, item.BookID)
+	}
+	cursor, err := coll.Find(ctx, bson.M{"id": bson.M{"$in": ids}, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return filterError(c, err)
+	}
+	var books []BookStore
+	if err := cursor.All(ctx, &books); err != nil {
+		return filterError(c, err)
+	}
+	byID := map[string]BookStore{}
+	for _, book := range books {
+		byID[book.ID] = book
+	}
+	for _, item := range stored.Items {
+		book, ok := byID[item.BookID]
+		if !ok {
+			continue
+		}
+		ret = append(ret, RecommendationResponse{Book: toBookResponse(book), Score: item.Score, Reasons: item.Reasons})
+		if len(ret) == limit {
+			break
+		}
+	}
+	return c.JSON(http.StatusOK, ret)
+}
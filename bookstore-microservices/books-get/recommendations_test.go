@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func recommendedIDs(items []Recommendation) []string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.BookID)
+	}
+	return ids
+}
+
+func TestScorePair(t *testing.T) {
+	dune := candidate{ID: "dune", AuthorIDs: []string{"herbert"}, Tags: []string{"sf", "desert"}, Year: "1965"}
+	messiah := candidate{ID: "messiah", AuthorIDs: []string{"herbert"}, Tags: []string{"sf"}, Year: "1969"}
+	score, reasons := scorePair(dune, messiah, 0)
+	if score != authorWeight+tagWeight+decadeWeight || !reflect.DeepEqual(reasons, []string{ReasonAuthor, ReasonTags, ReasonDecade}) {
+		t.Errorf("scorePair() = %d, %v", score, reasons)
+	}
+
+	stranger := candidate{ID: "stranger", Year: "1961"}
+	if score, reasons := scorePair(dune, stranger, 0); score != 0 || len(reasons) != 0 {
+		t.Errorf("the decade alone relates nothing: %d, %v", score, reasons)
+	}
+	if score, _ := scorePair(dune, stranger, 100); score != maxTogether*togetherWeight+decadeWeight {
+		t.Errorf("co-occurrences are capped: %d", score)
+	}
+
+	byName := candidate{ID: "a", Author: "Anonymous"}
+	if score, _ := scorePair(byName, candidate{ID: "b", Author: "Anonymous"}, 0); score != authorWeight {
+		t.Errorf("books without author IDs match by name: %d", score)
+	}
+}
+
+func TestCoOccurrences(t *testing.T) {
+	got := coOccurrences([][]string{{"a", "b", "c"}, {"b", "a", "a"}, {"c"}})
+	want := map[[2]string]int{{"a", "b"}: 2, {"a", "c"}: 1, {"b", "c"}: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("coOccurrences() = %v, want %v", got, want)
+	}
+
+	large := make([]string, maxBasket+1)
+	for i := range large {
+		large[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	if got := coOccurrences([][]string{large}); len(got) != 0 {
+		t.Errorf("large baskets are skipped, got %d pairs", len(got))
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	books := []candidate{
+		{ID: "dune", AuthorIDs: []string{"herbert"}, Tags: []string{"sf"}, Year: "1965", WorkID: "w-dune"},
+		{ID: "dune-2005", AuthorIDs: []string{"herbert"}, Tags: []string{"sf"}, Year: "2005", WorkID: "w-dune"},
+		{ID: "messiah", AuthorIDs: []string{"herbert"}, Year: "1969"},
+		{ID: "foundation", AuthorIDs: []string{"asimov"}, Tags: []string{"sf"}, Year: "1951"},
+		{ID: "cookbook", Year: "1965"},
+		{ID: "atlas", Year: "1990"},
+	}
+	pairs := coOccurrences([][]string{{"dune", "atlas"}, {"dune", "atlas", "foundation"}})
+
+	got := recommend(books, pairs)
+	if want := []string{"messiah", "atlas", "foundation"}; !reflect.DeepEqual(recommendedIDs(got["dune"]), want) {
+		t.Errorf("recommend()[dune] = %v, want %v", recommendedIDs(got["dune"]), want)
+	}
+	if _, ok := got["cookbook"]; ok {
+		t.Errorf("a book related to nothing has no recommendations: %v", got["cookbook"])
+	}
+	for _, item := range got["dune-2005"] {
+		if item.BookID == "dune" {
+			t.Errorf("editions of the same work are not recommended")
+		}
+	}
+}
+
+func TestDecade(t *testing.T) {
+	for year, want := range map[string]int{"1965": 196, "2000": 200, "": -1, "65": -1, "c. 1900": -1} {
+		if got := decade(year); got != want {
+			t.Errorf("decade(%q) = %d, want %d", year, got, want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Revision is a full copy of a book as it was after one write, as stored
+// by the write services.
+type Revision struct {
+	BookID    string    `bson:"bookid"`
+	Revision  int64     `bson:"revision"`
+	Action    string    `bson:"action"`
+	Actor     string    `bson:"actor"`
+	Timestamp time.Time `bson:"timestamp"`
+	Deleted   bool      `bson:"deleted,omitempty"`
+	Book      BookStore `bson:"book"`
+}
+
+type RevisionResponse struct {
+	Revision  int64        `json:"revision"`
+	Action    string       `json:"action"`
+	Actor     string       `json:"actor"`
+	Timestamp time.Time    `json:"timestamp"`
+	Deleted   bool         `json:"deleted"`
+	Book      BookResponse `json:"book"`
+}
+
+type RevisionDiff struct {
+	From    int64         `json:"from"`
+	To      int64         `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+func toRevisionResponse(rev Revision) RevisionResponse {
+	book := toBookResponse(rev.Book)
+	book.ID = rev.BookID
+	return RevisionResponse{
+		Revision:  rev.Revision,
+		Action:    rev.Action,
+		Actor:     rev.Actor,
+		Timestamp: rev.Timestamp,
+		Deleted:   rev.Deleted,
+		Book:      book,
+	}
+}
+
+// diffBooks lists the fields that differ between two versions of a book,
+// using the field names of the API.
+func diffBooks(from, to BookResponse) []FieldChange {
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"title", from.Title, to.Title},
+		{"author", from.Author, to.Author},
+		{"authorIds", strings.Join(from.AuthorIDs, ", "), strings.Join(to.AuthorIDs, ", ")},
+		{"pages", from.Pages, to.Pages},
+		{"edition", from.Edition, to.Edition},
+		{"year", from.Year, to.Year},
+		{"tags", strings.Join(from.Tags, ", "), strings.Join(to.Tags, ", ")},
+		{"subjects", strings.Join(from.Subjects, ", "), strings.Join(to.Subjects, ", ")},
+		{"publisherId", from.PublisherID, to.PublisherID},
+		{"series", from.Series, to.Series},
+		{"seriesPosition", formatPosition(from.SeriesPosition), formatPosition(to.SeriesPosition)},
+		{"workId", from.WorkID, to.WorkID},
+		{"format", from.Format, to.Format},
+		{"language", from.Language, to.Language},
+		{"price", formatMoney(from.Price), formatMoney(to.Price)},
+		{"discounts", formatDiscounts(from.Discounts), formatDiscounts(to.Discounts)},
+	}
+	changes := []FieldChange{}
+	for _, f := range fields {
+		if f.from != f.to {
+			changes = append(changes, FieldChange{Field: f.name, Before: f.from, After: f.to})
+		}
+	}
+	return changes
+}
+
+// formatPosition renders a series position, or "" when there is none.
+func formatPosition(p *float64) string {
+	if p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*p, 'f', -1, 64)
+}
+
+func revisionError(c echo.Context, err error) error {
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Revision not found",
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to fetch revisions",
+	})
+}
+
+func findRevision(c echo.Context, coll *mongo.Collection, filter bson.M, sort int) (Revision, error) {
+	ctx, cancel := dbContext(c.Request().Context(), "revisions")
+	defer cancel()
+	var rev Revision
+	opts := options.FindOne().SetSort(bson.D{{Key: "revision", Value: sort}})
+	err := coll.FindOne(ctx, filter, opts).Decode(&rev)
+	return rev, err
+}
+
+func revisionNumber(value string) (int64, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	return n, err == nil && n > 0
+}
+
+func listRevisions(c echo.Context, coll *mongo.Collection) error {
+	limit, offset := pagination(c, 100, 1000)
+
+	ctx, cancel := dbContext(c.Request().Context(), "revisions")
+	defer cancel()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "revision", Value: 1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := coll.Find(ctx, bson.M{"bookid": c.Param("id")}, opts)
+	if err != nil {
+		return revisionError(c, err)
+	}
+
+	var revisions []Revision
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return revisionError(c, err)
+	}
+	ret := []RevisionResponse{}
+	for _, rev := range revisions {
+		ret = append(ret, toRevisionResponse(rev))
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+func getRevision(c echo.Context, coll *mongo.Collection) error {
+	number, ok := revisionNumber(c.Param("rev"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Revision must be a positive number",
+		})
+	}
+	rev, err := findRevision(c, coll, bson.M{"bookid": c.Param("id"), "revision": number}, 1)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	return c.JSON(http.StatusOK, toRevisionResponse(rev))
+}
+
+// getBookAsOf returns the book as it was at the RFC 3339 time in at, or as
+// of revision number revision. A book that was deleted at that point is
+// reported as not found.
+func getBookAsOf(c echo.Context, coll *mongo.Collection) error {
+	filter := bson.M{"bookid": c.Param("id")}
+	if at := c.QueryParam("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "at must be an RFC 3339 timestamp",
+			})
+		}
+		filter["timestamp"] = bson.M{"$lte": t}
+	} else if number, ok := revisionNumber(c.QueryParam("revision")); ok {
+		filter["revision"] = bson.M{"$lte": number}
+	} else {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Either at or revision is required",
+		})
+	}
+
+	rev, err := findRevision(c, coll, filter, -1)
+	if err == mongo.ErrNoDocuments || (err == nil && rev.Deleted) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book did not exist at that point",
+		})
+	}
+	if err != nil {
+		return revisionError(c, err)
+	}
+	return c.JSON(http.StatusOK, toRevisionResponse(rev))
+}
+
+func diffRevisions(c echo.Context, coll *mongo.Collection) error {
+	from, okFrom := revisionNumber(c.QueryParam("from"))
+	to, okTo := revisionNumber(c.QueryParam("to"))
+	if !okFrom || !okTo {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "from and to must be revision numbers",
+		})
+	}
+
+	id := c.Param("id")
+	fromRev, err := findRevision(c, coll, bson.M{"bookid": id, "revision": from}, 1)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	toRev, err := findRevision(c, coll, bson.M{"bookid": id, "revision": to}, 1)
+	if err != nil {
+		return revisionError(c, err)
+	}
+	return c.JSON(http.StatusOK, RevisionDiff{
+		From:    from,
+		To:      to,
+		Changes: diffBooks(toRevisionResponse(fromRev).Book, toRevisionResponse(toRev).Book),
+	})
+}
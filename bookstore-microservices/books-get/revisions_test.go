@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestDiffBooks(t *testing.T) {
+	from := BookResponse{ID: "b1", Title: "Dune", Author: "Frank Herbert", Year: "1965"}
+	to := BookResponse{ID: "b1", Title: "Dune", Author: "Frank Herbert", Year: "1966", Pages: "412"}
+
+	changes := diffBooks(from, to)
+	if len(changes) != 2 {
+		t.Fatalf("expected two changes, got %+v", changes)
+	}
+	if changes[0].Field != "pages" || changes[0].After != "412" {
+		t.Errorf("unexpected first change %+v", changes[0])
+	}
+	if changes[1].Field != "year" || changes[1].Before != "1965" || changes[1].After != "1966" {
+		t.Errorf("unexpected second change %+v", changes[1])
+	}
+	if len(diffBooks(from, from)) != 0 {
+		t.Error("identical books should have no changes")
+	}
+}
+
+func TestDiffBooksListsTagsSubjectsAndAuthors(t *testing.T) {
+	from := BookResponse{Title: "Dune", AuthorIDs: []string{"herbert"}, Tags: []string{"classic"}}
+	to := BookResponse{Title: "Dune", AuthorIDs: []string{"herbert"}, Tags: []string{"classic", "scifi"}, Subjects: []string{"FIC028000"}}
+
+	changes := diffBooks(from, to)
+	if len(changes) != 2 {
+		t.Fatalf("expected two changes, got %+v", changes)
+	}
+	if changes[0].Field != "tags" || changes[0].Before != "classic" || changes[0].After != "classic, scifi" {
+		t.Errorf("unexpected tags change %+v", changes[0])
+	}
+	if changes[1].Field != "subjects" || changes[1].After != "FIC028000" {
+		t.Errorf("unexpected subjects change %+v", changes[1])
+	}
+}
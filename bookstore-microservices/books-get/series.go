@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Publisher is an entry of the publisher list. Books refer to it by code.
+type Publisher struct {
+	Code    string `bson:"_id"`
+	Name    string `bson:"name"`
+	Website string `bson:"website,omitempty"`
+}
+
+type PublisherResponse struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Website string `json:"website"`
+	Books   int64  `json:"books"`
+}
+
+// SeriesResponse lists the books of a series in reading order, with the
+// neighbours of the requested book.
+type SeriesResponse struct {
+	Series   string         `json:"series"`
+	Books    []BookResponse `json:"books"`
+	Previous *BookResponse  `json:"previous"`
+	Next     *BookResponse  `json:"next"`
+}
+
+// normalizeSeries tidies the whitespace of a series name.
+func normalizeSeries(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// sortSeries puts the books of a series in reading order: by position,
+// books without one last, then by year and title.
+func sortSeries(books []BookStore) {
+	sort.SliceStable(books, func(i, j int) bool {
+		a, b := books[i], books[j]
+		if (a.SeriesPos == nil) != (b.SeriesPos == nil) {
+			return a.SeriesPos != nil
+		}
+		if a.SeriesPos != nil && *a.SeriesPos != *b.SeriesPos {
+			return *a.SeriesPos < *b.SeriesPos
+		}
+		if a.BookYear != b.BookYear {
+			return a.BookYear < b.BookYear
+		}
+		return a.BookName < b.BookName
+	})
+}
+
+// seriesOf builds the series response for the book with the given ID out of
+// the books of its series.
+func seriesOf(id, series string, books []BookStore) SeriesResponse {
+	sortSeries(books)
+	ret := SeriesResponse{Series: series, Books: []BookResponse{}}
+	for _, book := range books {
+		ret.Books = append(ret.Books, toBookResponse(book))
+	}
+	for i := range ret.Books {
+		if ret.Books[i].ID != id {
+			continue
+		}
+		if i > 0 {
+			ret.Previous = &ret.Books[i-1]
+		}
+		if i < len(ret.Books)-1 {
+			ret.Next = &ret.Books[i+1]
+		}
+	}
+	return ret
+}
+
+// getBookSeries answers with the series of a book. A book outside any
+// series gets an empty one.
+func getBookSeries(c echo.Context, coll *mongo.Collection) error {
+	id := c.Param("id")
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+
+	var book BookStore
+	err := coll.FindOne(ctx, bson.M{"id": id, "deletedat": bson.M{"$exists": false}}).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book not found",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+	if book.Series == "" {
+		return c.JSON(http.StatusOK, seriesOf(id, "", nil))
+	}
+
+	cursor, err := coll.Find(ctx, bson.M{"series": book.Series, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return filterError(c, err)
+	}
+	var books []BookStore
+	if err := cursor.All(ctx, &books); err != nil {
+		return filterError(c, err)
+	}
+	return c.JSON(http.StatusOK, seriesOf(id, book.Series, books))
+}
+
+func loadPublishers(ctx context.Context, coll *mongo.Collection) ([]Publisher, error) {
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var publishers []Publisher
+	err = cursor.All(ctx, &publishers)
+	return publishers, err
+}
+
+// getPublishers lists the publishers by name with their number of books.
+func getPublishers(c echo.Context, publisherColl, books *mongo.Collection) error {
+	ctx, cancel := dbContext(c.Request().Context(), "stats")
+	defer cancel()
+	publishers, err := loadPublishers(ctx, publisherColl)
+	if err != nil {
+		return statsError(c, err)
+	}
+	counts, err := countValues(ctx, books, "publisherid")
+	if err != nil {
+		return statsError(c, err)
+	}
+
+	ret := []PublisherResponse{}
+	for _, p := range publishers {
+		ret = append(ret, PublisherResponse{Code: p.Code, Name: p.Name, Website: p.Website, Books: counts[p.Code]})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return c.JSON(http.StatusOK, ret)
+}
+
+func getPublisher(c echo.Context, coll, books *mongo.Collection) error {
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	var p Publisher
+	err := coll.FindOne(ctx, bson.M{"_id": c.Param("code")}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Publisher not found",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+	count, err := books.CountDocuments(ctx, bson.M{"publisherid": p.Code, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return filterError(c, err)
+	}
+	return c.JSON(http.StatusOK, PublisherResponse{Code: p.Code, Name: p.Name, Website: p.Website, Books: count})
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func position(p float64) *float64 {
+	return &p
+}
+
+func TestSeriesOrderAndNeighbours(t *testing.T) {
+	books := []BookStore{
+		{ID: "extra", BookName: "Extra", BookYear: "1990"},
+		{ID: "three", BookName: "Three", SeriesPos: position(3)},
+		{ID: "one", BookName: "One", SeriesPos: position(1)},
+		{ID: "novella", BookName: "Novella", SeriesPos: position(1.5)},
+	}
+	got := seriesOf("novella", "Saga", books)
+
+	var order []string
+	for _, b := range got.Books {
+		order = append(order, b.ID)
+	}
+	want := []string{"one", "novella", "three", "extra"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+	if got.Previous == nil || got.Previous.ID != "one" || got.Next == nil || got.Next.ID != "three" {
+		t.Errorf("unexpected neighbours %+v %+v", got.Previous, got.Next)
+	}
+
+	first := seriesOf("one", "Saga", books)
+	if first.Previous != nil || first.Next == nil || first.Next.ID != "novella" {
+		t.Errorf("the first book has only a next one: %+v %+v", first.Previous, first.Next)
+	}
+}
+
+func TestSeriesOfBookOutsideSeries(t *testing.T) {
+	got := seriesOf("solo", "", nil)
+	if got.Books == nil || len(got.Books) != 0 || got.Previous != nil || got.Next != nil {
+		t.Errorf("expected an empty series, got %+v", got)
+	}
+}
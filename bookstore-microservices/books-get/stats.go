@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AuthorCount struct {
+	AuthorID    string   `json:"authorId"`
+	Name        string   `json:"name"`
+	SortName    string   `json:"sortName"`
+	BirthYear   *int     `json:"birthYear"`
+	DeathYear   *int     `json:"deathYear"`
+	Nationality string   `json:"nationality"`
+	Aliases     []string `json:"aliases"`
+	Books       int64    `json:"books"`
+}
+
+type PageStats struct {
+	Books   int64   `json:"books"`
+	Total   int64   `json:"total"`
+	Min     int64   `json:"min"`
+	Max     int64   `json:"max"`
+	Average float64 `json:"average"`
+}
+
+var activeBooks = bson.D{{Key: "$match", Value: bson.M{"deletedat": bson.M{"$exists": false}}}}
+
+// asInt converts a string field to an int, or null when it is not a number.
+func asInt(field string) bson.M {
+	return bson.M{"$convert": bson.M{"input": "$" + field, "to": "int", "onError": nil, "onNull": nil}}
+}
+
+func paginate(limit, offset int64) []bson.D {
+	return []bson.D{
+		{{Key: "$skip", Value: offset}},
+		{{Key: "$limit", Value: limit}},
+	}
+}
+
+// authorCountsPipeline counts the books linked to each author. sort is
+// "name" (by sort name) or "count" (most books first).
+func authorCountsPipeline(sort string, limit, offset int64) mongo.Pipeline {
+	return append(authorGroups(activeBooks, sort), paginate(limit, offset)...)
+}
+
+// authorGroups counts the books passing match per linked author, in the
+// order of sort.
+func authorGroups(match bson.D, sort string) mongo.Pipeline {
+	order := bson.D{{Key: "author.sortname", Value: 1}, {Key: "_id", Value: 1}}
+	if sort == "count" {
+		order = bson.D{{Key: "books", Value: -1}, {Key: "author.sortname", Value: 1}, {Key: "_id", Value: 1}}
+	}
+	return mongo.Pipeline{
+		match,
+		{{Key: "$unwind", Value: "$authorids"}},
+		{{Key: "$group", Value: bson.M{"_id": "$authorids", "books": bson.M{"$sum": 1}}}},
+		{{Key: "$addFields", Value: bson.M{
+			"authoroid": bson.M{"$convert": bson.M{"input": "$_id", "to": "objectId", "onError": nil}},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "authors",
+			"localField":   "authoroid",
+			"foreignField": "_id",
+			"as":           "author",
+		}}},
+		{{Key: "$unwind", Value: "$author"}},
+		{{Key: "$sort", Value: order}},
+	}
+}
+
+// periodCountsPipeline counts books per publication year or, with decade,
+// per decade. Books without a numeric year are left out.
+func periodCountsPipeline(decade bool, limit, offset int64) mongo.Pipeline {
+	return append(periodGroups(activeBooks, decade), paginate(limit, offset)...)
+}
+
+// periodGroups counts the books passing match per year or decade.
+func periodGroups(match bson.D, decade bool) mongo.Pipeline {
+	period := interface{}("$year")
+	if decade {
+		period = bson.M{"$multiply": bson.A{bson.M{"$floor": bson.M{"$divide": bson.A{"$year", 10}}}, 10}}
+	}
+	return mongo.Pipeline{
+		match,
+		{{Key: "$project", Value: bson.M{"year": asInt("bookyear")}}},
+		{{Key: "$match", Value: bson.M{"year": bson.M{"$ne": nil}}}},
+		{{Key: "$group", Value: bson.M{"_id": period, "books": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+}
+
+// pageStatsPipeline summarises the page counts of books that have one.
+func pageStatsPipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		activeBooks,
+		{{Key: "$project", Value: bson.M{"pages": asInt("bookpages")}}},
+		{{Key: "$match", Value: bson.M{"pages": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"books":   bson.M{"$sum": 1},
+			"total":   bson.M{"$sum": "$pages"},
+			"min":     bson.M{"$min": "$pages"},
+			"max":     bson.M{"$max": "$pages"},
+			"average": bson.M{"$avg": "$pages"},
+		}}},
+	}
+}
+
+func statsError(c echo.Context, err error) error {
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to compute statistics",
+	})
+}
+
+func getAuthorCounts(c echo.Context, coll *mongo.Collection) error {
+	sort := c.QueryParam("sort")
+	if sort != "" && sort != "name" && sort != "count" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "sort must be name or count",
+		})
+	}
+	limit, offset := pagination(c, 100, 1000)
+
+	ctx, cancel := dbContext(c.Request().Context(), "stats")
+	defer cancel()
+	cursor, err := coll.Aggregate(ctx, authorCountsPipeline(sort, limit, offset))
+	if err != nil {
+		return statsError(c, err)
+	}
+	var rows []struct {
+		ID     string `bson:"_id"`
+		Books  int64  `bson:"books"`
+		Author struct {
+			Name        string   `bson:"name"`
+			SortName    string   `bson:"sortname"`
+			BirthYear   *int     `bson:"birthyear"`
+			DeathYear   *int     `bson:"deathyear"`
+			Nationality string   `bson:"nationality"`
+			Aliases     []string `bson:"aliases"`
+		} `bson:"author"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return statsError(c, err)
+	}
+
+	ret := []AuthorCount{}
+	for _, row := range rows {
+		aliases := row.Author.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		ret = append(ret, AuthorCount{
+			AuthorID:    row.ID,
+			Name:        row.Author.Name,
+			SortName:    row.Author.SortName,
+			BirthYear:   row.Author.BirthYear,
+			DeathYear:   row.Author.DeathYear,
+			Nationality: row.Author.Nationality,
+			Aliases:     aliases,
+			Books:       row.Books,
+		})
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// getPeriodCounts answers with {"year": y, "books": n} objects, or
+// {"decade": d, "books": n} with by=decade.
+func getPeriodCounts(c echo.Context, coll *mongo.Collection) error {
+	by := c.QueryParam("by")
+	if by == "" {
+		by = "year"
+	}
+	if by != "year" && by != "decade" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "by must be year or decade",
+		})
+	}
+	limit, offset := pagination(c, 100, 1000)
+
+	ctx, cancel := dbContext(c.Request().Context(), "stats")
+	defer cancel()
+	cursor, err := coll.Aggregate(ctx, periodCountsPipeline(by == "decade", limit, offset))
+	if err != nil {
+		return statsError(c, err)
+	}
+	var rows []struct {
+		Period int64 `bson:"_id"`
+		Books  int64 `bson:"books"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return statsError(c, err)
+	}
+
+	ret := []map[string]int64{}
+	for _, row := range rows {
+		ret = append(ret, map[string]int64{by: row.Period, "books": row.Books})
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+func getPageStats(c echo.Context, coll *mongo.Collection) error {
+	ctx, cancel := dbContext(c.Request().Context(), "stats")
+	defer cancel()
+	cursor, err := coll.Aggregate(ctx, pageStatsPipeline())
+	if err != nil {
+		return statsError(c, err)
+	}
+	var rows []struct {
+		Books   int64   `bson:"books"`
+		Total   int64   `bson:"total"`
+		Min     int64   `bson:"min"`
+		Max     int64   `bson:"max"`
+		Average float64 `bson:"average"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return statsError(c, err)
+	}
+
+	stats := PageStats{}
+	if len(rows) > 0 {
+		stats = PageStats(rows[0])
+	}
+	return c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStatsPipelinesPaginateLast(t *testing.T) {
+	for name, pipeline := range map[string][]bson.D{
+		"authors": authorCountsPipeline("count", 10, 20),
+		"years":   periodCountsPipeline(true, 10, 20),
+	} {
+		n := len(pipeline)
+		if pipeline[n-2][0].Key != "$skip" || pipeline[n-2][0].Value != int64(20) {
+			t.Errorf("%s: expected $skip 20 before the limit, got %v", name, pipeline[n-2])
+		}
+		if pipeline[n-1][0].Key != "$limit" || pipeline[n-1][0].Value != int64(10) {
+			t.Errorf("%s: expected $limit 10 last, got %v", name, pipeline[n-1])
+		}
+		if pipeline[0][0].Key != "$match" {
+			t.Errorf("%s: deleted books must be filtered first", name)
+		}
+	}
+}
+
+func TestAuthorCountsSortOrder(t *testing.T) {
+	sortStage := func(p []bson.D) bson.D {
+		for _, stage := range p {
+			if stage[0].Key == "$sort" {
+				return stage[0].Value.(bson.D)
+			}
+		}
+		t.Fatal("no $sort stage")
+		return nil
+	}
+	if first := sortStage(authorCountsPipeline("", 1, 0))[0].Key; first != "author.sortname" {
+		t.Errorf("default sort starts with %s", first)
+	}
+	if first := sortStage(authorCountsPipeline("count", 1, 0))[0]; first.Key != "books" || first.Value != -1 {
+		t.Errorf("count sort starts with %v", first)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Subject is an entry of the controlled subject vocabulary. Subjects form a
+// tree through Parent.
+type Subject struct {
+	Code   string `bson:"_id"`
+	Name   string `bson:"name"`
+	Parent string `bson:"parent,omitempty"`
+}
+
+type SubjectResponse struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+	Books  int64  `json:"books"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Books int64  `json:"books"`
+}
+
+var errUnknownSubject = errors.New("unknown subject")
+
+// normalizeTag lower-cases a free-form tag and tidies its whitespace.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// normalizeSubjectCode turns a subject code into its canonical form, e.g.
+// "Science Fiction" into "science-fiction".
+func normalizeSubjectCode(code string) string {
+	return strings.Join(strings.Fields(strings.ToLower(code)), "-")
+}
+
+// normalizeList applies normalize to every value, dropping empty values and
+// duplicates. The result is never nil.
+func normalizeList(values []string, normalize func(string) string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		v = normalize(v)
+		if v != "" && !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// subjectDescendants returns code and the codes of every subject below it.
+func subjectDescendants(subjects []Subject, code string) []string {
+	children := map[string][]string{}
+	for _, s := range subjects {
+		if s.Parent != "" {
+			children[s.Parent] = append(children[s.Parent], s.Code)
+		}
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	queue := []string{code}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		result = append(result, next)
+		queue = append(queue, children[next]...)
+	}
+	return result
+}
+
+func loadSubjects(ctx context.Context, coll *mongo.Collection) ([]Subject, error) {
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var subjects []Subject
+	err = cursor.All(ctx, &subjects)
+	return subjects, err
+}
+
+// catalogFilter is bookFilter plus the subject filter: with subject, books
+// filed under that subject or any subject below it match.
+func catalogFilter(c echo.Context, subjectColl *mongo.Collection) (bson.M, error) {
+	filter := bookFilter(c)
+	code := normalizeSubjectCode(c.QueryParam("subject"))
+	if code == "" {
+		return filter, nil
+	}
+
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	subjects, err := loadSubjects(ctx, subjectColl)
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, s := range subjects {
+		known = known || s.Code == code
+	}
+	if !known {
+		return nil, errUnknownSubject
+	}
+	filter["subjects"] = bson.M{"$in": subjectDescendants(subjects, code)}
+	return filter, nil
+}
+
+func filterError(c echo.Context, err error) error {
+	if err == errUnknownSubject {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Unknown subject",
+		})
+	}
+	if err == errUnknownSort {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to fetch books",
+	})
+}
+
+// getSubjects lists the vocabulary by name, with the number of books filed
+// directly under each subject.
+func getSubjects(c echo.Context, subjectColl, books *mongo.Collection) error {
+	ctx, cancel := dbContext(c.Request().Context(), "stats")
+	defer cancel()
+	subjects, err := loadSubjects(ctx, subjectColl)
+	if err != nil {
+		return statsError(c, err)
+	}
+	counts, err := countValues(ctx, books, "subjects")
+	if err != nil {
+		return statsError(c, err)
+	}
+
+	ret := []SubjectResponse{}
+	for _, s := range subjects {
+		ret = append(ret, SubjectResponse{Code: s.Code, Name: s.Name, Parent: s.Parent, Books: counts[s.Code]})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return c.JSON(http.StatusOK, ret)
+}
+
+// countValues counts the active books per value of an array field.
+func countValues(ctx context.Context, books *mongo.Collection, field string) (map[string]int64, error) {
+	cursor, err := books.Aggregate(ctx, mongo.Pipeline{
+		activeBooks,
+		{{Key: "$unwind", Value: "$" + field}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "books": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Value string `bson:"_id"`
+		Books int64  `bson:"books"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Value] = row.Books
+	}
+	return counts, nil
+}
+
+// getTagCounts lists tags with their number of books, most used first.
+func getTagCounts(c echo.Context, coll *mongo.Collection) error {
+	limit, offset := pagination(c, 100, 1000)
+
+	ctx, cancel := dbContext(c.Request().Context(), "stats")
+	defer cancel()
+	pipeline := mongo.Pipeline{
+		activeBooks,
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "books": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "books", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := coll.Aggregate(ctx, append(pipeline, paginate(limit, offset)...))
+	if err != nil {
+		return statsError(c, err)
+	}
+	var rows []struct {
+		Tag   string `bson:"_id"`
+		Books int64  `bson:"books"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return statsError(c, err)
+	}
+
+	ret := []TagCount{}
+	for _, row := range rows {
+		ret = append(ret, TagCount{Tag: row.Tag, Books: row.Books})
+	}
+	return c.JSON(http.StatusOK, ret)
+}
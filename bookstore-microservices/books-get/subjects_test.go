@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSubjectDescendants(t *testing.T) {
+	subjects := []Subject{
+		{Code: "fiction"},
+		{Code: "science-fiction", Parent: "fiction"},
+		{Code: "space-opera", Parent: "science-fiction"},
+		{Code: "history"},
+	}
+	got := subjectDescendants(subjects, "fiction")
+	sort.Strings(got)
+	want := []string{"fiction", "science-fiction", "space-opera"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("descendants = %v, want %v", got, want)
+	}
+	if got := subjectDescendants(subjects, "history"); !reflect.DeepEqual(got, []string{"history"}) {
+		t.Errorf("leaf descendants = %v", got)
+	}
+}
+
+func TestBookFilterTagsAndDecade(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/books?tag=Gothic&tag=+horror+&decade=1810", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	filter := bookFilter(c)
+
+	if !reflect.DeepEqual(filter["tags"], bson.M{"$all": []string{"gothic", "horror"}}) {
+		t.Errorf("tags filter = %v", filter["tags"])
+	}
+	if filter["bookyear"] != (primitive.Regex{Pattern: "^181[0-9]$"}) {
+		t.Errorf("decade filter = %v", filter["bookyear"])
+	}
+
+	req = httptest.NewRequest("GET", "/api/books?decade=1815", nil)
+	if _, ok := bookFilter(echo.New().NewContext(req, httptest.NewRecorder()))["bookyear"]; ok {
+		t.Error("decades must be multiples of ten")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Work is a title independent of its editions; every book is an edition of
+// one work.
+type Work struct {
+	MongoID   primitive.ObjectID `bson:"_id"`
+	Title     string             `bson:"title"`
+	Author    string             `bson:"author"`
+	AuthorIDs []string           `bson:"authorids"`
+	Year      string             `bson:"year,omitempty"`
+}
+
+// EditionResponse is a book seen as an edition of its work.
+type EditionResponse struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	ISBN        string `json:"isbn"`
+	PublisherID string `json:"publisherId"`
+	Pages       string `json:"pages"`
+	Format      string `json:"format"`
+	Language    string `json:"language"`
+	Year        string `json:"year"`
+	// EffectivePrice is the price at the time of the request.
+	Price          *Money `json:"price"`
+	EffectivePrice *Money `json:"effectivePrice"`
+}
+
+type WorkResponse struct {
+	ID           string            `json:"id"`
+	Title        string            `json:"title"`
+	Author       string            `json:"author"`
+	AuthorIDs    []string          `json:"authorIds"`
+	Year         string            `json:"year"`
+	EditionCount int64             `json:"editionCount"`
+	Editions     []EditionResponse `json:"editions,omitempty"`
+}
+
+func toEditionResponse(book BookStore) EditionResponse {
+	ret := EditionResponse{
+		ID:          book.ID,
+		Title:       book.BookName,
+		Author:      book.BookAuthor,
+		ISBN:        book.BookEdition,
+		PublisherID: book.PublisherID,
+		Pages:       book.BookPages,
+		Format:      book.Format,
+		Language:    book.Language,
+		Year:        book.BookYear,
+		Price:       book.Price,
+	}
+	if book.Price != nil {
+		price, _ := effectivePrice(*book.Price, book.Discounts, time.Now())
+		ret.EffectivePrice = &price
+	}
+	return ret
+}
+
+func toWorkResponse(work Work, editions int64) WorkResponse {
+	return WorkResponse{
+		ID:           work.MongoID.Hex(),
+		Title:        work.Title,
+		Author:       work.Author,
+		AuthorIDs:    nonNil(work.AuthorIDs),
+		Year:         work.Year,
+		EditionCount: editions,
+	}
+}
+
+// sortEditions orders editions by year, oldest first, then by ID.
+func sortEditions(books []BookStore) {
+	sort.SliceStable(books, func(i, j int) bool {
+		if books[i].BookYear != books[j].BookYear {
+			return books[i].BookYear < books[j].BookYear
+		}
+		return books[i].ID < books[j].ID
+	})
+}
+
+// getWorks lists works by title, optionally matching q against title and
+// author, with the number of editions of each.
+func getWorks(c echo.Context, workColl, books *mongo.Collection) error {
+	limit, offset := pagination(c, 100, 1000)
+	filter := bson.M{}
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		filter["$or"] = bson.A{
+			bson.M{"title": containsPattern(q)},
+			bson.M{"author": containsPattern(q)},
+		}
+	}
+
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := workColl.Find(ctx, filter, opts)
+	if err != nil {
+		return filterError(c, err)
+	}
+	var works []Work
+	if err := cursor.All(ctx, &works); err != nil {
+		return filterError(c, err)
+	}
+	counts, err := countValues(ctx, books, "workid")
+	if err != nil {
+		return filterError(c, err)
+	}
+
+	ret := []WorkResponse{}
+	for _, w := range works {
+		ret = append(ret, toWorkResponse(w, counts[w.MongoID.Hex()]))
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// getWork answers with a work and its editions, or with only the editions.
+func getWork(c echo.Context, workColl, books *mongo.Collection, withWork bool) error {
+	id := c.Param("id")
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Work not found",
+		})
+	}
+
+	ctx, cancel := dbContext(c.Request().Context(), "list")
+	defer cancel()
+	var work Work
+	err = workColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&work)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Work not found",
+		})
+	}
+	if err != nil {
+		return filterError(c, err)
+	}
+
+	cursor, err := books.Find(ctx, bson.M{"workid": id, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return filterError(c, err)
+	}
+	var found []BookStore
+	if err := cursor.All(ctx, &found); err != nil {
+		return filterError(c, err)
+	}
+	sortEditions(found)
+	editions := []EditionResponse{}
+	for _, book := range found {
+		editions = append(editions, toEditionResponse(book))
+	}
+
+	if !withWork {
+		return c.JSON(http.StatusOK, editions)
+	}
+	ret := toWorkResponse(work, int64(len(editions)))
+	ret.Editions = editions
+	return c.JSON(http.StatusOK, ret)
+}
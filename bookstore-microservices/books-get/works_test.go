@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEditionResponseCarriesISBN(t *testing.T) {
+	got := toEditionResponse(BookStore{
+		ID:          "b1",
+		BookName:    "Frankenstein",
+		BookEdition: "978-0-14-143947-1",
+		Format:      "paperback",
+		Language:    "en",
+	})
+	if got.ISBN != "978-0-14-143947-1" || got.Format != "paperback" || got.Language != "en" {
+		t.Errorf("unexpected edition %+v", got)
+	}
+}
+
+func TestSortEditions(t *testing.T) {
+	books := []BookStore{
+		{ID: "c", BookYear: "2003"},
+		{ID: "b", BookYear: "1831"},
+		{ID: "a", BookYear: "2003"},
+	}
+	sortEditions(books)
+	if books[0].ID != "b" || books[1].ID != "a" || books[2].ID != "c" {
+		t.Errorf("unexpected order %v %v %v", books[0].ID, books[1].ID, books[2].ID)
+	}
+}
+
+func TestWorkResponseHasAuthorList(t *testing.T) {
+	got := toWorkResponse(Work{MongoID: primitive.NewObjectID(), Title: "Frankenstein"}, 3)
+	if got.AuthorIDs == nil || got.EditionCount != 3 || got.ID == "" {
+		t.Errorf("unexpected work %+v", got)
+	}
+}
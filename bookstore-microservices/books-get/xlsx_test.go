@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestXLSXColumn(t *testing.T) {
+	cases := map[int]string{0: "A", 5: "F", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for i, want := range cases {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestXLSXWriterProducesWorkbook(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newXLSXExport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.WriteBook(BookResponse{ID: "b1", Title: "Tom & Jerry <2>", Author: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(body)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook is missing part %s", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	if !strings.Contains(sheet, `<c r="B2" t="inlineStr"><is><t xml:space="preserve">Tom &amp; Jerry &lt;2&gt;</t>`) {
+		t.Errorf("sheet does not contain the escaped title cell:\n%s", sheet)
+	}
+	if !strings.HasSuffix(sheet, "</sheetData></worksheet>") {
+		t.Error("sheet is not closed")
+	}
+}
+
+func TestCSVExportWritesHeader(t *testing.T) {
+	var buf bytes.Buffer
+	out, _ := newCSVExport(&buf)
+	out.WriteBook(BookResponse{ID: "b1", Title: "Frankenstein, or", Author: "Mary Shelley"})
+	out.Close()
+
+	want := "id,title,author,pages,edition,year\nb1,\"Frankenstein, or\",Mary Shelley,,,\n"
+	if buf.String() != want {
+		t.Errorf("csv = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	before := bson.M{"_id": "x", "bookname": "Dune", "bookpages": int32(412), "updatedat": 1}
+	after := bson.M{"_id": "x", "bookname": "Dune Messiah", "bookpages": int32(412), "updatedat": 2}
+
+	changes := diffDocuments(before, after)
+	if len(changes) != 1 {
+		t.Fatalf("expected one change, got %+v", changes)
+	}
+	if c := changes[0]; c.Field != "bookname" || c.Before != "Dune" || c.After != "Dune Messiah" {
+		t.Errorf("unexpected change %+v", c)
+	}
+}
+
+func TestDiffDocumentsCreate(t *testing.T) {
+	changes := diffDocuments(nil, bson.M{"bookname": "Dune", "bookauthor": "Frank Herbert"})
+	if len(changes) != 2 || changes[0].Field != "bookauthor" || changes[1].Field != "bookname" {
+		t.Fatalf("expected sorted creation changes, got %+v", changes)
+	}
+	if changes[0].Before != nil {
+		t.Errorf("created fields have no previous value, got %v", changes[0].Before)
+	}
+}
+
+func TestToDocumentNormalizesUnchangedFields(t *testing.T) {
+	score := 4.5
+	before, err := toDocument(bson.M{"tags": bson.A{"scifi"}, "score": 4.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := toDocument(bson.M{"tags": []string{"scifi"}, "score": &score})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := diffDocuments(before, after); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// Roles are ordered: every role includes the permissions of those before it.
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{RoleReader: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string
+	Name    string
+	Roles   []string
+}
+
+// HasRole reports whether the principal holds role or a role above it.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if roleRank[r] >= roleRank[role] && roleRank[r] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Name  string   `json:"name,omitempty"`
+	Role  string   `json:"role,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// authConfig holds the keys used to verify bearer tokens. HS256 tokens are
+// checked against JWT_HS256_SECRET and RS256 tokens against the RSA keys in
+// the JWKS file named by JWT_JWKS_FILE. JWT_ISSUER and JWT_AUDIENCE are
+// enforced when set. The placeholder secret of the example configuration is
+// refused, since anyone could sign tokens with it.
+type authConfig struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	issuer     string
+	audience   string
+}
+
+const placeholderSecret = "change-me"
+
+var errPlaceholderSecret = errors.New(`JWT_HS256_SECRET is the placeholder "change-me"; set a secret of your own`)
+
+func loadAuthConfig() (*authConfig, error) {
+	cfg := &authConfig{
+		hmacSecret: []byte(os.Getenv("JWT_HS256_SECRET")),
+		rsaKeys:    map[string]*rsa.PublicKey{},
+		issuer:     os.Getenv("JWT_ISSUER"),
+		audience:   os.Getenv("JWT_AUDIENCE"),
+	}
+	if string(cfg.hmacSecret) == placeholderSecret {
+		return nil, errPlaceholderSecret
+	}
+	if path := os.Getenv("JWT_JWKS_FILE"); path != "" {
+		keys, err := loadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.rsaKeys = keys
+	}
+	if len(cfg.hmacSecret) == 0 && len(cfg.rsaKeys) == 0 {
+		fmt.Println("Warning: neither JWT_HS256_SECRET nor JWT_JWKS_FILE is set, all authenticated requests will be rejected")
+	}
+	return cfg, nil
+}
+
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS file: %w", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS file: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: invalid modulus: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: invalid exponent: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func (a *authConfig) keyFor(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case "HS256":
+		if len(a.hmacSecret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return a.hmacSecret, nil
+	case "RS256":
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(a.rsaKeys) == 1 {
+			for _, key := range a.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// authenticate verifies a bearer token and returns its principal.
+func (a *authConfig) authenticate(tokenString string) (*Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+	}
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+
+	var claims tokenClaims
+	if _, err := jwt.ParseWithClaims(tokenString, &claims, a.keyFor, opts...); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	roles := claims.Roles
+	if claims.Role != "" {
+		roles = append(roles, claims.Role)
+	}
+	return &Principal{Subject: claims.Subject, Name: claims.Name, Roles: roles}, nil
+}
+
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// requireRole rejects requests without a valid bearer token (401) or whose
+// token lacks role (403). The principal is stored under "principal".
+func requireRole(auth *authConfig, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := bearerToken(c)
+			if token == "" {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="bookstore"`)
+				return problem(c, http.StatusUnauthorized, "A bearer token is required")
+			}
+			principal, err := auth.authenticate(token)
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="bookstore", error="invalid_token"`)
+				return problem(c, http.StatusUnauthorized, "Invalid token: "+err.Error())
+			}
+			if !principal.HasRole(role) {
+				return problem(c, http.StatusForbidden, fmt.Sprintf("The %s role is required", role))
+			}
+			c.Set("principal", principal)
+			return next(c)
+		}
+	}
+}
+
+// actor returns the subject of the authenticated caller.
+func actor(c echo.Context) string {
+	if p, ok := c.Get("principal").(*Principal); ok {
+		return p.Subject
+	}
+	return ""
+}
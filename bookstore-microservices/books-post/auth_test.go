@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func signHS256(t *testing.T, secret string, claims tokenClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func claimsFor(sub string, roles ...string) tokenClaims {
+	return tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   sub,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Roles: roles,
+	}
+}
+
+func TestPrincipalHasRole(t *testing.T) {
+	admin := &Principal{Roles: []string{RoleAdmin}}
+	reader := &Principal{Roles: []string{RoleReader}}
+	nobody := &Principal{Roles: []string{"guest"}}
+
+	if !admin.HasRole(RoleEditor) || !admin.HasRole(RoleReader) {
+		t.Error("admin should include editor and reader")
+	}
+	if reader.HasRole(RoleEditor) {
+		t.Error("reader must not be an editor")
+	}
+	if nobody.HasRole(RoleReader) {
+		t.Error("unknown roles grant nothing")
+	}
+}
+
+func TestAuthenticateHS256(t *testing.T) {
+	auth := &authConfig{hmacSecret: []byte("secret"), rsaKeys: map[string]*rsa.PublicKey{}}
+
+	p, err := auth.authenticate(signHS256(t, "secret", claimsFor("alice", RoleEditor)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "alice" || !p.HasRole(RoleEditor) {
+		t.Errorf("principal = %+v", p)
+	}
+
+	if _, err := auth.authenticate(signHS256(t, "other", claimsFor("alice", RoleEditor))); err == nil {
+		t.Error("token signed with the wrong secret was accepted")
+	}
+
+	noExp := claimsFor("alice", RoleEditor)
+	noExp.ExpiresAt = nil
+	if _, err := auth.authenticate(signHS256(t, "secret", noExp)); err == nil {
+		t.Error("token without expiry was accepted")
+	}
+}
+
+func TestAuthenticateRS256WithJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	raw, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &authConfig{rsaKeys: keys}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claimsFor("bob", RoleAdmin))
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.authenticate(signed); err != nil {
+		t.Fatalf("RS256 token rejected: %v", err)
+	}
+
+	// HS256 must not be accepted when no secret is configured.
+	if _, err := auth.authenticate(signHS256(t, "", claimsFor("bob", RoleAdmin))); err == nil {
+		t.Error("HS256 token accepted without a configured secret")
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	auth := &authConfig{hmacSecret: []byte("secret"), rsaKeys: map[string]*rsa.PublicKey{}}
+	e := echo.New()
+	handler := requireRole(auth, RoleEditor)(func(c echo.Context) error {
+		return c.String(http.StatusOK, actor(c))
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"garbage token", "Bearer nope", http.StatusUnauthorized},
+		{"reader", "Bearer " + signHS256(t, "secret", claimsFor("r", RoleReader)), http.StatusForbidden},
+		{"editor", "Bearer " + signHS256(t, "secret", claimsFor("e", RoleEditor)), http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/books", nil)
+		if tc.header != "" {
+			req.Header.Set(echo.HeaderAuthorization, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+		if tc.want != http.StatusOK && rec.Header().Get(echo.HeaderContentType) != "application/problem+json" {
+			t.Errorf("%s: content type %q", tc.name, rec.Header().Get(echo.HeaderContentType))
+		}
+	}
+}
+
+func TestLoadAuthConfigRefusesPlaceholderSecret(t *testing.T) {
+	t.Setenv("JWT_HS256_SECRET", placeholderSecret)
+	if _, err := loadAuthConfig(); err != errPlaceholderSecret {
+		t.Errorf("loadAuthConfig() = %v, want %v", err, errPlaceholderSecret)
+	}
+	t.Setenv("JWT_HS256_SECRET", "a secret of our own")
+	if _, err := loadAuthConfig(); err != nil {
+		t.Errorf("loadAuthConfig() = %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cover describes the cover image of a book. The image and its thumbnails
+// are kept in the "covers" GridFS bucket, tagged with the book ID and the
+// ETag, which is derived from the uploaded bytes.
+type Cover struct {
+	ETag        string    `bson:"etag"`
+	ContentType string    `bson:"contenttype"`
+	Width       int       `bson:"width"`
+	Height      int       `bson:"height"`
+	Bytes       int64     `bson:"bytes"`
+	Sizes       []string  `bson:"sizes"`
+	UploadedBy  string    `bson:"uploadedby"`
+	UploadedAt  time.Time `bson:"uploadedat"`
+}
+
+const (
+	coverBucketName = "covers"
+	// CoverOriginal is the uploaded image as it was sent.
+	CoverOriginal = "original"
+	// Larger images would take too long and too much memory to decode.
+	maxCoverPixels      = 25_000_000
+	defaultMaxCoverSize = 5 << 20
+	thumbnailQuality    = 85
+)
+
+// coverSizes are the thumbnails made of every cover, by width. Covers
+// narrower than a size get no thumbnail of it; the original stands in.
+var coverSizes = []struct {
+	Name  string
+	Width int
+}{
+	{"large", 480},
+	{"medium", 240},
+	{"small", 80},
+}
+
+// coverTypes are the image types accepted as covers, as sniffed from the
+// content rather than taken from the client.
+var coverTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+var (
+	errMissingCover  = errors.New("cover is a required file")
+	errCoverType     = errors.New("cover must be a JPEG, PNG or GIF image")
+	errInvalidCover  = errors.New("cover is not a readable image")
+	errCoverTooLarge = errors.New("cover is too large")
+)
+
+// maxCoverSize is the largest cover accepted in bytes, COVER_MAX_BYTES
+// (default 5 MiB).
+func maxCoverSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("COVER_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxCoverSize
+}
+
+// coverStatus is the status to answer a failed upload with, or 0 when err
+// is not about the cover itself.
+func coverStatus(err error) int {
+	switch {
+	case errors.Is(err, errCoverTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errCoverType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errMissingCover), errors.Is(err, errInvalidCover):
+		return http.StatusBadRequest
+	}
+	return 0
+}
+
+// limitUpload caps the body of a multipart request to what a cover and the
+// form fields around it can take.
+func limitUpload(c echo.Context) {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxCoverSize()+1<<20)
+}
+
+// formError tells a body over the limit apart from a malformed one.
+func formError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errCoverTooLarge
+	}
+	return err
+}
+
+// readCover reads the "cover" file of a multipart request.
+func readCover(c echo.Context) ([]byte, error) {
+	header, err := c.FormFile("cover")
+	if err == http.ErrMissingFile {
+		return nil, errMissingCover
+	}
+	if err != nil {
+		return nil, formError(err)
+	}
+	limit := maxCoverSize()
+	if header.Size > limit {
+		return nil, errCoverTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, formError(err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errCoverTooLarge
+	}
+	return data, nil
+}
+
+// coverImage is one file of a cover: the original or a thumbnail.
+type coverImage struct {
+	Size        string
+	ContentType string
+	Data        []byte
+}
+
+// coverUpload is a checked cover with its thumbnails, ready to be stored.
+type coverUpload struct {
+	Cover  Cover
+	Images []coverImage
+}
+
+// processCover checks an uploaded image and makes its thumbnails. The
+// type is sniffed from the content, and the dimensions are checked before
+// the image is decoded. All its errors are cover errors.
+func processCover(data []byte, actor string) (*coverUpload, error) {
+	if len(data) == 0 {
+		return nil, errMissingCover
+	}
+	contentType := http.DetectContentType(data)
+	if !coverTypes[contentType] {
+		return nil, errCoverType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return nil, errInvalidCover
+	}
+	if config.Width*config.Height > maxCoverPixels {
+		return nil, fmt.Errorf("%w: at most %d pixels", errCoverTooLarge, maxCoverPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidCover
+	}
+
+	sum := sha256.Sum256(data)
+	upload := &coverUpload{
+		Cover: Cover{
+			ETag:        hex.EncodeToString(sum[:16]),
+			ContentType: contentType,
+			Width:       config.Width,
+			Height:      config.Height,
+			Bytes:       int64(len(data)),
+			Sizes:       []string{},
+			UploadedBy:  actor,
+			UploadedAt:  time.Now().UTC(),
+		},
+		Images: []coverImage{{Size: CoverOriginal, ContentType: contentType, Data: data}},
+	}
+	// Each thumbnail is scaled from the next larger one, which is much
+	// quicker than going back to the original every time.
+	source := img
+	for _, size := range coverSizes {
+		if size.Width >= config.Width {
+			continue
+		}
+		thumb := thumbnail(source, size.Width)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidCover, err)
+		}
+		upload.Cover.Sizes = append(upload.Cover.Sizes, size.Name)
+		upload.Images = append(upload.Images, coverImage{Size: size.Name, ContentType: "image/jpeg", Data: buf.Bytes()})
+		source = thumb
+	}
+	return upload, nil
+}
+
+// thumbnail scales img down to width, keeping its aspect ratio. Every
+// pixel averages the pixels it covers, and transparent parts end up white
+// since thumbnails are JPEGs.
+func thumbnail(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			white := 0xffff - a/n
+			thumb.Set(x, y, color.RGBA64{
+				R: uint16(r/n + white),
+				G: uint16(g/n + white),
+				B: uint16(b/n + white),
+				A: 0xffff,
+			})
+		}
+	}
+	return thumb
+}
+
+// coverBucket opens the covers bucket for one operation. GridFS takes
+// deadlines rather than contexts, so the bucket gets the deadline of ctx.
+func coverBucket(ctx context.Context, db *mongo.Database) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(coverBucketName))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+		bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// ensureCoverIndexes indexes the cover files by book and ETag, the way
+// they are looked up.
+func ensureCoverIndexes(ctx context.Context, db *mongo.Database) error {
+	bucket, err := coverBucket(ctx, db)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.GetFilesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "metadata.bookid", Value: 1}, {Key: "metadata.etag", Value: 1}, {Key: "metadata.size", Value: 1}},
+	})
+	return err
+}
+
+// storeCover writes the files of a cover and reports whether it did.
+// Files are stored once per ETag, so uploading the same image again writes
+// nothing. When a file fails, those already written are removed again.
+func storeCover(ctx context.Context, db *mongo.Database, bookID string, upload *coverUpload) (bool, error) {
+	bucket, err := coverBucket(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	stored, err := bucket.GetFilesCollection().CountDocuments(ctx, bson.M{"metadata.bookid": bookID, "metadata.etag": upload.Cover.ETag})
+	if err != nil || stored > 0 {
+		return false, err
+	}
+	for _, img := range upload.Images {
+		opts := options.GridFSUpload().SetMetadata(bson.M{
+			"bookid":      bookID,
+			"etag":        upload.Cover.ETag,
+			"size":        img.Size,
+			"contenttype": img.ContentType,
+		})
+		if _, err := bucket.UploadFromStream(bookID+"/"+img.Size, bytes.NewReader(img.Data), opts); err != nil {
+			removeCoverFiles(ctx, db, bookID, upload.Cover.ETag)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// removeCoverFiles deletes the files of the cover of a book with the given
+// ETag. Replacing a cover removes exactly the files of the one before, so
+// of two uploads at once neither removes the files of the other.
+func removeCoverFiles(ctx context.Context, db *mongo.Database, bookID, etag string) error {
+	bucket, err := coverBucket(ctx, db)
+	if err != nil {
+		return err
+	}
+	cursor, err := bucket.FindContext(ctx, bson.M{"metadata.bookid": bookID, "metadata.etag": etag})
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := bucket.DeleteContext(ctx, file.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessCover(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 450))
+	for y := 0; y < 450; y++ {
+		for x := 0; x < 300; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, A: 255})
+		}
+	}
+	data := encodePNG(t, img)
+
+	upload, err := processCover(data, "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cover := upload.Cover
+	if cover.ContentType != "image/png" || cover.Width != 300 || cover.Height != 450 || cover.Bytes != int64(len(data)) {
+		t.Errorf("unexpected cover %+v", cover)
+	}
+	if !reflect.DeepEqual(cover.Sizes, []string{"medium", "small"}) {
+		t.Errorf("sizes = %v, want only those narrower than the original", cover.Sizes)
+	}
+	if len(upload.Images) != 3 || upload.Images[0].Size != CoverOriginal || !bytes.Equal(upload.Images[0].Data, data) {
+		t.Fatalf("the original is kept as it was sent")
+	}
+	small, err := jpeg.Decode(bytes.NewReader(upload.Images[2].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := small.Bounds(); b.Dx() != 80 || b.Dy() != 120 {
+		t.Errorf("small thumbnail is %dx%d, want 80x120", b.Dx(), b.Dy())
+	}
+
+	again, _ := processCover(data, "someone else")
+	if again.Cover.ETag != cover.ETag {
+		t.Error("the ETag depends only on the image")
+	}
+}
+
+func TestProcessCoverRejects(t *testing.T) {
+	tiny := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	for name, tt := range map[string]struct {
+		data []byte
+		want error
+	}{
+		"empty":     {nil, errMissingCover},
+		"text":      {[]byte("definitely not an image"), errCoverType},
+		"truncated": {tiny[:20], errInvalidCover},
+	} {
+		if _, err := processCover(tt.data, "editor"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: processCover() = %v, want %v", name, err, tt.want)
+		}
+	}
+}
+
+func TestThumbnailWhitensTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	got := thumbnail(img, 2).RGBAAt(0, 0)
+	if got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("transparent pixels became %v, want white", got)
+	}
+}
+
+func TestCoverStatus(t *testing.T) {
+	for err, want := range map[error]int{
+		errCoverTooLarge: http.StatusRequestEntityTooLarge,
+		errCoverType:     http.StatusUnsupportedMediaType,
+		errInvalidCover:  http.StatusBadRequest,
+		errMissingCover:  http.StatusBadRequest,
+		errUnknownWork:   0,
+	} {
+		if got := coverStatus(err); got != want {
+			t.Errorf("coverStatus(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultDBTimeout = 5 * time.Second
+
+// dbTimeout returns the deadline for a database operation. DB_TIMEOUT_<OP>
+// (e.g. DB_TIMEOUT_CREATE) overrides DB_TIMEOUT, which defaults to 5s.
+// Values use Go duration syntax such as "2s" or "500ms".
+func dbTimeout(op string) time.Duration {
+	for _, key := range []string{"DB_TIMEOUT_" + strings.ToUpper(op), "DB_TIMEOUT"} {
+		if value := os.Getenv(key); value != "" {
+			if d, err := time.ParseDuration(value); err == nil && d > 0 {
+				return d
+			}
+		}
+	}
+	return defaultDBTimeout
+}
+
+// dbContext derives the context for a database operation from the request
+// context, so client disconnects cancel the query and stuck queries time out.
+func dbContext(parent context.Context, op string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, dbTimeout(op))
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)
+}
+
+func dbTimeoutProblem(c echo.Context) error {
+	return problem(c, http.StatusGatewayTimeout, "The database did not respond in time")
+}
+
+// collection returns a collection of the service database, or nil when the
+// service started without a database connection.
+func collection(client *mongo.Client, name string) *mongo.Collection {
+	if client == nil {
+		return nil
+	}
+	return client.Database("exercise-1").Collection(name)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">urn:uuid:0b7f3c2a-6a55-4a43-9d6f-8d0d0e3e2a11</dc:identifier>
+    <dc:identifier>urn:isbn:978-0-14-143947-1</dc:identifier>
+    <dc:title>  Pride and
+      Prejudice </dc:title>
+    <dc:creator>Jane Austen</dc:creator>
+    <dc:language>EN</dc:language>
+  </metadata>
+</package>`
+
+func buildEpub(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range []string{"mimetype", "META-INF/container.xml", "OEBPS/content.opf"} {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testEpubFiles() map[string]string {
+	return map[string]string{
+		"mimetype": "application/epub+zip",
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`,
+		"OEBPS/content.opf": testOPF,
+	}
+}
+
+func TestCheckEbookReadsEpubMetadata(t *testing.T) {
+	data := buildEpub(t, testEpubFiles())
+	ebook, err := checkEbook(bytes.NewReader(data), int64(len(data)), "pride.epub", "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ebook.Format != EbookEPUB || ebook.ETag == "" || ebook.Bytes != int64(len(data)) {
+		t.Errorf("unexpected e-book %+v", ebook)
+	}
+	want := &EpubMetadata{
+		Title:    "Pride and Prejudice",
+		Authors:  []string{"Jane Austen"},
+		Language: "en",
+		Identifiers: []string{
+			"urn:uuid:0b7f3c2a-6a55-4a43-9d6f-8d0d0e3e2a11",
+			"urn:isbn:978-0-14-143947-1",
+		},
+		ISBN: "9780141439471",
+	}
+	if !reflect.DeepEqual(ebook.Metadata, want) {
+		t.Errorf("metadata = %+v, want %+v", ebook.Metadata, want)
+	}
+}
+
+func TestCheckEbookRejects(t *testing.T) {
+	noMimetype := testEpubFiles()
+	delete(noMimetype, "mimetype")
+	noOPF := testEpubFiles()
+	delete(noOPF, "OEBPS/content.opf")
+	for name, tt := range map[string]struct {
+		data []byte
+		want error
+	}{
+		"text":        {[]byte("just some text"), errEbookType},
+		"plain zip":   {buildEpub(t, noMimetype), errEbookType},
+		"missing opf": {buildEpub(t, noOPF), errInvalidEbook},
+	} {
+		if _, err := checkEbook(bytes.NewReader(tt.data), int64(len(tt.data)), name, "editor"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: checkEbook() = %v, want %v", name, err, tt.want)
+		}
+	}
+
+	pdf := []byte("%PDF-1.7\n...")
+	ebook, err := checkEbook(bytes.NewReader(pdf), int64(len(pdf)), "book.pdf", "editor")
+	if err != nil || ebook.Format != EbookPDF || ebook.Metadata != nil {
+		t.Errorf("checkEbook(pdf) = %+v, %v", ebook, err)
+	}
+}
+
+func TestIsbnOf(t *testing.T) {
+	for id, want := range map[string]string{
+		"urn:isbn:978-0-14-143947-1": "9780141439471",
+		"URN:ISBN:0141439475":        "0141439475",
+		"0-8044-2957-X":              "080442957X",
+		"1234567890123":              "",
+		"urn:uuid:1234567890":        "",
+		"2nd edition":                "",
+	} {
+		if got := isbnOf(id); got != want {
+			t.Errorf("isbnOf(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestPrefillBook(t *testing.T) {
+	metadata := &EpubMetadata{
+		Title:    "Pride and Prejudice",
+		Authors:  []string{"Jane Austen"},
+		Language: "en",
+		ISBN:     "9780141439471",
+	}
+
+	empty := BookRequest{}
+	if mismatches := prefillBook(&empty, metadata); len(mismatches) != 0 {
+		t.Errorf("an empty record has nothing to mismatch, got %v", mismatches)
+	}
+	if empty.Title != "Pride and Prejudice" || empty.Author != "Jane Austen" || empty.Language != "en" || empty.Edition != "9780141439471" {
+		t.Errorf("prefilled %+v", empty)
+	}
+
+	book := BookRequest{Title: "pride  and prejudice", Author: "J. Austen", Language: "fr", Edition: "978-0-14-143951-8"}
+	got := prefillBook(&book, metadata)
+	want := []EbookMismatch{
+		{Field: "author", Record: "J. Austen", Ebook: "Jane Austen"},
+		{Field: "language", Record: "fr", Ebook: "en"},
+		{Field: "isbn", Record: "978-0-14-143951-8", Ebook: "9780141439471"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatches = %+v, want %+v", got, want)
+	}
+	if book.Title != "pride  and prejudice" || book.Author != "J. Austen" {
+		t.Errorf("fields already set are kept, got %+v", book)
+	}
+}
@@ -3,8 +3,10 @@ module bookstore-service
 go 1.22.0
 
 require (
+	github.com/golang-jwt/jwt/v5 v5.3.1
 	github.com/labstack/echo/v4 v4.12.0
 	go.mongodb.org/mongo-driver v1.15.0
+	golang.org/x/time v0.5.0
 )
 
 require (
@@ -26,5 +28,4 @@ require (
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
-)
\ No newline at end of file
+)
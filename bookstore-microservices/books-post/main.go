@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -23,15 +27,45 @@ type BookStore struct {
 	BookEdition string             `bson:"bookedition"`
 	BookPages   string             `bson:"bookpages"`
 	BookYear    string             `bson:"bookyear"`
+	AuthorIDs   []string           `bson:"authorids"`
+	Tags        []string           `bson:"tags"`
+	Subjects    []string           `bson:"subjects"`
+	PublisherID string             `bson:"publisherid,omitempty"`
+	Series      string             `bson:"series,omitempty"`
+	SeriesPos   *float64           `bson:"seriesposition,omitempty"`
+	WorkID      string             `bson:"workid,omitempty"`
+	Format      string             `bson:"format,omitempty"`
+	Language    string             `bson:"language,omitempty"`
+	Price       *Money             `bson:"price,omitempty"`
+	Discounts   []Discount         `bson:"discounts,omitempty"`
+	Cover       *Cover             `bson:"cover,omitempty"`
+	Ebooks      []Ebook            `bson:"ebooks,omitempty"`
+	CreatedBy   string             `bson:"createdby,omitempty"`
+	CreatedAt   time.Time          `bson:"createdat,omitempty"`
+	UpdatedBy   string             `bson:"updatedby,omitempty"`
+	UpdatedAt   time.Time          `bson:"updatedat,omitempty"`
+	DeletedBy   string             `bson:"deletedby,omitempty"`
+	DeletedAt   *time.Time         `bson:"deletedat,omitempty"`
 }
 
 type BookRequest struct {
-	ID      string `json:"id"`
-	Title   string `json:"title"`
-	Author  string `json:"author"`
-	Pages   string `json:"pages,omitempty"`
-	Edition string `json:"edition,omitempty"`
-	Year    string `json:"year,omitempty"`
+	ID             string     `json:"id"`
+	Title          string     `json:"title"`
+	Author         string     `json:"author"`
+	AuthorIDs      []string   `json:"authorIds,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	Subjects       []string   `json:"subjects,omitempty"`
+	Pages          string     `json:"pages,omitempty"`
+	Edition        string     `json:"edition,omitempty"`
+	Year           string     `json:"year,omitempty"`
+	PublisherID    string     `json:"publisherId,omitempty"`
+	Series         string     `json:"series,omitempty"`
+	SeriesPosition *float64   `json:"seriesPosition,omitempty"`
+	WorkID         string     `json:"workId,omitempty"`
+	Format         string     `json:"format,omitempty"`
+	Language       string     `json:"language,omitempty"`
+	Price          *Money     `json:"price,omitempty"`
+	Discounts      []Discount `json:"discounts,omitempty"`
 }
 
 func getMongoURI() string {
@@ -61,23 +95,35 @@ func connectToMongoDB() (*mongo.Client, *mongo.Collection, error) {
 	return client, coll, nil
 }
 
-func createBook(coll *mongo.Collection, bookReq BookRequest) error {
-	// Check if book with same ID already exists
-	cursor, err := coll.Find(context.TODO(), bson.M{"id": bookReq.ID})
-	if err != nil {
-		return err
+// createBook stores a new book as an edition of bookReq.WorkID, or of a new
+// work made from the book when no work is given. The files of the cover
+// and e-book, if any, must be stored already.
+func createBook(ctx context.Context, coll, works *mongo.Collection, bookReq BookRequest, cover *Cover, ebook *Ebook, actor string) (*BookStore, error) {
+	// Check if book with same ID already exists. A deleted book keeps its
+	// record for restores and revisions, so its ID is not free either.
+	var existing BookStore
+	err := coll.FindOne(ctx, bson.M{"id": bookReq.ID}, options.FindOne().SetProjection(bson.M{"id": 1, "deletedat": 1})).Decode(&existing)
+	if err == nil {
+		if existing.DeletedAt != nil {
+			return nil, fmt.Errorf("book with ID %s was deleted; restore it instead", bookReq.ID)
+		}
+		return nil, fmt.Errorf("book with ID %s already exists", bookReq.ID)
 	}
-	
-	var results []BookStore
-	if err = cursor.All(context.TODO(), &results); err != nil {
-		return err
+	if err != mongo.ErrNoDocuments {
+		return nil, err
 	}
-	
-	if len(results) > 0 {
-		return fmt.Errorf("book with ID %s already exists", bookReq.ID)
+
+	var newWork *Work
+	if bookReq.WorkID == "" {
+		newWork = workFromBook(bookReq, actor)
+		if _, err = works.InsertOne(ctx, newWork); err != nil {
+			return nil, err
+		}
+		bookReq.WorkID = newWork.MongoID.Hex()
 	}
 
 	// Create new book
+	now := time.Now().UTC()
 	newBook := BookStore{
 		ID:          bookReq.ID,
 		BookName:    bookReq.Title,
@@ -85,10 +131,253 @@ func createBook(coll *mongo.Collection, bookReq BookRequest) error {
 		BookEdition: bookReq.Edition,
 		BookPages:   bookReq.Pages,
 		BookYear:    bookReq.Year,
+		AuthorIDs:   bookReq.AuthorIDs,
+		Tags:        normalizeList(bookReq.Tags, normalizeTag),
+		Subjects:    normalizeList(bookReq.Subjects, normalizeSubjectCode),
+		PublisherID: bookReq.PublisherID,
+		Series:      bookReq.Series,
+		SeriesPos:   bookReq.SeriesPosition,
+		WorkID:      bookReq.WorkID,
+		Format:      normalizeFormat(bookReq.Format),
+		Language:    normalizeLanguage(bookReq.Language),
+		Price:       bookReq.Price,
+		Discounts:   bookReq.Discounts,
+		Cover:       cover,
+		CreatedBy:   actor,
+		CreatedAt:   now,
+		UpdatedBy:   actor,
+		UpdatedAt:   now,
+	}
+
+	if ebook != nil {
+		newBook.Ebooks = []Ebook{*ebook}
+	}
+
+	if _, err = coll.InsertOne(ctx, newBook); err != nil {
+		if newWork != nil {
+			works.DeleteOne(ctx, bson.M{"_id": newWork.MongoID})
+		}
+		return nil, err
+	}
+	return &newBook, nil
+}
+
+// bookFiles are the files posted along with a new book.
+type bookFiles struct {
+	Cover []byte
+	Ebook *ebookUpload
+}
+
+func (f *bookFiles) Close() {
+	if f.Ebook != nil {
+		f.Ebook.Close()
+	}
+}
+
+// bindBook reads the book of a create request. Besides a JSON body it
+// takes a multipart form with the book as JSON in the "book" field, its
+// cover image in the "cover" file and an EPUB or PDF in the "ebook" file.
+// Both files are optional.
+func bindBook(c echo.Context, bookReq *BookRequest) (*bookFiles, error) {
+	files := &bookFiles{}
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return files, c.Bind(bookReq)
+	}
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxCoverSize()+maxEbookSize()+1<<20)
+	if _, err := c.MultipartForm(); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(c.FormValue("book")), bookReq); err != nil {
+		return nil, err
+	}
+	cover, err := readCover(c)
+	if err != nil && err != errMissingCover {
+		return nil, err
+	}
+	files.Cover = cover
+	ebook, err := readEbook(c, "ebook")
+	if err != nil && err != errMissingEbook {
+		return nil, err
+	}
+	files.Ebook = ebook
+	return files, nil
+}
+
+// uploadStatus is the status to answer a failed upload with, or 0 when
+// err is not about the posted files.
+func uploadStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if status := coverStatus(err); status != 0 {
+		return status
+	}
+	return ebookStatus(err)
+}
+
+// prefillBook fills the fields a new book leaves empty from the metadata
+// of its EPUB, and reports where the fields it has disagree with it.
+func prefillBook(bookReq *BookRequest, metadata *EpubMetadata) []EbookMismatch {
+	mismatches := compareEbook(bookReq.Title, bookReq.Author, bookReq.Language, bookReq.Edition, metadata)
+	if metadata == nil {
+		return mismatches
+	}
+	if bookReq.Title == "" {
+		bookReq.Title = metadata.Title
+	}
+	if bookReq.Author == "" && len(bookReq.AuthorIDs) == 0 && len(metadata.Authors) > 0 {
+		bookReq.Author = metadata.Authors[0]
+	}
+	if bookReq.Language == "" {
+		bookReq.Language = metadata.Language
+	}
+	if bookReq.Edition == "" {
+		bookReq.Edition = metadata.ISBN
+	}
+	return mismatches
+}
+
+// workFromBook makes the work of a book that was not added to one, taking
+// its title, author and year as the original ones.
+func workFromBook(book BookRequest, actor string) *Work {
+	return &Work{
+		MongoID:   primitive.NewObjectID(),
+		Title:     book.Title,
+		Author:    book.Author,
+		AuthorIDs: book.AuthorIDs,
+		Year:      book.Year,
+		CreatedBy: actor,
+		CreatedAt: time.Now().UTC(),
 	}
+}
 
-	_, err = coll.InsertOne(context.TODO(), newBook)
-	return err
+// migrateWorks makes every book that is not an edition of a work yet the
+// single edition of a new work. Books already linked are left alone, so
+// running it again only picks up new books. Deleted books get a work too,
+// so that a restored book comes back as an edition like any other.
+//
+// Another replica may run the migration at the same time: a book is only
+// linked if it still has no work, and the work made for it is removed
+// again when the other replica linked it first.
+func migrateWorks(ctx context.Context, works, books *mongo.Collection) (int, error) {
+	cursor, err := books.Find(ctx, bson.M{"workid": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var book BookStore
+		if err := cursor.Decode(&book); err != nil {
+			return migrated, err
+		}
+		work := workFromBook(BookRequest{
+			Title:     book.BookName,
+			Author:    book.BookAuthor,
+			AuthorIDs: book.AuthorIDs,
+			Year:      book.BookYear,
+		}, "migration")
+		if _, err := works.InsertOne(ctx, work); err != nil {
+			return migrated, err
+		}
+		res, err := books.UpdateOne(ctx,
+			bson.M{"_id": book.MongoID, "workid": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"workid": work.MongoID.Hex()}})
+		if err != nil {
+			return migrated, err
+		}
+		if res.MatchedCount == 0 {
+			if _, err := works.DeleteOne(ctx, bson.M{"_id": work.MongoID}); err != nil {
+				return migrated, err
+			}
+			continue
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// lookupError answers for a failed author, subject, publisher or work
+// lookup.
+func lookupError(c echo.Context, err error) error {
+	if errors.Is(err, errUnknownAuthor) || errors.Is(err, errUnknownSubject) || errors.Is(err, errUnknownPublisher) ||
+		errors.Is(err, errUnknownWork) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+type SubjectRequest struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Parent string `json:"parent"`
+}
+
+// newSubject validates a request for a new subject. The code defaults to
+// one derived from the name.
+func newSubject(req SubjectRequest, actor string) (Subject, error) {
+	name := strings.Join(strings.Fields(req.Name), " ")
+	if name == "" {
+		return Subject{}, fmt.Errorf("name is a required field")
+	}
+	code := normalizeSubjectCode(req.Code)
+	if code == "" {
+		code = normalizeSubjectCode(name)
+	}
+	parent := normalizeSubjectCode(req.Parent)
+	if parent == code {
+		return Subject{}, fmt.Errorf("a subject cannot be its own parent")
+	}
+	return Subject{
+		Code:      code,
+		Name:      name,
+		Parent:    parent,
+		CreatedBy: actor,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+type WorkRequest struct {
+	Title     string   `json:"title"`
+	Author    string   `json:"author"`
+	AuthorIDs []string `json:"authorIds,omitempty"`
+	Year      string   `json:"year,omitempty"`
+}
+
+type PublisherRequest struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Website string `json:"website"`
+}
+
+// newPublisher validates a request for a new publisher. The code defaults
+// to one derived from the name.
+func newPublisher(req PublisherRequest, actor string) (Publisher, error) {
+	name := strings.Join(strings.Fields(req.Name), " ")
+	if name == "" {
+		return Publisher{}, fmt.Errorf("name is a required field")
+	}
+	code := normalizePublisherCode(req.Code)
+	if code == "" {
+		code = normalizePublisherCode(name)
+	}
+	return Publisher{
+		Code:      code,
+		Name:      name,
+		Website:   strings.TrimSpace(req.Website),
+		CreatedBy: actor,
+		CreatedAt: time.Now().UTC(),
+	}, nil
 }
 
 func main() {
@@ -121,35 +410,294 @@ func main() {
 		}
 	}()
 
+	keys := newKeyStore(collection(client, "apikeys"))
+	audit := &auditLog{coll: collection(client, "audit")}
+	authors := &authorStore{coll: collection(client, "authors")}
+	subjects := collection(client, "subjects")
+	publishers := collection(client, "publishers")
+	works := collection(client, "works")
+	revisions := &revisionLog{coll: collection(client, "revisions")}
+	prices := &priceHistory{coll: collection(client, "pricehistory")}
+	if client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := revisions.ensureIndexes(ctx); err != nil {
+			fmt.Printf("Warning: Failed to create revision indexes: %v\n", err)
+		}
+		if err := ensureCoverIndexes(ctx, coll.Database()); err != nil {
+			fmt.Printf("Warning: Failed to create cover indexes: %v\n", err)
+		}
+		if err := ensureEbookIndexes(ctx, coll.Database()); err != nil {
+			fmt.Printf("Warning: Failed to create e-book indexes: %v\n", err)
+		}
+		cancel()
+
+		ctx, cancel = dbContext(context.Background(), "migrate")
+		migrated, err := migrateWorks(ctx, works, coll)
+		cancel()
+		if err != nil {
+			fmt.Printf("Warning: Failed to migrate books to works: %v\n", err)
+		} else if migrated > 0 {
+			fmt.Printf("Made %d books editions of new works\n", migrated)
+		}
+	}
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
 	e := echo.New()
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
 
-	e.POST("/api/books", func(c echo.Context) error {
+	// postBook creates a book, with its cover and e-book when it comes as a
+	// multipart form; an EPUB fills in what the book leaves out. Posted to a
+	// work's editions it becomes an edition of that work, taking the work's
+	// title and author unless it has its own.
+	postBook := func(c echo.Context) error {
 		var bookReq BookRequest
-		if err := c.Bind(&bookReq); err != nil {
+		files, err := bindBook(c, &bookReq)
+		if status := uploadStatus(err); status != 0 {
+			return c.JSON(status, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": "Invalid request body",
 			})
 		}
+		defer files.Close()
+		var upload *coverUpload
+		if files.Cover != nil {
+			if upload, err = processCover(files.Cover, actor(c)); err != nil {
+				return c.JSON(coverStatus(err), map[string]string{
+					"error": err.Error(),
+				})
+			}
+		}
+		var mismatches []EbookMismatch
+		if files.Ebook != nil {
+			mismatches = prefillBook(&bookReq, files.Ebook.Ebook.Metadata)
+		}
+
+		if workID := c.Param("id"); workID != "" {
+			bookReq.WorkID = workID
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		if bookReq.WorkID != "" {
+			work, err := findWork(ctx, works, bookReq.WorkID)
+			if err != nil {
+				return lookupError(c, err)
+			}
+			if bookReq.Title == "" {
+				bookReq.Title = work.Title
+			}
+			if bookReq.Author == "" && len(bookReq.AuthorIDs) == 0 {
+				bookReq.Author, bookReq.AuthorIDs = work.Author, work.AuthorIDs
+			}
+		}
 
 		// Validate required fields
-		if bookReq.ID == "" || bookReq.Title == "" || bookReq.Author == "" {
+		if bookReq.ID == "" || bookReq.Title == "" || (bookReq.Author == "" && len(bookReq.AuthorIDs) == 0) {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": "ID, title, and author are required fields",
 			})
 		}
+		bookReq.Series = normalizeSeries(bookReq.Series)
+		if err := checkSeries(bookReq.Series, bookReq.SeriesPosition); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		normalizePricing(bookReq.Price, bookReq.Discounts)
+		if err := checkPricing(bookReq.Price, bookReq.Discounts); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
 
-		if err := createBook(coll, bookReq); err != nil {
+		authorIDs, author, err := authors.resolveBookAuthors(ctx, bookReq.Author, bookReq.AuthorIDs, actor(c))
+		if err != nil {
+			return lookupError(c, err)
+		}
+		bookReq.AuthorIDs, bookReq.Author = authorIDs, author
+		bookReq.Subjects = normalizeList(bookReq.Subjects, normalizeSubjectCode)
+		if err := checkSubjects(ctx, subjects, bookReq.Subjects); err != nil {
+			return lookupError(c, err)
+		}
+		bookReq.PublisherID = normalizePublisherCode(bookReq.PublisherID)
+		if err := checkPublisher(ctx, publishers, bookReq.PublisherID); err != nil {
+			return lookupError(c, err)
+		}
+		var cover *Cover
+		coverWritten := false
+		if upload != nil {
+			if coverWritten, err = storeCover(ctx, coll.Database(), bookReq.ID, upload); err != nil {
+				return lookupError(c, err)
+			}
+			cover = &upload.Cover
+		}
+		var ebook *Ebook
+		ebookWritten := false
+		if files.Ebook != nil {
+			if ebookWritten, err = storeEbook(ctx, coll.Database(), bookReq.ID, files.Ebook); err != nil {
+				if coverWritten {
+					removeCoverFiles(ctx, coll.Database(), bookReq.ID, cover.ETag)
+				}
+				return lookupError(c, err)
+			}
+			ebook = &files.Ebook.Ebook
+		}
+		newBook, err := createBook(ctx, coll, works, bookReq, cover, ebook, actor(c))
+		if err != nil {
+			if coverWritten {
+				removeCoverFiles(ctx, coll.Database(), bookReq.ID, cover.ETag)
+			}
+			if ebookWritten {
+				removeEbookFile(ctx, coll.Database(), bookReq.ID, ebook.Format, ebook.ETag)
+			}
+			if isTimeout(err) {
+				return dbTimeoutProblem(c)
+			}
 			return c.JSON(http.StatusConflict, map[string]string{
 				"error": err.Error(),
 			})
 		}
 
+		audit.record(c, AuditCreate, newBook.ID, nil, newBook)
+		revisions.save(c, AuditCreate, newBook.ID, nil, newBook, false)
+		prices.record(c, newBook.ID, nil, newBook)
+
+		if ebook == nil {
+			return c.JSON(http.StatusCreated, map[string]string{
+				"message": "Book created successfully",
+				"workId":  newBook.WorkID,
+			})
+		}
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"message":    "Book created successfully",
+			"workId":     newBook.WorkID,
+			"format":     ebook.Format,
+			"metadata":   ebook.Metadata,
+			"mismatches": mismatches,
+		})
+	}
+
+	e.POST("/api/books", postBook, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/works/:id/editions", postBook, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/works", func(c echo.Context) error {
+		var req WorkRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		req.Title = strings.TrimSpace(req.Title)
+		if req.Title == "" || (req.Author == "" && len(req.AuthorIDs) == 0) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Title and author are required fields",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		authorIDs, author, err := authors.resolveBookAuthors(ctx, req.Author, req.AuthorIDs, actor(c))
+		if err != nil {
+			return lookupError(c, err)
+		}
+		work := workFromBook(BookRequest{
+			Title:     req.Title,
+			Author:    author,
+			AuthorIDs: authorIDs,
+			Year:      strings.TrimSpace(req.Year),
+		}, actor(c))
+		if _, err := works.InsertOne(ctx, work); err != nil {
+			return lookupError(c, err)
+		}
 		return c.JSON(http.StatusCreated, map[string]string{
-			"message": "Book created successfully",
+			"message": "Work created successfully",
+			"id":      work.MongoID.Hex(),
 		})
-	})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/works/migrate", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "migrate")
+		defer cancel()
+		migrated, err := migrateWorks(ctx, works, coll)
+		if err != nil {
+			return lookupError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]int{"migrated": migrated})
+	}, requireRole(auth, RoleAdmin))
+
+	e.POST("/api/subjects", func(c echo.Context) error {
+		var req SubjectRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		subject, err := newSubject(req, actor(c))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		if err := checkSubjects(ctx, subjects, normalizeList([]string{subject.Parent}, normalizeSubjectCode)); err != nil {
+			return lookupError(c, err)
+		}
+		if _, err := subjects.InsertOne(ctx, subject); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return c.JSON(http.StatusConflict, map[string]string{
+					"error": fmt.Sprintf("subject %s already exists", subject.Code),
+				})
+			}
+			return lookupError(c, err)
+		}
+		return c.JSON(http.StatusCreated, map[string]string{
+			"message": "Subject created successfully",
+			"code":    subject.Code,
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/publishers", func(c echo.Context) error {
+		var req PublisherRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		publisher, err := newPublisher(req, actor(c))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		if _, err := publishers.InsertOne(ctx, publisher); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return c.JSON(http.StatusConflict, map[string]string{
+					"error": fmt.Sprintf("publisher %s already exists", publisher.Code),
+				})
+			}
+			return lookupError(c, err)
+		}
+		return c.JSON(http.StatusCreated, map[string]string{
+			"message": "Publisher created successfully",
+			"code":    publisher.Code,
+		})
+	}, authorize(auth, keys, RoleEditor))
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Money is an amount in the minor units of an ISO 4217 currency, e.g.
+// 1299 EUR for 12.99 euros, so prices never go through floats.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+// Discount lowers the price of a book while it runs, either by a percentage
+// or to a fixed promotional price in the currency of the list price. A
+// missing start or end leaves that side open.
+type Discount struct {
+	Label    string     `bson:"label,omitempty" json:"label,omitempty"`
+	Percent  int64      `bson:"percent,omitempty" json:"percent,omitempty"`
+	Price    *int64     `bson:"price,omitempty" json:"price,omitempty"`
+	StartsAt *time.Time `bson:"startsat,omitempty" json:"startsAt,omitempty"`
+	EndsAt   *time.Time `bson:"endsat,omitempty" json:"endsAt,omitempty"`
+}
+
+// currencyDigits maps the accepted currencies to their number of minor
+// unit digits.
+var currencyDigits = map[string]int{
+	"AUD": 2, "CAD": 2, "CHF": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2,
+	"HUF": 2, "JPY": 0, "KRW": 0, "NOK": 2, "PLN": 2, "SEK": 2, "USD": 2,
+}
+
+var errInvalidPrice = errors.New("invalid price")
+
+func normalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// checkPricing validates a list price and the discounts on it. Discounts
+// can only be written along with the list price they apply to.
+func checkPricing(price *Money, discounts []Discount) error {
+	if price == nil {
+		if len(discounts) > 0 {
+			return fmt.Errorf("%w: discounts need a list price", errInvalidPrice)
+		}
+		return nil
+	}
+	if _, ok := currencyDigits[price.Currency]; !ok {
+		return fmt.Errorf("%w: unknown currency %q", errInvalidPrice, price.Currency)
+	}
+	if price.Amount < 0 {
+		return fmt.Errorf("%w: amount cannot be negative", errInvalidPrice)
+	}
+	for _, d := range discounts {
+		if (d.Percent == 0) == (d.Price == nil) {
+			return fmt.Errorf("%w: a discount needs either a percent or a price", errInvalidPrice)
+		}
+		if d.Percent < 0 || d.Percent > 100 {
+			return fmt.Errorf("%w: percent must be between 1 and 100", errInvalidPrice)
+		}
+		if d.Price != nil && (*d.Price < 0 || *d.Price >= price.Amount) {
+			return fmt.Errorf("%w: a promotional price must be below the list price", errInvalidPrice)
+		}
+		if d.StartsAt != nil && d.EndsAt != nil && !d.EndsAt.After(*d.StartsAt) {
+			return fmt.Errorf("%w: a discount must end after it starts", errInvalidPrice)
+		}
+	}
+	return nil
+}
+
+// normalizePricing tidies a price and its discounts in place.
+func normalizePricing(price *Money, discounts []Discount) {
+	if price != nil {
+		price.Currency = normalizeCurrency(price.Currency)
+	}
+	for i := range discounts {
+		discounts[i].Label = strings.TrimSpace(discounts[i].Label)
+	}
+}
+
+// Pricing is the price part of a book document.
+type Pricing struct {
+	Price     *Money     `bson:"price,omitempty"`
+	Discounts []Discount `bson:"discounts,omitempty"`
+}
+
+// pricingOf reads the pricing out of a book, whatever form the book is in.
+func pricingOf(book interface{}) (Pricing, error) {
+	var p Pricing
+	if book == nil {
+		return p, nil
+	}
+	raw, err := bson.Marshal(book)
+	if err != nil {
+		return p, err
+	}
+	err = bson.Unmarshal(raw, &p)
+	if len(p.Discounts) == 0 {
+		p.Discounts = nil
+	}
+	return p, err
+}
+
+// pricingFields returns the price and discounts as fields of a book
+// update. Nil discounts are left out, keeping those of the book.
+func pricingFields(price *Money, discounts []Discount) bson.M {
+	fields := bson.M{"price": price}
+	if discounts != nil {
+		fields["discounts"] = discounts
+	}
+	return fields
+}
+
+// PriceChange records the pricing of a book from the time it was set.
+type PriceChange struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    string             `bson:"bookid"`
+	Price     *Money             `bson:"price,omitempty"`
+	Discounts []Discount         `bson:"discounts,omitempty"`
+	Actor     string             `bson:"actor"`
+	Timestamp time.Time          `bson:"timestamp"`
+}
+
+type priceHistory struct {
+	coll *mongo.Collection
+}
+
+// record adds an entry to the price history of a book when a write changed
+// its price or discounts. Like audit entries, failures are logged rather
+// than failing the request.
+func (h *priceHistory) record(c echo.Context, bookID string, before, after interface{}) {
+	err := func() error {
+		from, err := pricingOf(before)
+		if err != nil {
+			return err
+		}
+		to, err := pricingOf(after)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(from, to) {
+			return nil
+		}
+		ctx, cancel := dbContext(context.WithoutCancel(c.Request().Context()), "prices")
+		defer cancel()
+		_, err = h.coll.InsertOne(ctx, PriceChange{
+			BookID:    bookID,
+			Price:     to.Price,
+			Discounts: to.Discounts,
+			Actor:     actor(c),
+			Timestamp: time.Now().UTC(),
+		})
+		return err
+	}()
+	if err != nil {
+		fmt.Printf("Error recording price change of book %s: %v\n", bookID, err)
+	}
+}
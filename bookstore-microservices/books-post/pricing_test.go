@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckPricing(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	promo, tooHigh := int64(500), int64(2000)
+	list := &Money{Amount: 1299, Currency: "EUR"}
+
+	valid := []struct {
+		price     *Money
+		discounts []Discount
+	}{
+		{nil, nil},
+		{list, nil},
+		{list, []Discount{{Percent: 20, StartsAt: &now, EndsAt: &later}, {Price: &promo}}},
+	}
+	for _, tc := range valid {
+		if err := checkPricing(tc.price, tc.discounts); err != nil {
+			t.Errorf("checkPricing(%+v, %+v) = %v", tc.price, tc.discounts, err)
+		}
+	}
+
+	invalid := []struct {
+		price     *Money
+		discounts []Discount
+	}{
+		{nil, []Discount{{Percent: 10}}},
+		{&Money{Amount: 100, Currency: "XXX"}, nil},
+		{&Money{Amount: -1, Currency: "EUR"}, nil},
+		{list, []Discount{{}}},
+		{list, []Discount{{Percent: 10, Price: &promo}}},
+		{list, []Discount{{Percent: 120}}},
+		{list, []Discount{{Price: &tooHigh}}},
+		{list, []Discount{{Percent: 10, StartsAt: &later, EndsAt: &now}}},
+	}
+	for _, tc := range invalid {
+		if err := checkPricing(tc.price, tc.discounts); !errors.Is(err, errInvalidPrice) {
+			t.Errorf("checkPricing(%+v, %+v) = %v, want an invalid price", tc.price, tc.discounts, err)
+		}
+	}
+}
+
+func TestPricingOf(t *testing.T) {
+	p, err := pricingOf(BookStore{Price: &Money{Amount: 999, Currency: "USD"}, Discounts: []Discount{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Price == nil || p.Price.Amount != 999 || p.Discounts != nil {
+		t.Errorf("unexpected pricing %+v", p)
+	}
+	q, err := pricingOf(pricingFields(p.Price, nil))
+	if err != nil || q.Price == nil || *q.Price != *p.Price {
+		t.Errorf("pricing does not survive a round trip: %+v, %v", q, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Publisher is an entry of the publisher list. Books refer to it by code.
+type Publisher struct {
+	Code      string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	Website   string    `bson:"website,omitempty"`
+	CreatedBy string    `bson:"createdby,omitempty"`
+	CreatedAt time.Time `bson:"createdat"`
+	UpdatedBy string    `bson:"updatedby,omitempty"`
+	UpdatedAt time.Time `bson:"updatedat,omitempty"`
+}
+
+var (
+	errUnknownPublisher = errors.New("unknown publisher")
+	errInvalidSeries    = errors.New("seriesPosition must be positive and needs a series")
+)
+
+// normalizePublisherCode turns a publisher code into its canonical form,
+// e.g. "Penguin Books" into "penguin-books".
+func normalizePublisherCode(code string) string {
+	return strings.Join(strings.Fields(strings.ToLower(code)), "-")
+}
+
+// normalizeSeries tidies the whitespace of a series name.
+func normalizeSeries(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// checkSeries validates a series name and position. A position orders the
+// books of a series and may be fractional, e.g. 2.5 for a novella between
+// the second and third book.
+func checkSeries(series string, position *float64) error {
+	if position != nil && (*position <= 0 || series == "") {
+		return errInvalidSeries
+	}
+	return nil
+}
+
+// checkPublisher verifies that code, when set, is a known publisher.
+func checkPublisher(ctx context.Context, publishers *mongo.Collection, code string) error {
+	if code == "" {
+		return nil
+	}
+	count, err := publishers.CountDocuments(ctx, bson.M{"_id": code})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w %s", errUnknownPublisher, code)
+	}
+	return nil
+}
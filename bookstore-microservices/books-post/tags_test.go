@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeList(t *testing.T) {
+	tags := normalizeList([]string{" Gothic ", "gothic", "", "Science  Fiction"}, normalizeTag)
+	if !reflect.DeepEqual(tags, []string{"gothic", "science fiction"}) {
+		t.Errorf("tags = %v", tags)
+	}
+	codes := normalizeList([]string{"Science Fiction", "science-fiction"}, normalizeSubjectCode)
+	if !reflect.DeepEqual(codes, []string{"science-fiction"}) {
+		t.Errorf("codes = %v", codes)
+	}
+	if normalizeList(nil, normalizeTag) == nil {
+		t.Error("the result should never be nil")
+	}
+}
+
+func TestNewSubject(t *testing.T) {
+	s, err := newSubject(SubjectRequest{Name: "Science Fiction", Parent: "Fiction"}, "ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Code != "science-fiction" || s.Parent != "fiction" || s.CreatedBy != "ed" {
+		t.Errorf("unexpected subject %+v", s)
+	}
+	if _, err := newSubject(SubjectRequest{Name: "Loop", Code: "loop", Parent: "Loop"}, "ed"); err == nil {
+		t.Error("a subject cannot be its own parent")
+	}
+	if _, err := newSubject(SubjectRequest{}, "ed"); err == nil {
+		t.Error("name is required")
+	}
+}
+
+func TestNewPublisher(t *testing.T) {
+	p, err := newPublisher(PublisherRequest{Name: " Penguin  Books ", Website: " https://penguin.example "}, "ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "penguin-books" || p.Name != "Penguin Books" || p.Website != "https://penguin.example" {
+		t.Errorf("unexpected publisher %+v", p)
+	}
+	if _, err := newPublisher(PublisherRequest{Code: "x"}, "ed"); err == nil {
+		t.Error("name is required")
+	}
+}
+
+func TestCheckSeries(t *testing.T) {
+	two, zero := 2.5, 0.0
+	if err := checkSeries("Discworld", &two); err != nil {
+		t.Errorf("valid position rejected: %v", err)
+	}
+	if err := checkSeries("", nil); err != nil {
+		t.Errorf("a book without a series is fine: %v", err)
+	}
+	if err := checkSeries("Discworld", &zero); err == nil {
+		t.Error("positions must be positive")
+	}
+	if err := checkSeries("", &two); err == nil {
+		t.Error("a position needs a series")
+	}
+}
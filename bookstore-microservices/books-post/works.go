@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Work is a title independent of its editions. Every book record is an
+// edition of one work and refers to it by WorkID; the book keeps its own
+// title and author, which may differ from the original, e.g. for a
+// translation.
+type Work struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	Title     string             `bson:"title"`
+	Author    string             `bson:"author"`
+	AuthorIDs []string           `bson:"authorids"`
+	Year      string             `bson:"year,omitempty"`
+	CreatedBy string             `bson:"createdby,omitempty"`
+	CreatedAt time.Time          `bson:"createdat"`
+	UpdatedBy string             `bson:"updatedby,omitempty"`
+	UpdatedAt time.Time          `bson:"updatedat,omitempty"`
+}
+
+var errUnknownWork = errors.New("unknown work")
+
+// normalizeFormat lower-cases an edition format such as "Paperback".
+func normalizeFormat(format string) string {
+	return strings.ToLower(strings.Join(strings.Fields(format), " "))
+}
+
+// normalizeLanguage lower-cases a language code such as "EN" or "pt-BR".
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.TrimSpace(language))
+}
+
+// findWork loads the work with the given hex ID.
+func findWork(ctx context.Context, works *mongo.Collection, id string) (*Work, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s", errUnknownWork, id)
+	}
+	var work Work
+	err = works.FindOne(ctx, bson.M{"_id": oid}).Decode(&work)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w %s", errUnknownWork, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &work, nil
+}
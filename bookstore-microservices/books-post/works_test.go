@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestWorkFromBook(t *testing.T) {
+	work := workFromBook(BookRequest{
+		ID:        "b1",
+		Title:     "Frankenstein",
+		Author:    "Mary Shelley",
+		AuthorIDs: []string{"a1"},
+		Year:      "1818",
+		Edition:   "978-0-14-143947-1",
+	}, "ed")
+	if work.MongoID.IsZero() {
+		t.Error("the work needs an ID before the book can refer to it")
+	}
+	if work.Title != "Frankenstein" || work.Author != "Mary Shelley" || work.Year != "1818" || work.CreatedBy != "ed" {
+		t.Errorf("unexpected work %+v", work)
+	}
+	if len(work.AuthorIDs) != 1 || work.AuthorIDs[0] != "a1" {
+		t.Errorf("authors are not carried over: %v", work.AuthorIDs)
+	}
+}
+
+func TestEditionNormalizers(t *testing.T) {
+	if got := normalizeFormat("  Mass  Market Paperback "); got != "mass market paperback" {
+		t.Errorf("format = %q", got)
+	}
+	if got := normalizeLanguage(" pt-BR "); got != "pt-br" {
+		t.Errorf("language = %q", got)
+	}
+}
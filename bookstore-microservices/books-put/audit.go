@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditPatch   = "patch"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditRevert  = "revert"
+)
+
+// FieldChange is one field of a book that differs between two versions.
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditEntry records one change to the catalog. Entries are only ever
+// inserted.
+type AuditEntry struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    string             `bson:"bookid"`
+	Action    string             `bson:"action"`
+	Actor     string             `bson:"actor"`
+	RequestID string             `bson:"requestid,omitempty"`
+	Timestamp time.Time          `bson:"timestamp"`
+	Changes   []FieldChange      `bson:"changes,omitempty"`
+}
+
+// auditIgnoredFields are bookkeeping fields left out of diffs.
+var auditIgnoredFields = map[string]bool{
+	"_id":       true,
+	"createdby": true,
+	"createdat": true,
+	"updatedby": true,
+	"updatedat": true,
+	"deletedby": true,
+	"deletedat": true,
+}
+
+// toDocument converts a version of a book to the form the driver reads
+// documents back in. Documents built from Go values, such as a stored book
+// with a $set applied, go through it too, so that a []string is compared
+// as the primitive.A it is read back as and an unchanged field does not
+// show up as a change.
+func toDocument(v interface{}) (bson.M, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// diffDocuments lists the fields that differ between two versions of a
+// book. A nil version stands for a book that does not exist (before a
+// create, after a delete).
+func diffDocuments(before, after bson.M) []FieldChange {
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	var changes []FieldChange
+	for field := range fields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		b, a := before[field], after[field]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, Before: b, After: a})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// applySet returns a copy of doc with the fields of a $set update applied.
+func applySet(doc bson.M, set bson.M) bson.M {
+	out := bson.M{}
+	for k, v := range doc {
+		out[k] = v
+	}
+	for k, v := range set {
+		out[k] = v
+	}
+	return out
+}
+
+type auditLog struct {
+	coll *mongo.Collection
+}
+
+// record appends an audit entry for a change that has already been
+// written. Failures are logged rather than failing the request, since the
+// change itself has succeeded.
+func (a *auditLog) record(c echo.Context, action, bookID string, before, after interface{}) {
+	beforeDoc, err := toDocument(before)
+	if err == nil {
+		var afterDoc bson.M
+		afterDoc, err = toDocument(after)
+		if err == nil {
+			entry := AuditEntry{
+				BookID:    bookID,
+				Action:    action,
+				Actor:     actor(c),
+				RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+				Timestamp: time.Now().UTC(),
+				Changes:   diffDocuments(beforeDoc, afterDoc),
+			}
+			ctx, cancel := dbContext(context.WithoutCancel(c.Request().Context()), "audit")
+			defer cancel()
+			_, err = a.coll.InsertOne(ctx, entry)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error writing audit entry for %s of book %s: %v\n", action, bookID, err)
+	}
+}
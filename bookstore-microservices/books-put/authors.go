@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Author is a person books can be linked to. Key is the normalized name
+// used to recognise the same author written differently; AliasKeys holds
+// the keys of the aliases.
+type Author struct {
+	MongoID     primitive.ObjectID `bson:"_id,omitempty"`
+	Name        string             `bson:"name"`
+	SortName    string             `bson:"sortname"`
+	Key         string             `bson:"key"`
+	BirthYear   *int               `bson:"birthyear,omitempty"`
+	DeathYear   *int               `bson:"deathyear,omitempty"`
+	Nationality string             `bson:"nationality,omitempty"`
+	Aliases     []string           `bson:"aliases,omitempty"`
+	AliasKeys   []string           `bson:"aliaskeys,omitempty"`
+	CreatedBy   string             `bson:"createdby,omitempty"`
+	CreatedAt   time.Time          `bson:"createdat"`
+	UpdatedBy   string             `bson:"updatedby,omitempty"`
+	UpdatedAt   time.Time          `bson:"updatedat,omitempty"`
+}
+
+var errUnknownAuthor = errors.New("unknown author")
+
+var nameSuffixes = map[string]bool{"jr": true, "sr": true, "ii": true, "iii": true, "iv": true}
+
+// normalizeAuthorName tidies whitespace and turns "Last, First" into
+// "First Last". A comma before a suffix such as "Jr." is left alone.
+func normalizeAuthorName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	parts := strings.Split(name, ",")
+	if len(parts) != 2 {
+		return name
+	}
+	last, first := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if first == "" || last == "" || nameSuffixes[strings.ToLower(strings.TrimSuffix(first, "."))] {
+		return name
+	}
+	return first + " " + last
+}
+
+// authorKey is the form names are compared in: normalized, lower case and
+// without periods, so "Poe, Edgar Allan" and "Edgar Allan Poe" match.
+func authorKey(name string) string {
+	name = strings.ToLower(normalizeAuthorName(name))
+	name = strings.ReplaceAll(name, ".", " ")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// sortNameFor returns "Last, First" for a normalized name.
+func sortNameFor(name string) string {
+	fields := strings.Fields(name)
+	if len(fields) < 2 {
+		return name
+	}
+	last := len(fields) - 1
+	return fields[last] + ", " + strings.Join(fields[:last], " ")
+}
+
+// splitAuthors splits a book's author string into the individual names.
+// Several authors are separated by ";" or "&".
+func splitAuthors(s string) []string {
+	var names []string
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '&' }) {
+		if name := normalizeAuthorName(part); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type authorStore struct {
+	coll *mongo.Collection
+}
+
+func (s *authorStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "aliaskeys", Value: 1}}},
+		{Keys: bson.D{{Key: "sortname", Value: 1}}},
+	})
+	return err
+}
+
+func (s *authorStore) findByKey(ctx context.Context, key string) (*Author, error) {
+	var author Author
+	filter := bson.M{"$or": bson.A{bson.M{"key": key}, bson.M{"aliaskeys": key}}}
+	if err := s.coll.FindOne(ctx, filter).Decode(&author); err != nil {
+		return nil, err
+	}
+	return &author, nil
+}
+
+// findOrCreate returns the author whose name or alias matches name,
+// creating one when there is none.
+func (s *authorStore) findOrCreate(ctx context.Context, name, actor string) (*Author, error) {
+	key := authorKey(name)
+	author, err := s.findByKey(ctx, key)
+	if err != mongo.ErrNoDocuments {
+		return author, err
+	}
+
+	name = normalizeAuthorName(name)
+	author = &Author{
+		MongoID:   primitive.NewObjectID(),
+		Name:      name,
+		SortName:  sortNameFor(name),
+		Key:       key,
+		CreatedBy: actor,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err = s.coll.InsertOne(ctx, author)
+	if mongo.IsDuplicateKeyError(err) {
+		// Created concurrently by another request.
+		return s.findByKey(ctx, key)
+	}
+	return author, err
+}
+
+// byIDs loads the authors with the given hex IDs, in the same order.
+func (s *authorStore) byIDs(ctx context.Context, ids []string) ([]Author, error) {
+	oids := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errUnknownAuthor, id)
+		}
+		oids = append(oids, oid)
+	}
+	cursor, err := s.coll.Find(ctx, bson.M{"_id": bson.M{"$in": oids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []Author
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	byID := map[primitive.ObjectID]Author{}
+	for _, a := range found {
+		byID[a.MongoID] = a
+	}
+	authors := make([]Author, 0, len(oids))
+	for i, oid := range oids {
+		a, ok := byID[oid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknownAuthor, ids[i])
+		}
+		authors = append(authors, a)
+	}
+	return authors, nil
+}
+
+// resolveBookAuthors links a book to its authors. Explicit authorIDs must
+// exist; otherwise the names in author are looked up, creating authors as
+// needed. It returns the IDs and the author string to store on the book,
+// which defaults to the linked names when author is empty.
+func (s *authorStore) resolveBookAuthors(ctx context.Context, author string, authorIDs []string, actor string) ([]string, string, error) {
+	if len(authorIDs) > 0 {
+		authors, err := s.byIDs(ctx, authorIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		if author == "" {
+			names := make([]string, len(authors))
+			for i, a := range authors {
+				names[i] = a.Name
+			}
+			author = strings.Join(names, " & ")
+		}
+		return authorIDs, author, nil
+	}
+
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, name := range splitAuthors(author) {
+		a, err := s.findOrCreate(ctx, name, actor)
+		if err != nil {
+			return nil, "", err
+		}
+		if id := a.MongoID.Hex(); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, author, nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ebook is a DRM-free file of a book, at most one per format. The file is
+// kept in the "ebooks" GridFS bucket, tagged with the book ID, the format
+// and the ETag, which is derived from the uploaded bytes.
+type Ebook struct {
+	Format     string        `bson:"format"`
+	ETag       string        `bson:"etag"`
+	Filename   string        `bson:"filename"`
+	Bytes      int64         `bson:"bytes"`
+	Metadata   *EpubMetadata `bson:"metadata,omitempty"`
+	UploadedBy string        `bson:"uploadedby"`
+	UploadedAt time.Time     `bson:"uploadedat"`
+}
+
+// EpubMetadata is what the OPF package of an EPUB says about the book.
+type EpubMetadata struct {
+	Title       string   `bson:"title" json:"title"`
+	Authors     []string `bson:"authors" json:"authors"`
+	Language    string   `bson:"language" json:"language"`
+	Identifiers []string `bson:"identifiers" json:"identifiers"`
+	ISBN        string   `bson:"isbn,omitempty" json:"isbn,omitempty"`
+}
+
+// EbookMismatch is a field on which a book and the metadata of its EPUB
+// disagree.
+type EbookMismatch struct {
+	Field  string `json:"field"`
+	Record string `json:"record"`
+	Ebook  string `json:"ebook"`
+}
+
+const (
+	ebookBucketName     = "ebooks"
+	EbookEPUB           = "epub"
+	EbookPDF            = "pdf"
+	defaultMaxEbookSize = 50 << 20
+	// Package documents are small; anything larger is not one.
+	maxOPFSize = 1 << 20
+)
+
+var (
+	errMissingEbook  = errors.New("e-book is a required file")
+	errEbookType     = errors.New("e-book must be an EPUB or a PDF")
+	errInvalidEbook  = errors.New("e-book is not a readable EPUB")
+	errEbookTooLarge = errors.New("e-book is too large")
+)
+
+// maxEbookSize is the largest e-book accepted in bytes, EBOOK_MAX_BYTES
+// (default 50 MiB).
+func maxEbookSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("EBOOK_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxEbookSize
+}
+
+// ebookStatus is the status to answer a failed upload with, or 0 when err
+// is not about the file itself.
+func ebookStatus(err error) int {
+	switch {
+	case errors.Is(err, errEbookTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errEbookType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errMissingEbook), errors.Is(err, errInvalidEbook):
+		return http.StatusBadRequest
+	}
+	return 0
+}
+
+// limitEbookUpload caps the body of a multipart request to what an e-book
+// and the form fields around it can take.
+func limitEbookUpload(c echo.Context) {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxEbookSize()+1<<20)
+}
+
+// ebookUpload is a checked e-book, ready to be stored. File stays open
+// until Close.
+type ebookUpload struct {
+	Ebook Ebook
+	File  multipart.File
+}
+
+func (u *ebookUpload) Close() error {
+	return u.File.Close()
+}
+
+// readEbook opens and checks the file in the form field of a multipart
+// request. The format is sniffed from the content, and the metadata of an
+// EPUB is read from its package document.
+func readEbook(c echo.Context, field string) (*ebookUpload, error) {
+	header, err := c.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, errMissingEbook
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errEbookTooLarge
+		}
+		return nil, err
+	}
+	if header.Size > maxEbookSize() {
+		return nil, errEbookTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	upload := &ebookUpload{File: file}
+	upload.Ebook, err = checkEbook(file, header.Size, path.Base(header.Filename), actor(c))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return upload, nil
+}
+
+// checkEbook works out the format, ETag and metadata of an e-book file.
+func checkEbook(file io.ReadSeeker, size int64, filename, actor string) (Ebook, error) {
+	ebook := Ebook{Filename: filename, Bytes: size, UploadedBy: actor, UploadedAt: time.Now().UTC()}
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return ebook, errEbookType
+	}
+	switch {
+	case bytes.Equal(head, []byte("%PDF-")):
+		ebook.Format = EbookPDF
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		reader, ok := file.(io.ReaderAt)
+		if !ok {
+			return ebook, errInvalidEbook
+		}
+		metadata, err := readEpubMetadata(reader, size)
+		if err != nil {
+			return ebook, err
+		}
+		ebook.Format, ebook.Metadata = EbookEPUB, metadata
+	default:
+		return ebook, errEbookType
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ebook, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ebook, err
+	}
+	ebook.ETag = hex.EncodeToString(hash.Sum(nil)[:16])
+	_, err := file.Seek(0, io.SeekStart)
+	return ebook, err
+}
+
+// readEpubMetadata reads the title, authors, language and identifiers from
+// the OPF package document an EPUB's container points to.
+func readEpubMetadata(r io.ReaderAt, size int64) (*EpubMetadata, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errInvalidEbook
+	}
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	mimetype, err := readZipFile(files["mimetype"])
+	if err != nil || strings.TrimSpace(string(mimetype)) != "application/epub+zip" {
+		return nil, errEbookType
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath  string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	raw, err := readZipFile(files["META-INF/container.xml"])
+	if err != nil || xml.Unmarshal(raw, &container) != nil {
+		return nil, errInvalidEbook
+	}
+	opfPath := ""
+	for _, rootfile := range container.Rootfiles {
+		if rootfile.MediaType == "" || rootfile.MediaType == "application/oebps-package+xml" {
+			opfPath = rootfile.FullPath
+			break
+		}
+	}
+
+	var pkg struct {
+		Metadata struct {
+			Titles      []string `xml:"title"`
+			Creators    []string `xml:"creator"`
+			Languages   []string `xml:"language"`
+			Identifiers []string `xml:"identifier"`
+		} `xml:"metadata"`
+	}
+	raw, err = readZipFile(files[opfPath])
+	if err != nil || xml.Unmarshal(raw, &pkg) != nil {
+		return nil, errInvalidEbook
+	}
+	metadata := &EpubMetadata{Authors: []string{}, Identifiers: []string{}}
+	if titles := trimAll(pkg.Metadata.Titles); len(titles) > 0 {
+		metadata.Title = titles[0]
+	}
+	if languages := trimAll(pkg.Metadata.Languages); len(languages) > 0 {
+		metadata.Language = normalizeLanguage(languages[0])
+	}
+	metadata.Authors = trimAll(pkg.Metadata.Creators)
+	metadata.Identifiers = trimAll(pkg.Metadata.Identifiers)
+	for _, id := range metadata.Identifiers {
+		if isbn := isbnOf(id); isbn != "" {
+			metadata.ISBN = isbn
+			break
+		}
+	}
+	return metadata, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f == nil {
+		return nil, errInvalidEbook
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxOPFSize+1))
+	if err != nil || len(data) > maxOPFSize {
+		return nil, errInvalidEbook
+	}
+	return data, nil
+}
+
+func trimAll(values []string) []string {
+	ret := []string{}
+	for _, v := range values {
+		if v = strings.Join(strings.Fields(v), " "); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// isbnOf returns the digits of an identifier that is an ISBN-10 or ISBN-13,
+// such as "urn:isbn:978-0-14-143947-1", or "".
+func isbnOf(id string) string {
+	id = strings.TrimSpace(id)
+	if len(id) > 9 && strings.EqualFold(id[:9], "urn:isbn:") {
+		id = id[9:]
+	}
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == 'x' || r == 'X':
+			return 'X'
+		case r == '-' || r == ' ':
+			return -1
+		}
+		return '?'
+	}, id)
+	if strings.Contains(digits, "?") || strings.Contains(strings.TrimSuffix(digits, "X"), "X") {
+		return ""
+	}
+	if len(digits) == 13 && (strings.HasPrefix(digits, "978") || strings.HasPrefix(digits, "979")) {
+		return digits
+	}
+	if len(digits) == 10 {
+		return digits
+	}
+	return ""
+}
+
+// compareEbook cross-checks a book with the metadata of its EPUB. Fields
+// the book leaves empty are not reported, and the author matches any
+// creator of the EPUB.
+func compareEbook(title, author, language, edition string, metadata *EpubMetadata) []EbookMismatch {
+	mismatches := []EbookMismatch{}
+	if metadata == nil {
+		return mismatches
+	}
+	same := func(a, b string) bool {
+		return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+	}
+	if title != "" && metadata.Title != "" && !same(title, metadata.Title) {
+		mismatches = append(mismatches, EbookMismatch{Field: "title", Record: title, Ebook: metadata.Title})
+	}
+	if author != "" && len(metadata.Authors) > 0 {
+		found := false
+		for _, a := range metadata.Authors {
+			found = found || same(author, a)
+		}
+		if !found {
+			mismatches = append(mismatches, EbookMismatch{Field: "author", Record: author, Ebook: strings.Join(metadata.Authors, "; ")})
+		}
+	}
+	if language != "" && metadata.Language != "" && normalizeLanguage(language) != metadata.Language {
+		mismatches = append(mismatches, EbookMismatch{Field: "language", Record: language, Ebook: metadata.Language})
+	}
+	if isbn := isbnOf(edition); isbn != "" && metadata.ISBN != "" && isbn != metadata.ISBN {
+		mismatches = append(mismatches, EbookMismatch{Field: "isbn", Record: edition, Ebook: metadata.ISBN})
+	}
+	return mismatches
+}
+
+// ebookBucket opens the e-books bucket for one operation. GridFS takes
+// deadlines rather than contexts, so the bucket gets the deadline of ctx.
+func ebookBucket(ctx context.Context, db *mongo.Database) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(ebookBucketName))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+		bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// ensureEbookIndexes indexes the e-book files the way they are looked up.
+func ensureEbookIndexes(ctx context.Context, db *mongo.Database) error {
+	bucket, err := ebookBucket(ctx, db)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.GetFilesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "metadata.bookid", Value: 1}, {Key: "metadata.format", Value: 1}, {Key: "metadata.etag", Value: 1}},
+	})
+	return err
+}
+
+// storeEbook writes the file of an e-book and reports whether it did; the
+// same file is stored only once for a book.
+func storeEbook(ctx context.Context, db *mongo.Database, bookID string, upload *ebookUpload) (bool, error) {
+	bucket, err := ebookBucket(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	ebook := upload.Ebook
+	stored, err := bucket.GetFilesCollection().CountDocuments(ctx, bson.M{
+		"metadata.bookid": bookID, "metadata.format": ebook.Format, "metadata.etag": ebook.ETag,
+	})
+	if err != nil || stored > 0 {
+		return false, err
+	}
+	opts := options.GridFSUpload().SetMetadata(bson.M{
+		"bookid":   bookID,
+		"format":   ebook.Format,
+		"etag":     ebook.ETag,
+		"filename": ebook.Filename,
+	})
+	if _, err := bucket.UploadFromStream(bookID+"/"+ebook.Format, upload.File, opts); err != nil {
+		removeEbookFile(ctx, db, bookID, ebook.Format, ebook.ETag)
+		return false, err
+	}
+	return true, nil
+}
+
+// removeEbookFile deletes the file of a book in one format with the given
+// ETag.
+func removeEbookFile(ctx context.Context, db *mongo.Database, bookID, format, etag string) error {
+	bucket, err := ebookBucket(ctx, db)
+	if err != nil {
+		return err
+	}
+	cursor, err := bucket.FindContext(ctx, bson.M{"metadata.bookid": bookID, "metadata.format": format, "metadata.etag": etag})
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := bucket.DeleteContext(ctx, file.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	return nil
+}
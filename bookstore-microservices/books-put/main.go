@@ -2,25 +2,43 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type BookRequest struct {
-	ID      string `json:"id"`
-	Title   string `json:"title"`
-	Author  string `json:"author"`
-	Pages   string `json:"pages,omitempty"`
-	Edition string `json:"edition,omitempty"`
-	Year    string `json:"year,omitempty"`
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Author    string   `json:"author"`
+	AuthorIDs []string `json:"authorIds,omitempty"`
+	Pages     string   `json:"pages,omitempty"`
+	Edition   string   `json:"edition,omitempty"`
+	Year      string   `json:"year,omitempty"`
+	WorkID    string   `json:"workId,omitempty"`
+	// Clients that predate publishers, series and editions leave these
+	// out; absent fields are kept as they are. The series position is
+	// written along with the series.
+	PublisherID    *string  `json:"publisherId"`
+	Series         *string  `json:"series"`
+	SeriesPosition *float64 `json:"seriesPosition"`
+	Format         *string  `json:"format"`
+	Language       *string  `json:"language"`
+	// Discounts can only be sent with the price; left out, the book keeps
+	// its discounts.
+	Price     *Money     `json:"price"`
+	Discounts []Discount `json:"discounts"`
 }
 
 func getMongoURI() string {
@@ -50,32 +68,405 @@ func connectToMongoDB() (*mongo.Client, *mongo.Collection, error) {
 	return client, coll, nil
 }
 
-func updateBook(coll *mongo.Collection, id string, bookReq BookRequest) error {
+// BookPatch is a partial update; only fields present in the body change.
+type BookPatch struct {
+	Title          *string    `json:"title"`
+	Author         *string    `json:"author"`
+	AuthorIDs      *[]string  `json:"authorIds"`
+	Tags           *[]string  `json:"tags"`
+	Subjects       *[]string  `json:"subjects"`
+	Pages          *string    `json:"pages"`
+	Edition        *string    `json:"edition"`
+	Year           *string    `json:"year"`
+	PublisherID    *string    `json:"publisherId"`
+	Series         *string    `json:"series"`
+	SeriesPosition *float64   `json:"seriesPosition"`
+	WorkID         *string    `json:"workId"`
+	Format         *string    `json:"format"`
+	Language       *string    `json:"language"`
+	Price          *Money     `json:"price"`
+	Discounts      []Discount `json:"discounts"`
+}
+
+// PricingRequest replaces the price and discounts of a book; a null price
+// removes both.
+type PricingRequest struct {
+	Price     *Money     `json:"price"`
+	Discounts []Discount `json:"discounts"`
+}
+
+// setBookFields applies a $set to an existing book and returns the book as
+// it was before the change.
+func setBookFields(ctx context.Context, coll *mongo.Collection, id string, set bson.M, actor string) (bson.M, error) {
 	// Find the book by ID (custom ID, not MongoDB _id)
-	filter := bson.M{"id": id}
-	
-	// Create update document
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": false}}
+
+	set["updatedby"] = actor
+	set["updatedat"] = time.Now().UTC()
+	update := bson.M{"$set": set}
+
+	var before bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	return before, err
+}
+
+func updateBook(ctx context.Context, coll *mongo.Collection, id string, bookReq BookRequest, actor string) (bson.M, bson.M, error) {
+	set := bson.M{
+		"bookname":    bookReq.Title,
+		"bookauthor":  bookReq.Author,
+		"bookedition": bookReq.Edition,
+		"bookpages":   bookReq.Pages,
+		"bookyear":    bookReq.Year,
+		"authorids":   bookReq.AuthorIDs,
+	}
+	if bookReq.PublisherID != nil {
+		set["publisherid"] = *bookReq.PublisherID
+	}
+	if bookReq.Series != nil {
+		set["series"] = *bookReq.Series
+		set["seriesposition"] = bookReq.SeriesPosition
+	}
+	if bookReq.Format != nil {
+		set["format"] = normalizeFormat(*bookReq.Format)
+	}
+	if bookReq.Language != nil {
+		set["language"] = normalizeLanguage(*bookReq.Language)
+	}
+	if bookReq.Price != nil {
+		fields := pricingFields(bookReq.Price, bookReq.Discounts)
+		for k, v := range fields {
+			set[k] = v
+		}
+	}
+	// A book stays an edition of its work unless it is moved to another.
+	if bookReq.WorkID != "" {
+		set["workid"] = bookReq.WorkID
+	}
+	before, err := setBookFields(ctx, coll, id, set, actor)
+	return before, set, err
+}
+
+func patchBook(ctx context.Context, coll *mongo.Collection, id string, patch BookPatch, actor string) (bson.M, bson.M, error) {
+	set := bson.M{}
+	fields := map[string]*string{
+		"bookname":    patch.Title,
+		"bookauthor":  patch.Author,
+		"bookedition": patch.Edition,
+		"bookpages":   patch.Pages,
+		"bookyear":    patch.Year,
+	}
+	for field, value := range fields {
+		if value != nil {
+			set[field] = *value
+		}
+	}
+	if patch.AuthorIDs != nil {
+		set["authorids"] = *patch.AuthorIDs
+	}
+	if patch.Tags != nil {
+		set["tags"] = normalizeList(*patch.Tags, normalizeTag)
+	}
+	if patch.Subjects != nil {
+		set["subjects"] = normalizeList(*patch.Subjects, normalizeSubjectCode)
+	}
+	if patch.PublisherID != nil {
+		set["publisherid"] = normalizePublisherCode(*patch.PublisherID)
+	}
+	if patch.Series != nil {
+		set["series"] = normalizeSeries(*patch.Series)
+		if set["series"] == "" {
+			// Leaving a series drops the position in it too.
+			set["seriesposition"] = nil
+		}
+	}
+	if patch.SeriesPosition != nil {
+		set["seriesposition"] = *patch.SeriesPosition
+	}
+	if patch.WorkID != nil {
+		set["workid"] = *patch.WorkID
+	}
+	if patch.Format != nil {
+		set["format"] = normalizeFormat(*patch.Format)
+	}
+	if patch.Language != nil {
+		set["language"] = normalizeLanguage(*patch.Language)
+	}
+	if patch.Price != nil {
+		fields := pricingFields(patch.Price, patch.Discounts)
+		for k, v := range fields {
+			set[k] = v
+		}
+	}
+	before, err := setBookFields(ctx, coll, id, set, actor)
+	return before, set, err
+}
+
+// setPricing replaces the price and discounts of a book and returns the
+// book before and after the change.
+func setPricing(ctx context.Context, coll *mongo.Collection, id string, req PricingRequest, actor string) (bson.M, bson.M, error) {
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": false}}
+	set := bson.M{"updatedby": actor, "updatedat": time.Now().UTC()}
+	update := bson.M{"$set": set}
+	if req.Price == nil {
+		update["$unset"] = bson.M{"price": "", "discounts": ""}
+	} else {
+		if req.Discounts == nil {
+			req.Discounts = []Discount{}
+		}
+		fields := pricingFields(req.Price, req.Discounts)
+		for k, v := range fields {
+			set[k] = v
+		}
+	}
+
+	var before bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	after := applySet(before, set)
+	if req.Price == nil {
+		delete(after, "price")
+		delete(after, "discounts")
+	}
+	return before, after, nil
+}
+
+// setCover makes cover the cover of a book, whose files must be stored
+// already, and removes the files of the cover it had before.
+func setCover(ctx context.Context, coll *mongo.Collection, id string, cover Cover, actor string) (bson.M, bson.M, error) {
+	doc, err := toDocument(cover)
+	if err != nil {
+		return nil, nil, err
+	}
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": false}}
+	set := bson.M{"cover": doc, "updatedby": actor, "updatedat": time.Now().UTC()}
+
+	var before bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if previous, ok := before["cover"].(bson.M); ok {
+		if etag, _ := previous["etag"].(string); etag != "" && etag != cover.ETag {
+			if err := removeCoverFiles(ctx, coll.Database(), id, etag); err != nil {
+				fmt.Printf("Error removing the previous cover of book %s: %v\n", id, err)
+			}
+		}
+	}
+	return before, applySet(before, set), nil
+}
+
+// setEbook attaches an e-book, whose file must be stored already, to a
+// book in place of the one it had in that format. It returns the book
+// before and after, and removes the file replaced.
+func setEbook(ctx context.Context, coll *mongo.Collection, id string, ebook Ebook, actor string) (bson.M, bson.M, error) {
+	doc, err := toDocument(ebook)
+	if err != nil {
+		return nil, nil, err
+	}
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": false}}
+	now := time.Now().UTC()
+	// A pipeline swaps the entry of the format in one write.
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"ebooks": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$ebooks", bson.A{}}},
+				"cond":  bson.M{"$ne": bson.A{"$$this.format", ebook.Format}},
+			}},
+			bson.A{bson.M{"$literal": doc}},
+		}},
+		"updatedby": actor,
+		"updatedat": now,
+	}}}}
+
+	var before bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ebooks := bson.A{}
+	previous, _ := before["ebooks"].(bson.A)
+	for _, entry := range previous {
+		old, _ := entry.(bson.M)
+		if old["format"] != ebook.Format {
+			ebooks = append(ebooks, old)
+			continue
+		}
+		if etag, _ := old["etag"].(string); etag != "" && etag != ebook.ETag {
+			if err := removeEbookFile(ctx, coll.Database(), id, ebook.Format, etag); err != nil {
+				fmt.Printf("Error removing the previous %s of book %s: %v\n", ebook.Format, id, err)
+			}
+		}
+	}
+	ebooks = append(ebooks, doc)
+	return before, applySet(before, bson.M{"ebooks": ebooks, "updatedby": actor, "updatedat": now}), nil
+}
+
+// restoreBook undoes a delete and returns the restored book.
+func restoreBook(ctx context.Context, coll *mongo.Collection, id string, actor string) (bson.M, error) {
+	filter := bson.M{"id": id, "deletedat": bson.M{"$exists": true}}
 	update := bson.M{
-		"$set": bson.M{
-			"bookname":    bookReq.Title,
-			"bookauthor":  bookReq.Author,
-			"bookedition": bookReq.Edition,
-			"bookpages":   bookReq.Pages,
-			"bookyear":    bookReq.Year,
-		},
+		"$unset": bson.M{"deletedat": "", "deletedby": ""},
+		"$set":   bson.M{"updatedby": actor, "updatedat": time.Now().UTC()},
 	}
 
-	result, err := coll.UpdateOne(context.TODO(), filter, update)
+	var after bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("deleted book with ID %s not found", id)
+	}
+	return after, err
+}
+
+// revisionFields are the parts of a book a revert copies back. The work a
+// book is an edition of is left alone, and so is its price, which has a
+// history of its own.
+var revisionFields = []string{"bookname", "bookauthor", "authorids", "bookedition", "bookpages", "bookyear", "tags", "subjects",
+	"publisherid", "series", "seriesposition", "format", "language"}
+
+// revertBook writes the fields of an earlier revision back to the book as a
+// new change, restoring the book if it is currently deleted. It returns the
+// book before and after the revert.
+func revertBook(ctx context.Context, coll, revisionColl *mongo.Collection, id string, number int64, actor string) (bson.M, bson.M, error) {
+	var rev Revision
+	err := revisionColl.FindOne(ctx, bson.M{"bookid": id, "revision": number}).Decode(&rev)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("revision %d of book %s not found", number, id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if rev.Deleted {
+		return nil, nil, errRevertToDeleted
+	}
+
+	set := bson.M{"updatedby": actor, "updatedat": time.Now().UTC()}
+	for _, field := range revisionFields {
+		set[field] = rev.Book[field]
+	}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"deletedat": "", "deletedby": ""},
+	}
+
+	var before bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err = coll.FindOneAndUpdate(ctx, bson.M{"id": id}, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("book with ID %s not found", id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	after := applySet(before, set)
+	delete(after, "deletedat")
+	delete(after, "deletedby")
+	return before, after, nil
+}
+
+var errRevertToDeleted = errors.New("cannot revert to a revision that deleted the book")
+
+// updateWork changes the original title, author and year of a work. The
+// editions keep their own title and author.
+func updateWork(ctx context.Context, works *mongo.Collection, id string, set bson.M, actor string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("work %s not found", id)
+	}
+	set["updatedby"] = actor
+	set["updatedat"] = time.Now().UTC()
+	result, err := works.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": set})
 	if err != nil {
 		return err
 	}
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("book with ID %s not found", id)
+		return fmt.Errorf("work %s not found", id)
+	}
+	return nil
+}
+
+var errSubjectCycle = errors.New("a subject cannot be placed below itself")
+
+// updateSubject renames a subject or moves it below another parent,
+// refusing moves that would make the tree cyclic.
+func updateSubject(ctx context.Context, subjects *mongo.Collection, code, name, parent, actor string) error {
+	for p := parent; p != ""; {
+		if p == code {
+			return errSubjectCycle
+		}
+		var s Subject
+		err := subjects.FindOne(ctx, bson.M{"_id": p}).Decode(&s)
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("%w %s", errUnknownSubject, p)
+		}
+		if err != nil {
+			return err
+		}
+		p = s.Parent
 	}
 
+	set := bson.M{"name": name, "updatedby": actor, "updatedat": time.Now().UTC()}
+	update := bson.M{"$set": set}
+	if parent == "" {
+		update["$unset"] = bson.M{"parent": ""}
+	} else {
+		set["parent"] = parent
+	}
+	result, err := subjects.UpdateOne(ctx, bson.M{"_id": code}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("subject %s not found", code)
+	}
 	return nil
 }
 
+func writeError(c echo.Context, err error) error {
+	if errors.Is(err, errUnknownAuthor) || errors.Is(err, errUnknownSubject) || errors.Is(err, errSubjectCycle) ||
+		errors.Is(err, errUnknownPublisher) || errors.Is(err, errInvalidSeries) || errors.Is(err, errUnknownWork) ||
+		errors.Is(err, errInvalidPrice) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err == errRevertToDeleted {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	if strings.HasSuffix(err.Error(), "not found") {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
 func main() {
 	// Wait for MongoDB to be ready
 	fmt.Println("Waiting for MongoDB to be ready...")
@@ -106,7 +497,35 @@ func main() {
 		}
 	}()
 
+	keys := newKeyStore(collection(client, "apikeys"))
+	audit := &auditLog{coll: collection(client, "audit")}
+	authors := &authorStore{coll: collection(client, "authors")}
+	subjects := collection(client, "subjects")
+	publishers := collection(client, "publishers")
+	works := collection(client, "works")
+	revisions := &revisionLog{coll: collection(client, "revisions")}
+	prices := &priceHistory{coll: collection(client, "pricehistory")}
+	if client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := revisions.ensureIndexes(ctx); err != nil {
+			fmt.Printf("Warning: Failed to create revision indexes: %v\n", err)
+		}
+		if err := ensureCoverIndexes(ctx, coll.Database()); err != nil {
+			fmt.Printf("Warning: Failed to create cover indexes: %v\n", err)
+		}
+		if err := ensureEbookIndexes(ctx, coll.Database()); err != nil {
+			fmt.Printf("Warning: Failed to create e-book indexes: %v\n", err)
+		}
+		cancel()
+	}
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
 	e := echo.New()
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
 
@@ -120,27 +539,442 @@ func main() {
 		}
 
 		// Validate required fields
-		if bookReq.Title == "" || bookReq.Author == "" {
+		if bookReq.Title == "" || (bookReq.Author == "" && len(bookReq.AuthorIDs) == 0) {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": "Title and author are required fields",
 			})
 		}
 
-		if err := updateBook(coll, id, bookReq); err != nil {
-			if err.Error() == fmt.Sprintf("book with ID %s not found", id) {
-				return c.JSON(http.StatusNotFound, map[string]string{
-					"error": err.Error(),
-				})
+		if bookReq.Series != nil {
+			series := normalizeSeries(*bookReq.Series)
+			bookReq.Series = &series
+			if err := checkSeries(series, bookReq.SeriesPosition); err != nil {
+				return writeError(c, err)
 			}
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": err.Error(),
+		} else if bookReq.SeriesPosition != nil {
+			return writeError(c, errInvalidSeries)
+		}
+		if bookReq.Price != nil || len(bookReq.Discounts) > 0 {
+			normalizePricing(bookReq.Price, bookReq.Discounts)
+			if err := checkPricing(bookReq.Price, bookReq.Discounts); err != nil {
+				return writeError(c, err)
+			}
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		authorIDs, author, err := authors.resolveBookAuthors(ctx, bookReq.Author, bookReq.AuthorIDs, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		bookReq.AuthorIDs, bookReq.Author = authorIDs, author
+		if bookReq.PublisherID != nil {
+			code := normalizePublisherCode(*bookReq.PublisherID)
+			bookReq.PublisherID = &code
+			if err := checkPublisher(ctx, publishers, code); err != nil {
+				return writeError(c, err)
+			}
+		}
+		if bookReq.WorkID != "" {
+			if _, err := findWork(ctx, works, bookReq.WorkID); err != nil {
+				return writeError(c, err)
+			}
+		}
+		before, set, err := updateBook(ctx, coll, id, bookReq, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		after := applySet(before, set)
+		audit.record(c, AuditUpdate, id, before, after)
+		revisions.save(c, AuditUpdate, id, before, after, false)
+		prices.record(c, id, before, after)
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Book updated successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PATCH("/api/books/:id", func(c echo.Context) error {
+		id := c.Param("id")
+		var patch BookPatch
+		if err := c.Bind(&patch); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+
+		if (patch.Title != nil && *patch.Title == "") || (patch.Author != nil && *patch.Author == "") {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Title and author cannot be empty",
 			})
 		}
+		if patch.SeriesPosition != nil && (*patch.SeriesPosition <= 0 || (patch.Series != nil && normalizeSeries(*patch.Series) == "")) {
+			return writeError(c, errInvalidSeries)
+		}
+		if patch.Price != nil || len(patch.Discounts) > 0 {
+			normalizePricing(patch.Price, patch.Discounts)
+			if err := checkPricing(patch.Price, patch.Discounts); err != nil {
+				return writeError(c, err)
+			}
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "patch")
+		defer cancel()
+		if patch.Author != nil || (patch.AuthorIDs != nil && len(*patch.AuthorIDs) > 0) {
+			var name string
+			var ids []string
+			if patch.Author != nil {
+				name = *patch.Author
+			}
+			if patch.AuthorIDs != nil {
+				ids = *patch.AuthorIDs
+			}
+			authorIDs, author, err := authors.resolveBookAuthors(ctx, name, ids, actor(c))
+			if err != nil {
+				return writeError(c, err)
+			}
+			patch.Author, patch.AuthorIDs = &author, &authorIDs
+		} else if patch.AuthorIDs != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Title and author cannot be empty",
+			})
+		}
+		if patch.Subjects != nil {
+			codes := normalizeList(*patch.Subjects, normalizeSubjectCode)
+			if err := checkSubjects(ctx, subjects, codes); err != nil {
+				return writeError(c, err)
+			}
+		}
+		if patch.PublisherID != nil {
+			if err := checkPublisher(ctx, publishers, normalizePublisherCode(*patch.PublisherID)); err != nil {
+				return writeError(c, err)
+			}
+		}
+		if patch.WorkID != nil {
+			if _, err := findWork(ctx, works, *patch.WorkID); err != nil {
+				return writeError(c, err)
+			}
+		}
+		before, set, err := patchBook(ctx, coll, id, patch, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		after := applySet(before, set)
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+		prices.record(c, id, before, after)
 
 		return c.JSON(http.StatusOK, map[string]string{
 			"message": "Book updated successfully",
 		})
-	})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/price", func(c echo.Context) error {
+		id := c.Param("id")
+		var req PricingRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		normalizePricing(req.Price, req.Discounts)
+		if err := checkPricing(req.Price, req.Discounts); err != nil {
+			return writeError(c, err)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		before, after, err := setPricing(ctx, coll, id, req, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+		prices.record(c, id, before, after)
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Price updated successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/cover", func(c echo.Context) error {
+		id := c.Param("id")
+		limitUpload(c)
+		data, err := readCover(c)
+		if err != nil && coverStatus(err) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		var upload *coverUpload
+		if err == nil {
+			upload, err = processCover(data, actor(c))
+		}
+		if err != nil {
+			return c.JSON(coverStatus(err), map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "cover")
+		defer cancel()
+		written, err := storeCover(ctx, coll.Database(), id, upload)
+		if err != nil {
+			return writeError(c, err)
+		}
+		before, after, err := setCover(ctx, coll, id, upload.Cover, actor(c))
+		if err != nil {
+			if written {
+				removeCoverFiles(ctx, coll.Database(), id, upload.Cover.ETag)
+			}
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Cover uploaded successfully",
+			"etag":    upload.Cover.ETag,
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/ebooks", func(c echo.Context) error {
+		id := c.Param("id")
+		limitEbookUpload(c)
+		upload, err := readEbook(c, "file")
+		if status := ebookStatus(err); status != 0 {
+			return c.JSON(status, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		defer upload.Close()
+
+		ctx, cancel := dbContext(c.Request().Context(), "ebook")
+		defer cancel()
+		ebook := upload.Ebook
+		written, err := storeEbook(ctx, coll.Database(), id, upload)
+		if err != nil {
+			return writeError(c, err)
+		}
+		before, after, err := setEbook(ctx, coll, id, ebook, actor(c))
+		if err != nil {
+			if written {
+				removeEbookFile(ctx, coll.Database(), id, ebook.Format, ebook.ETag)
+			}
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+
+		field := func(name string) string {
+			value, _ := before[name].(string)
+			return value
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":    "E-book attached successfully",
+			"format":     ebook.Format,
+			"metadata":   ebook.Metadata,
+			"mismatches": compareEbook(field("bookname"), field("bookauthor"), field("language"), field("bookedition"), ebook.Metadata),
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/restore", func(c echo.Context) error {
+		id := c.Param("id")
+
+		ctx, cancel := dbContext(c.Request().Context(), "restore")
+		defer cancel()
+		after, err := restoreBook(ctx, coll, id, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditRestore, id, nil, after)
+		revisions.save(c, AuditRestore, id, nil, after, false)
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Book restored successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/revert", func(c echo.Context) error {
+		id := c.Param("id")
+		var body struct {
+			Revision int64 `json:"revision"`
+		}
+		if err := c.Bind(&body); err != nil || body.Revision <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "A positive revision number is required",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "revert")
+		defer cancel()
+		before, after, err := revertBook(ctx, coll, revisions.coll, id, body.Revision, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditRevert, id, before, after)
+		revisions.save(c, AuditRevert, id, before, after, false)
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": fmt.Sprintf("Book reverted to revision %d", body.Revision),
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/tags/:tag", func(c echo.Context) error {
+		id := c.Param("id")
+		tag := normalizeTag(c.Param("tag"))
+		if tag == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Tag cannot be empty",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "tag")
+		defer cancel()
+		before, after, err := updateBookList(ctx, coll, id, "tags", tag, true, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message": "Tag added successfully",
+			"tags":    after["tags"],
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/books/:id/subjects/:subject", func(c echo.Context) error {
+		id := c.Param("id")
+		code := normalizeSubjectCode(c.Param("subject"))
+
+		ctx, cancel := dbContext(c.Request().Context(), "tag")
+		defer cancel()
+		if err := checkSubjects(ctx, subjects, []string{code}); err != nil {
+			return writeError(c, err)
+		}
+		before, after, err := updateBookList(ctx, coll, id, "subjects", code, true, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		audit.record(c, AuditPatch, id, before, after)
+		revisions.save(c, AuditPatch, id, before, after, false)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":  "Subject added successfully",
+			"subjects": after["subjects"],
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/subjects/:code", func(c echo.Context) error {
+		var req struct {
+			Name   string `json:"name"`
+			Parent string `json:"parent"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		name := strings.Join(strings.Fields(req.Name), " ")
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "name is a required field",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		code := normalizeSubjectCode(c.Param("code"))
+		if err := updateSubject(ctx, subjects, code, name, normalizeSubjectCode(req.Parent), actor(c)); err != nil {
+			return writeError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Subject updated successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/works/:id", func(c echo.Context) error {
+		var req struct {
+			Title     string   `json:"title"`
+			Author    string   `json:"author"`
+			AuthorIDs []string `json:"authorIds,omitempty"`
+			Year      string   `json:"year,omitempty"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		req.Title = strings.TrimSpace(req.Title)
+		if req.Title == "" || (req.Author == "" && len(req.AuthorIDs) == 0) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Title and author are required fields",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		authorIDs, author, err := authors.resolveBookAuthors(ctx, req.Author, req.AuthorIDs, actor(c))
+		if err != nil {
+			return writeError(c, err)
+		}
+		set := bson.M{
+			"title":     req.Title,
+			"author":    author,
+			"authorids": authorIDs,
+			"year":      strings.TrimSpace(req.Year),
+		}
+		if err := updateWork(ctx, works, c.Param("id"), set, actor(c)); err != nil {
+			return writeError(c, err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Work updated successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
+
+	e.PUT("/api/publishers/:code", func(c echo.Context) error {
+		var req struct {
+			Name    string `json:"name"`
+			Website string `json:"website"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		name := strings.Join(strings.Fields(req.Name), " ")
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "name is a required field",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		code := normalizePublisherCode(c.Param("code"))
+		set := bson.M{
+			"name":      name,
+			"website":   strings.TrimSpace(req.Website),
+			"updatedby": actor(c),
+			"updatedat": time.Now().UTC(),
+		}
+		result, err := publishers.UpdateOne(ctx, bson.M{"_id": code}, bson.M{"$set": set})
+		if err != nil {
+			return writeError(c, err)
+		}
+		if result.MatchedCount == 0 {
+			return writeError(c, fmt.Errorf("publisher %s not found", code))
+		}
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Publisher updated successfully",
+		})
+	}, authorize(auth, keys, RoleEditor))
 
 	// Health check endpoint
 	e.GET("/health", func(c echo.Context) error {
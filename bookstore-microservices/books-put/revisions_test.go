@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSnapshotTime(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	doc := bson.M{"createdat": primitive.NewDateTimeFromTime(created)}
+	if got := snapshotTime(doc); !got.Equal(created) {
+		t.Errorf("expected creation time, got %v", got)
+	}
+	doc["updatedat"] = primitive.NewDateTimeFromTime(updated)
+	if got := snapshotTime(doc); !got.Equal(updated) {
+		t.Errorf("expected update time, got %v", got)
+	}
+	if got := snapshotTime(bson.M{}); !got.IsZero() {
+		t.Errorf("expected zero time, got %v", got)
+	}
+}
+
+func TestSnapshotDocumentDropsStorageFields(t *testing.T) {
+	doc := bson.M{"_id": "x", "id": "b1", "bookname": "Dune", "deletedat": 1, "deletedby": "ed"}
+	snap := snapshotDocument(doc)
+	if len(snap) != 2 || snap["id"] != "b1" || snap["bookname"] != "Dune" {
+		t.Errorf("unexpected snapshot %v", snap)
+	}
+}
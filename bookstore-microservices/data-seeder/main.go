@@ -22,6 +22,12 @@ type BookStore struct {
 	BookEdition string             `bson:"bookedition"`
 	BookPages   string             `bson:"bookpages"`
 	BookYear    string             `bson:"bookyear"`
+	CreatedBy   string             `bson:"createdby,omitempty"`
+	CreatedAt   time.Time          `bson:"createdat,omitempty"`
+	UpdatedBy   string             `bson:"updatedby,omitempty"`
+	UpdatedAt   time.Time          `bson:"updatedat,omitempty"`
+	DeletedBy   string             `bson:"deletedby,omitempty"`
+	DeletedAt   *time.Time         `bson:"deletedat,omitempty"`
 }
 
 func getMongoURI() string {
@@ -40,7 +46,7 @@ func prepareDatabase(client *mongo.Client, dbName string, collecName string) (*m
 		return nil, err
 	}
 	if !slices.Contains(names, collecName) {
-		cmd := bson.D{{"create", collecName}}
+		cmd := bson.D{{Key: "create", Value: collecName}}
 		var result bson.M
 		if err = db.RunCommand(context.TODO(), cmd).Decode(&result); err != nil {
 			log.Fatal(err)
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StockLevel is the number of copies of a book at one location. Books are
+// editions, so stock is kept per edition.
+type StockLevel struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    string             `bson:"bookid"`
+	Location  string             `bson:"location"`
+	Quantity  int64              `bson:"quantity"`
+	UpdatedBy string             `bson:"updatedby,omitempty"`
+	UpdatedAt time.Time          `bson:"updatedat"`
+}
+
+// Movement records one change of a stock level and why it happened. Both
+// halves of a transfer share a Reference.
+type Movement struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    string             `bson:"bookid"`
+	Location  string             `bson:"location"`
+	Delta     int64              `bson:"delta"`
+	Kind      string             `bson:"kind"`
+	Reason    string             `bson:"reason,omitempty"`
+	Reference string             `bson:"reference,omitempty"`
+	Actor     string             `bson:"actor"`
+	Timestamp time.Time          `bson:"timestamp"`
+}
+
+const (
+	MovementReceive     = "receive"
+	MovementAdjust      = "adjust"
+	MovementTransferOut = "transfer-out"
+	MovementTransferIn  = "transfer-in"
+	MovementDecrement   = "decrement"
+)
+
+// defaultLocation is used when a request names no location.
+const defaultLocation = "main"
+
+var (
+	errInsufficientStock = errors.New("not enough copies in stock")
+	errUnknownBook       = errors.New("book not found")
+)
+
+// normalizeLocation turns a location name into its code, e.g. "Back Room"
+// into "back-room". It defaults to the main location.
+func normalizeLocation(location string) string {
+	code := strings.Join(strings.Fields(strings.ToLower(location)), "-")
+	if code == "" {
+		return defaultLocation
+	}
+	return code
+}
+
+type inventoryStore struct {
+	client    *mongo.Client
+	stock     *mongo.Collection
+	movements *mongo.Collection
+	books     *mongo.Collection
+}
+
+func (s *inventoryStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.stock.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "bookid", Value: 1}, {Key: "location", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.movements.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "bookid", Value: 1}, {Key: "timestamp", Value: -1}},
+	})
+	return err
+}
+
+// checkBook verifies that id is a book of the catalog.
+func (s *inventoryStore) checkBook(ctx context.Context, id string) error {
+	count, err := s.books.CountDocuments(ctx, bson.M{"id": id, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownBook
+	}
+	return nil
+}
+
+// change adds delta to the stock of a book at a location and returns the
+// new quantity. A decrement only applies when enough copies are there, in
+// the same update, so concurrent decrements can never take the stock below
+// zero.
+func (s *inventoryStore) change(ctx context.Context, bookID, location string, delta int64, actor string) (int64, error) {
+	filter := bson.M{"bookid": bookID, "location": location}
+	update := bson.M{
+		"$inc": bson.M{"quantity": delta},
+		"$set": bson.M{"updatedby": actor, "updatedat": time.Now().UTC()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if delta < 0 {
+		filter["quantity"] = bson.M{"$gte": -delta}
+	} else {
+		opts.SetUpsert(true)
+	}
+
+	var level StockLevel
+	err := s.stock.FindOneAndUpdate(ctx, filter, update, opts).Decode(&level)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created the level first; it exists now.
+		err = s.stock.FindOneAndUpdate(ctx, filter, update, opts).Decode(&level)
+	}
+	if err == mongo.ErrNoDocuments {
+		return 0, errInsufficientStock
+	}
+	if err != nil {
+		return 0, err
+	}
+	return level.Quantity, nil
+}
+
+// record stores a movement. The stock has already changed by then, so a
+// failure is logged rather than returned.
+func (s *inventoryStore) record(ctx context.Context, m Movement) {
+	m.Timestamp = time.Now().UTC()
+	if _, err := s.movements.InsertOne(ctx, m); err != nil {
+		fmt.Printf("Error recording stock movement for book %s: %v\n", m.BookID, err)
+	}
+}
+
+// transfer moves copies of a book between two locations. Both stock
+// levels and both movements are written in one transaction, so copies are
+// never lost or counted twice and the history always has both halves.
+func (s *inventoryStore) transfer(ctx context.Context, bookID, from, to string, quantity int64, reason, actor string) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := s.change(sc, bookID, from, -quantity, actor); err != nil {
+			return nil, err
+		}
+		if _, err := s.change(sc, bookID, to, quantity, actor); err != nil {
+			return nil, err
+		}
+
+		now := time.Now().UTC()
+		reference := primitive.NewObjectID().Hex()
+		_, err := s.movements.InsertMany(sc, []interface{}{
+			Movement{BookID: bookID, Location: from, Delta: -quantity, Kind: MovementTransferOut, Reason: reason, Reference: reference, Actor: actor, Timestamp: now},
+			Movement{BookID: bookID, Location: to, Delta: quantity, Kind: MovementTransferIn, Reason: reason, Reference: reference, Actor: actor, Timestamp: now},
+		})
+		return nil, err
+	})
+	return err
+}
+
+func (s *inventoryStore) levels(ctx context.Context, bookID string) ([]StockLevel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "location", Value: 1}})
+	cursor, err := s.stock.Find(ctx, bson.M{"bookid": bookID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var levels []StockLevel
+	err = cursor.All(ctx, &levels)
+	return levels, err
+}
+
+func (s *inventoryStore) history(ctx context.Context, bookID string, limit, offset int64) ([]Movement, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := s.movements.Find(ctx, bson.M{"bookid": bookID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var movements []Movement
+	err = cursor.All(ctx, &movements)
+	return movements, err
+}
+
+// lowStockPipeline totals the stock of every tracked book over all
+// locations and keeps the books of the catalog with at most threshold
+// copies, fewest first.
+func lowStockPipeline(threshold, limit, offset int64) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$bookid", "total": bson.M{"$sum": "$quantity"}}}},
+		{{Key: "$match", Value: bson.M{"total": bson.M{"$lte": threshold}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "information",
+			"localField":   "_id",
+			"foreignField": "id",
+			"as":           "book",
+		}}},
+		{{Key: "$unwind", Value: "$book"}},
+		{{Key: "$match", Value: bson.M{"book.deletedat": bson.M{"$exists": false}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: offset}},
+		{{Key: "$limit", Value: limit}},
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNormalizeLocation(t *testing.T) {
+	cases := map[string]string{
+		"":            "main",
+		"  ":          "main",
+		"Back Room":   "back-room",
+		"warehouse-2": "warehouse-2",
+	}
+	for in, want := range cases {
+		if got := normalizeLocation(in); got != want {
+			t.Errorf("normalizeLocation(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestToStockResponseTotals(t *testing.T) {
+	got := toStockResponse("b1", []StockLevel{
+		{Location: "main", Quantity: 4},
+		{Location: "back-room", Quantity: 2},
+	})
+	if got.Total != 6 || len(got.Locations) != 2 || got.Locations[1].Location != "back-room" {
+		t.Errorf("unexpected stock %+v", got)
+	}
+	if empty := toStockResponse("b2", nil); empty.Locations == nil || empty.Total != 0 {
+		t.Errorf("untracked books should have an empty stock, got %+v", empty)
+	}
+}
+
+func TestLowStockThreshold(t *testing.T) {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest("GET", "/api/inventory/low-stock?threshold=0", nil), httptest.NewRecorder())
+	if got := lowStockThreshold(c); got != 0 {
+		t.Errorf("threshold = %d, want 0", got)
+	}
+
+	t.Setenv("LOW_STOCK_THRESHOLD", "5")
+	c = e.NewContext(httptest.NewRequest("GET", "/api/inventory/low-stock", nil), httptest.NewRecorder())
+	if got := lowStockThreshold(c); got != 5 {
+		t.Errorf("threshold = %d, want 5 from the environment", got)
+	}
+
+	t.Setenv("LOW_STOCK_THRESHOLD", "")
+	c = e.NewContext(httptest.NewRequest("GET", "/api/inventory/low-stock?threshold=-1", nil), httptest.NewRecorder())
+	if got := lowStockThreshold(c); got != 3 {
+		t.Errorf("threshold = %d, want the default 3", got)
+	}
+}
+
+func TestLowStockPipelineFiltersOnTotal(t *testing.T) {
+	pipeline := lowStockPipeline(2, 10, 0)
+	match := pipeline[1][0]
+	if match.Key != "$match" {
+		t.Fatalf("expected the totals to be matched after grouping, got %s", match.Key)
+	}
+	total := match.Value.(bson.M)["total"].(bson.M)
+	if total["$lte"] != int64(2) {
+		t.Errorf("unexpected total filter %v", total)
+	}
+}
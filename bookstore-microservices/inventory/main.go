@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LocationStock struct {
+	Location string `json:"location"`
+	Quantity int64  `json:"quantity"`
+}
+
+type StockResponse struct {
+	BookID    string          `json:"bookId"`
+	Total     int64           `json:"total"`
+	Locations []LocationStock `json:"locations"`
+}
+
+type MovementResponse struct {
+	Location  string    `json:"location"`
+	Delta     int64     `json:"delta"`
+	Kind      string    `json:"kind"`
+	Reason    string    `json:"reason,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	Actor     string    `json:"actor"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type LowStockResponse struct {
+	BookID string `json:"bookId"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Total  int64  `json:"total"`
+}
+
+// StockRequest is the body of the receive, adjust and decrement endpoints.
+// Receive and decrement take a positive quantity; adjust takes a signed
+// delta and a reason.
+type StockRequest struct {
+	Location string `json:"location"`
+	Quantity int64  `json:"quantity"`
+	Delta    int64  `json:"delta"`
+	Reason   string `json:"reason"`
+}
+
+type TransferRequest struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Quantity int64  `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *inventoryStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := client.Database("exercise-1")
+	store := &inventoryStore{
+		client:    client,
+		stock:     db.Collection("stock"),
+		movements: db.Collection("stockmovements"),
+		books:     db.Collection("information"),
+	}
+	if err := store.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, store, nil
+}
+
+// pagination reads limit and offset from the query string.
+func pagination(c echo.Context, defaultLimit, maxLimit int64) (int64, int64) {
+	limit, err := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// lowStockThreshold is the stock at or below which a book is reported as
+// low. It defaults to LOW_STOCK_THRESHOLD, or 3.
+func lowStockThreshold(c echo.Context) int64 {
+	for _, value := range []string{c.QueryParam("threshold"), os.Getenv("LOW_STOCK_THRESHOLD")} {
+		if threshold, err := strconv.ParseInt(value, 10, 64); err == nil && threshold >= 0 {
+			return threshold
+		}
+	}
+	return 3
+}
+
+func toStockResponse(bookID string, levels []StockLevel) StockResponse {
+	ret := StockResponse{BookID: bookID, Locations: []LocationStock{}}
+	for _, level := range levels {
+		ret.Total += level.Quantity
+		ret.Locations = append(ret.Locations, LocationStock{Location: level.Location, Quantity: level.Quantity})
+	}
+	return ret
+}
+
+// bindStock reads a stock request, normalizing the location and reason.
+func bindStock(c echo.Context) (StockRequest, error) {
+	var req StockRequest
+	if err := c.Bind(&req); err != nil {
+		return req, errors.New("Invalid request body")
+	}
+	req.Location = normalizeLocation(req.Location)
+	req.Reason = strings.TrimSpace(req.Reason)
+	return req, nil
+}
+
+func inventoryError(c echo.Context, err error) error {
+	if err == errUnknownBook {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Book not found",
+		})
+	}
+	if err == errInsufficientStock {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func badRequest(c echo.Context, message string) error {
+	return c.JSON(http.StatusBadRequest, map[string]string{
+		"error": message,
+	})
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var store *inventoryStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, store, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		store = &inventoryStore{}
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	keys := newKeyStore(collection(client, "apikeys"))
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	// stockAfter answers with the stock of a book after a change.
+	stockAfter := func(ctx context.Context, c echo.Context, bookID string) error {
+		levels, err := store.levels(ctx, bookID)
+		if err != nil {
+			return inventoryError(c, err)
+		}
+		return c.JSON(http.StatusOK, toStockResponse(bookID, levels))
+	}
+
+	e.GET("/api/inventory/books/:bookId", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		return stockAfter(ctx, c, c.Param("bookId"))
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/inventory/books/:bookId/movements", func(c echo.Context) error {
+		limit, offset := pagination(c, 100, 1000)
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		movements, err := store.history(ctx, c.Param("bookId"), limit, offset)
+		if err != nil {
+			return inventoryError(c, err)
+		}
+		ret := []MovementResponse{}
+		for _, m := range movements {
+			ret = append(ret, MovementResponse{
+				Location:  m.Location,
+				Delta:     m.Delta,
+				Kind:      m.Kind,
+				Reason:    m.Reason,
+				Reference: m.Reference,
+				Actor:     m.Actor,
+				Timestamp: m.Timestamp,
+			})
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.GET("/api/inventory/low-stock", func(c echo.Context) error {
+		limit, offset := pagination(c, 100, 1000)
+		ctx, cancel := dbContext(c.Request().Context(), "report")
+		defer cancel()
+		cursor, err := store.stock.Aggregate(ctx, lowStockPipeline(lowStockThreshold(c), limit, offset))
+		if err != nil {
+			return inventoryError(c, err)
+		}
+		var rows []struct {
+			BookID string `bson:"_id"`
+			Total  int64  `bson:"total"`
+			Book   struct {
+				Name   string `bson:"bookname"`
+				Author string `bson:"bookauthor"`
+			} `bson:"book"`
+		}
+		if err := cursor.All(ctx, &rows); err != nil {
+			return inventoryError(c, err)
+		}
+		ret := []LowStockResponse{}
+		for _, row := range rows {
+			ret = append(ret, LowStockResponse{BookID: row.BookID, Title: row.Book.Name, Author: row.Book.Author, Total: row.Total})
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/inventory/books/:bookId/receive", func(c echo.Context) error {
+		req, err := bindStock(c)
+		if err != nil {
+			return badRequest(c, err.Error())
+		}
+		if req.Quantity <= 0 {
+			return badRequest(c, "quantity must be positive")
+		}
+
+		bookID := c.Param("bookId")
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		if err := store.checkBook(ctx, bookID); err != nil {
+			return inventoryError(c, err)
+		}
+		if _, err := store.change(ctx, bookID, req.Location, req.Quantity, actor(c)); err != nil {
+			return inventoryError(c, err)
+		}
+		store.record(ctx, Movement{BookID: bookID, Location: req.Location, Delta: req.Quantity, Kind: MovementReceive, Reason: req.Reason, Actor: actor(c)})
+		return stockAfter(ctx, c, bookID)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/inventory/books/:bookId/adjust", func(c echo.Context) error {
+		req, err := bindStock(c)
+		if err != nil {
+			return badRequest(c, err.Error())
+		}
+		if req.Delta == 0 || req.Reason == "" {
+			return badRequest(c, "a non-zero delta and a reason are required")
+		}
+
+		bookID := c.Param("bookId")
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		if err := store.checkBook(ctx, bookID); err != nil {
+			return inventoryError(c, err)
+		}
+		if _, err := store.change(ctx, bookID, req.Location, req.Delta, actor(c)); err != nil {
+			return inventoryError(c, err)
+		}
+		store.record(ctx, Movement{BookID: bookID, Location: req.Location, Delta: req.Delta, Kind: MovementAdjust, Reason: req.Reason, Actor: actor(c)})
+		return stockAfter(ctx, c, bookID)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/inventory/books/:bookId/decrement", func(c echo.Context) error {
+		req, err := bindStock(c)
+		if err != nil {
+			return badRequest(c, err.Error())
+		}
+		if req.Quantity <= 0 {
+			return badRequest(c, "quantity must be positive")
+		}
+
+		bookID := c.Param("bookId")
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		if _, err := store.change(ctx, bookID, req.Location, -req.Quantity, actor(c)); err != nil {
+			return inventoryError(c, err)
+		}
+		store.record(ctx, Movement{BookID: bookID, Location: req.Location, Delta: -req.Quantity, Kind: MovementDecrement, Reason: req.Reason, Actor: actor(c)})
+		return stockAfter(ctx, c, bookID)
+	}, authorize(auth, keys, RoleEditor))
+
+	e.POST("/api/inventory/books/:bookId/transfer", func(c echo.Context) error {
+		var req TransferRequest
+		if err := c.Bind(&req); err != nil {
+			return badRequest(c, "Invalid request body")
+		}
+		from, to := normalizeLocation(req.From), normalizeLocation(req.To)
+		if req.Quantity <= 0 || from == to {
+			return badRequest(c, "a positive quantity and two different locations are required")
+		}
+
+		bookID := c.Param("bookId")
+		ctx, cancel := dbContext(c.Request().Context(), "transfer")
+		defer cancel()
+		if err := store.transfer(ctx, bookID, from, to, req.Quantity, strings.TrimSpace(req.Reason), actor(c)); err != nil {
+			return inventoryError(c, err)
+		}
+		return stockAfter(ctx, c, bookID)
+	}, authorize(auth, keys, RoleEditor))
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("Inventory service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
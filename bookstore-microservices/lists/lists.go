@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// List is a named, ordered selection of books kept by one user, such as a
+// wishlist or a reading list. Public lists can be read by anybody holding
+// the share token.
+type List struct {
+	MongoID     primitive.ObjectID `bson:"_id,omitempty"`
+	Owner       string             `bson:"owner"`
+	Name        string             `bson:"name"`
+	Description string             `bson:"description,omitempty"`
+	Visibility  string             `bson:"visibility"`
+	ShareToken  string             `bson:"sharetoken"`
+	Items       []ListItem         `bson:"items"`
+	Version     int64              `bson:"version"`
+	CreatedAt   time.Time          `bson:"createdat"`
+	UpdatedAt   time.Time          `bson:"updatedat"`
+}
+
+// ListItem refers to a book by ID. Title and author are copied when the
+// book is added, so the list can still say what it was after the book is
+// deleted from the catalog.
+type ListItem struct {
+	BookID  string    `bson:"bookid"`
+	Title   string    `bson:"title"`
+	Author  string    `bson:"author"`
+	Note    string    `bson:"note,omitempty"`
+	AddedAt time.Time `bson:"addedat"`
+}
+
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+const (
+	maxListName        = 100
+	maxListDescription = 1000
+	maxListItems       = 500
+	maxItemNote        = 1000
+	maxListsPerUser    = 100
+)
+
+var (
+	errUnknownList       = errors.New("list not found")
+	errUnknownBook       = errors.New("book not found")
+	errUnknownItem       = errors.New("the book is not on this list")
+	errDuplicateItem     = errors.New("the book is already on this list")
+	errDuplicateList     = errors.New("you already have a list with this name")
+	errListFull          = fmt.Errorf("a list can hold at most %d books", maxListItems)
+	errTooManyLists      = fmt.Errorf("you can keep at most %d lists", maxListsPerUser)
+	errListName          = fmt.Errorf("name is required and can be at most %d characters", maxListName)
+	errListDescription   = fmt.Errorf("description can be at most %d characters", maxListDescription)
+	errItemNote          = fmt.Errorf("note can be at most %d characters", maxItemNote)
+	errInvalidVisibility = errors.New("visibility must be private or public")
+	errConcurrentChange  = errors.New("the list was changed at the same time; try again")
+)
+
+// checkList validates the settings of a list and returns them trimmed. An
+// empty visibility keeps a list private.
+func checkList(name, description, visibility string) (string, string, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxListName {
+		return "", "", "", errListName
+	}
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxListDescription {
+		return "", "", "", errListDescription
+	}
+	switch visibility = strings.ToLower(strings.TrimSpace(visibility)); visibility {
+	case "":
+		visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityPublic:
+	default:
+		return "", "", "", errInvalidVisibility
+	}
+	return name, description, visibility, nil
+}
+
+func checkNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > maxItemNote {
+		return "", errItemNote
+	}
+	return note, nil
+}
+
+func indexOfItem(items []ListItem, bookID string) int {
+	for i, item := range items {
+		if item.BookID == bookID {
+			return i
+		}
+	}
+	return -1
+}
+
+// clampPosition turns a 0-based position into an index of a list of n
+// items; positions past the end mean the end.
+func clampPosition(position, n int) int {
+	if position < 0 {
+		return 0
+	}
+	if position > n {
+		return n
+	}
+	return position
+}
+
+// insertItem adds an item at position, or at the end when position is nil.
+func insertItem(items []ListItem, item ListItem, position *int) ([]ListItem, error) {
+	if indexOfItem(items, item.BookID) >= 0 {
+		return nil, errDuplicateItem
+	}
+	if len(items) >= maxListItems {
+		return nil, errListFull
+	}
+	at := len(items)
+	if position != nil {
+		at = clampPosition(*position, len(items))
+	}
+	ret := make([]ListItem, 0, len(items)+1)
+	ret = append(ret, items[:at]...)
+	ret = append(ret, item)
+	return append(ret, items[at:]...), nil
+}
+
+// updateItem changes the note of an item and moves it to position; nil
+// leaves either as it is.
+func updateItem(items []ListItem, bookID string, note *string, position *int) ([]ListItem, error) {
+	i := indexOfItem(items, bookID)
+	if i < 0 {
+		return nil, errUnknownItem
+	}
+	item := items[i]
+	if note != nil {
+		item.Note = *note
+	}
+	ret, _ := removeItem(items, bookID)
+	at := i
+	if position != nil {
+		at = clampPosition(*position, len(ret))
+	}
+	return insertItem(ret, item, &at)
+}
+
+func removeItem(items []ListItem, bookID string) ([]ListItem, error) {
+	i := indexOfItem(items, bookID)
+	if i < 0 {
+		return nil, errUnknownItem
+	}
+	ret := make([]ListItem, 0, len(items)-1)
+	ret = append(ret, items[:i]...)
+	return append(ret, items[i+1:]...), nil
+}
+
+func newShareToken() (string, error) {
+	raw := make([]byte, 18)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// CatalogBook is the current state of a book on a list.
+type CatalogBook struct {
+	ID     string `bson:"id"`
+	Title  string `bson:"bookname"`
+	Author string `bson:"bookauthor"`
+}
+
+type listStore struct {
+	lists *mongo.Collection
+	books *mongo.Collection
+}
+
+func (s *listStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.lists.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+		},
+		{
+			Keys:    bson.D{{Key: "sharetoken", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	return err
+}
+
+func (s *listStore) create(ctx context.Context, list *List) error {
+	count, err := s.lists.CountDocuments(ctx, bson.M{"owner": list.Owner})
+	if err != nil {
+		return err
+	}
+	if count >= maxListsPerUser {
+		return errTooManyLists
+	}
+	_, err = s.lists.InsertOne(ctx, list)
+	if mongo.IsDuplicateKeyError(err) {
+		return errDuplicateList
+	}
+	return err
+}
+
+// find loads a list of owner; lists of other users are reported as
+// missing.
+func (s *listStore) find(ctx context.Context, id primitive.ObjectID, owner string) (*List, error) {
+	return s.findOne(ctx, bson.M{"_id": id, "owner": owner})
+}
+
+// findShared loads a public list by its share token.
+func (s *listStore) findShared(ctx context.Context, token string) (*List, error) {
+	return s.findOne(ctx, bson.M{"sharetoken": token, "visibility": VisibilityPublic})
+}
+
+func (s *listStore) findOne(ctx context.Context, filter bson.M) (*List, error) {
+	var list List
+	err := s.lists.FindOne(ctx, filter).Decode(&list)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownList
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (s *listStore) listOwned(ctx context.Context, owner string) ([]List, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).
+		SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	cursor, err := s.lists.Find(ctx, bson.M{"owner": owner}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var lists []List
+	err = cursor.All(ctx, &lists)
+	return lists, err
+}
+
+// modify applies change to a list of owner and saves it. The save only
+// applies to the version that was read, so of two changes at once one is
+// tried again on top of the other.
+func (s *listStore) modify(ctx context.Context, id primitive.ObjectID, owner string, change func(*List) error) (*List, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		list, err := s.find(ctx, id, owner)
+		if err != nil {
+			return nil, err
+		}
+		version := list.Version
+		if err := change(list); err != nil {
+			return nil, err
+		}
+		list.Version++
+		list.UpdatedAt = time.Now().UTC()
+		result, err := s.lists.ReplaceOne(ctx, bson.M{"_id": id, "owner": owner, "version": version}, list)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errDuplicateList
+		}
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 1 {
+			return list, nil
+		}
+	}
+	return nil, errConcurrentChange
+}
+
+func (s *listStore) remove(ctx context.Context, id primitive.ObjectID, owner string) error {
+	result, err := s.lists.DeleteOne(ctx, bson.M{"_id": id, "owner": owner})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errUnknownList
+	}
+	return nil
+}
+
+// book looks up a book that can be added to a list.
+func (s *listStore) book(ctx context.Context, id string) (*CatalogBook, error) {
+	var book CatalogBook
+	err := s.books.FindOne(ctx, bson.M{"id": id, "deletedat": bson.M{"$exists": false}}).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownBook
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// catalog looks up the books on a list that are still in the catalog.
+func (s *listStore) catalog(ctx context.Context, items []ListItem) (map[string]CatalogBook, error) {
+	ret := map[string]CatalogBook{}
+	if len(items) == 0 {
+		return ret, nil
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.BookID
+	}
+	cursor, err := s.books.Find(ctx, bson.M{"id": bson.M{"$in": ids}, "deletedat": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"id": 1, "bookname": 1, "bookauthor": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var books []CatalogBook
+	if err := cursor.All(ctx, &books); err != nil {
+		return nil, err
+	}
+	for _, book := range books {
+		ret[book.ID] = book
+	}
+	return ret, nil
+}
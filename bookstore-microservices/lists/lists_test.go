@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func bookIDs(items []ListItem) []string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.BookID)
+	}
+	return ids
+}
+
+func items(ids ...string) []ListItem {
+	ret := []ListItem{}
+	for _, id := range ids {
+		ret = append(ret, ListItem{BookID: id})
+	}
+	return ret
+}
+
+func TestCheckList(t *testing.T) {
+	name, description, visibility, err := checkList("  To read ", " Summer ", "")
+	if err != nil || name != "To read" || description != "Summer" || visibility != VisibilityPrivate {
+		t.Errorf("checkList() = %q, %q, %q, %v", name, description, visibility, err)
+	}
+	if _, _, visibility, _ := checkList("Gifts", "", "Public"); visibility != VisibilityPublic {
+		t.Errorf("visibility = %q, want public", visibility)
+	}
+	for _, tt := range []struct {
+		name, description, visibility string
+		want                          error
+	}{
+		{"  ", "", "", errListName},
+		{strings.Repeat("x", maxListName+1), "", "", errListName},
+		{"Gifts", strings.Repeat("x", maxListDescription+1), "", errListDescription},
+		{"Gifts", "", "friends", errInvalidVisibility},
+	} {
+		if _, _, _, err := checkList(tt.name, tt.description, tt.visibility); err != tt.want {
+			t.Errorf("checkList(%.10q, %.10q, %q) = %v, want %v", tt.name, tt.description, tt.visibility, err, tt.want)
+		}
+	}
+}
+
+func TestInsertItem(t *testing.T) {
+	at := func(i int) *int { return &i }
+
+	tests := []struct {
+		position *int
+		want     []string
+	}{
+		{nil, []string{"a", "b", "c", "x"}},
+		{at(0), []string{"x", "a", "b", "c"}},
+		{at(1), []string{"a", "x", "b", "c"}},
+		{at(99), []string{"a", "b", "c", "x"}},
+		{at(-1), []string{"x", "a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		list := items("a", "b", "c")
+		got, err := insertItem(list, ListItem{BookID: "x"}, tt.position)
+		if err != nil || !reflect.DeepEqual(bookIDs(got), tt.want) {
+			t.Errorf("insertItem(%v) = %v, %v; want %v", tt.position, bookIDs(got), err, tt.want)
+		}
+		if !reflect.DeepEqual(bookIDs(list), []string{"a", "b", "c"}) {
+			t.Errorf("insertItem changed its input to %v", bookIDs(list))
+		}
+	}
+
+	if _, err := insertItem(items("a", "b"), ListItem{BookID: "b"}, nil); err != errDuplicateItem {
+		t.Errorf("adding a book twice gave %v", err)
+	}
+	full := make([]ListItem, maxListItems)
+	for i := range full {
+		full[i].BookID = strings.Repeat("b", i+1)
+	}
+	if _, err := insertItem(full, ListItem{BookID: "x"}, nil); err != errListFull {
+		t.Errorf("adding to a full list gave %v", err)
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	at := func(i int) *int { return &i }
+	note := "Signed copy"
+
+	got, err := updateItem(items("a", "b", "c"), "b", &note, nil)
+	if err != nil || !reflect.DeepEqual(bookIDs(got), []string{"a", "b", "c"}) || got[1].Note != note {
+		t.Errorf("updating the note gave %v %+v, %v", bookIDs(got), got, err)
+	}
+	got, err = updateItem(items("a", "b", "c"), "a", nil, at(2))
+	if err != nil || !reflect.DeepEqual(bookIDs(got), []string{"b", "c", "a"}) {
+		t.Errorf("moving to the end gave %v, %v", bookIDs(got), err)
+	}
+	got, err = updateItem(items("a", "b", "c"), "c", nil, at(0))
+	if err != nil || !reflect.DeepEqual(bookIDs(got), []string{"c", "a", "b"}) {
+		t.Errorf("moving to the start gave %v, %v", bookIDs(got), err)
+	}
+	if _, err := updateItem(items("a"), "z", &note, nil); err != errUnknownItem {
+		t.Errorf("updating a missing book gave %v", err)
+	}
+}
+
+func TestRemoveItem(t *testing.T) {
+	got, err := removeItem(items("a", "b", "c"), "b")
+	if err != nil || !reflect.DeepEqual(bookIDs(got), []string{"a", "c"}) {
+		t.Errorf("removeItem() = %v, %v", bookIDs(got), err)
+	}
+	if _, err := removeItem(items("a"), "z"); err != errUnknownItem {
+		t.Errorf("removing a missing book gave %v", err)
+	}
+}
+
+func TestWithItemsMarksDeletedBooks(t *testing.T) {
+	list := List{Items: []ListItem{
+		{BookID: "b1", Title: "Dune", Author: "Herbert"},
+		{BookID: "b2", Title: "Old title", Author: "Someone"},
+	}}
+	catalog := map[string]CatalogBook{"b1": {ID: "b1", Title: "Dune (2nd ed.)", Author: "Frank Herbert"}}
+
+	got := withItems(ListResponse{}, list, catalog).Items
+	if !got[0].Available || got[0].Title != "Dune (2nd ed.)" || got[0].Author != "Frank Herbert" {
+		t.Errorf("books in the catalog show as they are now: %+v", got[0])
+	}
+	if got[1].Available || got[1].Title != "Old title" {
+		t.Errorf("deleted books keep the title they were added with: %+v", got[1])
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ListRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+}
+
+type ItemRequest struct {
+	BookID   string  `json:"bookId"`
+	Note     *string `json:"note"`
+	Position *int    `json:"position"`
+}
+
+// ListItemResponse is a book on a list. Available is false once the book
+// has been deleted from the catalog; title and author are then the ones it
+// had when it was added.
+type ListItemResponse struct {
+	BookID    string    `json:"bookId"`
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	Note      string    `json:"note"`
+	AddedAt   time.Time `json:"addedAt"`
+	Available bool      `json:"available"`
+}
+
+type ListResponse struct {
+	ID          string             `json:"id"`
+	Owner       string             `json:"owner"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Visibility  string             `json:"visibility"`
+	ShareToken  string             `json:"shareToken,omitempty"`
+	ItemCount   int                `json:"itemCount"`
+	Items       []ListItemResponse `json:"items,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt"`
+}
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *listStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := client.Database("exercise-1")
+	store := &listStore{
+		lists: db.Collection("lists"),
+		books: db.Collection("information"),
+	}
+	if err := store.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, store, nil
+}
+
+// toListResponse sums a list up; the share token is only shown to the
+// owner of a public list.
+func toListResponse(list List, owner bool) ListResponse {
+	ret := ListResponse{
+		ID:          list.MongoID.Hex(),
+		Owner:       list.Owner,
+		Name:        list.Name,
+		Description: list.Description,
+		Visibility:  list.Visibility,
+		ItemCount:   len(list.Items),
+		CreatedAt:   list.CreatedAt,
+		UpdatedAt:   list.UpdatedAt,
+	}
+	if owner && list.Visibility == VisibilityPublic {
+		ret.ShareToken = list.ShareToken
+	}
+	return ret
+}
+
+// withItems adds the books of a list, as they are now in the catalog.
+func withItems(ret ListResponse, list List, catalog map[string]CatalogBook) ListResponse {
+	ret.Items = []ListItemResponse{}
+	for _, item := range list.Items {
+		line := ListItemResponse{
+			BookID:  item.BookID,
+			Title:   item.Title,
+			Author:  item.Author,
+			Note:    item.Note,
+			AddedAt: item.AddedAt,
+		}
+		if book, ok := catalog[item.BookID]; ok {
+			line.Title, line.Author, line.Available = book.Title, book.Author, true
+		}
+		ret.Items = append(ret.Items, line)
+	}
+	return ret
+}
+
+func listError(c echo.Context, err error) error {
+	if err == errUnknownList || err == errUnknownItem || err == errUnknownBook {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	switch err {
+	case errListName, errListDescription, errItemNote, errInvalidVisibility:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	case errDuplicateItem, errDuplicateList, errListFull, errTooManyLists, errConcurrentChange:
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var store *listStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, store, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		store = &listStore{}
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	keys := newKeyStore(collection(client, "apikeys"))
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	// Lists belong to the user who made them; nobody else, staff included,
+	// can see or change a private list.
+	owner := authorize(auth, keys, RoleReader)
+
+	listID := func(c echo.Context) (primitive.ObjectID, error) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return id, errUnknownList
+		}
+		return id, nil
+	}
+
+	// respond writes a list with its books.
+	respond := func(ctx context.Context, c echo.Context, status int, list *List, owned bool) error {
+		catalog, err := store.catalog(ctx, list.Items)
+		if err != nil {
+			return listError(c, err)
+		}
+		return c.JSON(status, withItems(toListResponse(*list, owned), *list, catalog))
+	}
+
+	e.GET("/api/lists", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		lists, err := store.listOwned(ctx, actor(c))
+		if err != nil {
+			return listError(c, err)
+		}
+		ret := []ListResponse{}
+		for _, list := range lists {
+			ret = append(ret, toListResponse(list, true))
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, owner)
+
+	e.POST("/api/lists", func(c echo.Context) error {
+		var req ListRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		name, description, visibility, err := checkList(req.Name, req.Description, req.Visibility)
+		if err != nil {
+			return listError(c, err)
+		}
+		token, err := newShareToken()
+		if err != nil {
+			return listError(c, err)
+		}
+
+		now := time.Now().UTC()
+		list := &List{
+			MongoID:     primitive.NewObjectID(),
+			Owner:       actor(c),
+			Name:        name,
+			Description: description,
+			Visibility:  visibility,
+			ShareToken:  token,
+			Items:       []ListItem{},
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		if err := store.create(ctx, list); err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusCreated, list, true)
+	}, owner)
+
+	// A public list as anybody with the share link sees it.
+	e.GET("/api/lists/shared/:token", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		list, err := store.findShared(ctx, c.Param("token"))
+		if err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusOK, list, false)
+	}, optionalAPIKey(keys))
+
+	e.GET("/api/lists/:id", func(c echo.Context) error {
+		id, err := listID(c)
+		if err != nil {
+			return listError(c, err)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		list, err := store.find(ctx, id, actor(c))
+		if err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusOK, list, true)
+	}, owner)
+
+	e.PUT("/api/lists/:id", func(c echo.Context) error {
+		var req ListRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		name, description, visibility, err := checkList(req.Name, req.Description, req.Visibility)
+		if err != nil {
+			return listError(c, err)
+		}
+		id, err := listID(c)
+		if err != nil {
+			return listError(c, err)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		list, err := store.modify(ctx, id, actor(c), func(list *List) error {
+			list.Name, list.Description, list.Visibility = name, description, visibility
+			return nil
+		})
+		if err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusOK, list, true)
+	}, owner)
+
+	e.DELETE("/api/lists/:id", func(c echo.Context) error {
+		id, err := listID(c)
+		if err != nil {
+			return listError(c, err)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		if err := store.remove(ctx, id, actor(c)); err != nil {
+			return listError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, owner)
+
+	// Adds a book at position (0-based), or at the end without one.
+	e.POST("/api/lists/:id/items", func(c echo.Context) error {
+		var req ItemRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		var note string
+		if req.Note != nil {
+			checked, err := checkNote(*req.Note)
+			if err != nil {
+				return listError(c, err)
+			}
+			note = checked
+		}
+		id, err := listID(c)
+		if err != nil {
+			return listError(c, err)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		book, err := store.book(ctx, strings.TrimSpace(req.BookID))
+		if err != nil {
+			return listError(c, err)
+		}
+		item := ListItem{BookID: book.ID, Title: book.Title, Author: book.Author, Note: note, AddedAt: time.Now().UTC()}
+		list, err := store.modify(ctx, id, actor(c), func(list *List) error {
+			items, err := insertItem(list.Items, item, req.Position)
+			list.Items = items
+			return err
+		})
+		if err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusCreated, list, true)
+	}, owner)
+
+	// Changes the note of a book on a list or moves it; either can be left
+	// out.
+	e.PUT("/api/lists/:id/items/:bookId", func(c echo.Context) error {
+		var req ItemRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		if req.Note != nil {
+			note, err := checkNote(*req.Note)
+			if err != nil {
+				return listError(c, err)
+			}
+			req.Note = &note
+		}
+		id, err := listID(c)
+		if err != nil {
+			return listError(c, err)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		list, err := store.modify(ctx, id, actor(c), func(list *List) error {
+			items, err := updateItem(list.Items, c.Param("bookId"), req.Note, req.Position)
+			list.Items = items
+			return err
+		})
+		if err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusOK, list, true)
+	}, owner)
+
+	// Books that were deleted from the catalog stay on a list until their
+	// owner removes them here.
+	e.DELETE("/api/lists/:id/items/:bookId", func(c echo.Context) error {
+		id, err := listID(c)
+		if err != nil {
+			return listError(c, err)
+		}
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		list, err := store.modify(ctx, id, actor(c), func(list *List) error {
+			items, err := removeItem(list.Items, c.Param("bookId"))
+			list.Items = items
+			return err
+		})
+		if err != nil {
+			return listError(c, err)
+		}
+		return respond(ctx, c, http.StatusOK, list, true)
+	}, owner)
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("Lists service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
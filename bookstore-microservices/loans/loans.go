@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Loan is one copy of a book checked out to a patron. A loan stays on
+// record after the return.
+type Loan struct {
+	MongoID      primitive.ObjectID `bson:"_id,omitempty"`
+	BookID       string             `bson:"bookid"`
+	Patron       string             `bson:"patron"`
+	CheckedOutAt time.Time          `bson:"checkedoutat"`
+	CheckedOutBy string             `bson:"checkedoutby"`
+	DueAt        time.Time          `bson:"dueat"`
+	Renewals     int                `bson:"renewals"`
+	ReturnedAt   *time.Time         `bson:"returnedat,omitempty"`
+	ReturnedBy   string             `bson:"returnedby,omitempty"`
+}
+
+// Hold is a place in the queue for a book whose copies are all out. When a
+// copy comes back it is kept for the first waiting patron until the hold
+// expires. Active is set on waiting and ready holds only, so a unique index
+// can allow one active hold per patron and book.
+type Hold struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	BookID    string             `bson:"bookid"`
+	Patron    string             `bson:"patron"`
+	Status    string             `bson:"status"`
+	Active    bool               `bson:"active,omitempty"`
+	PlacedAt  time.Time          `bson:"placedat"`
+	ReadyAt   *time.Time         `bson:"readyat,omitempty"`
+	ExpiresAt *time.Time         `bson:"expiresat,omitempty"`
+}
+
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// Circulation counts the copies of a book that are out on loan or kept for
+// a ready hold. Checkouts check and change it in one update, so a book is
+// never lent out more often than there are copies.
+type Circulation struct {
+	BookID string `bson:"_id"`
+	OnLoan int64  `bson:"onloan"`
+	Ready  int64  `bson:"ready"`
+}
+
+var (
+	errUnknownBook      = errors.New("book not found")
+	errUnknownLoan      = errors.New("loan not found")
+	errUnknownHold      = errors.New("hold not found")
+	errNoCopyAvailable  = errors.New("all copies are out; place a hold instead")
+	errCopyAvailable    = errors.New("a copy is available; check it out instead")
+	errAlreadyReturned  = errors.New("the loan has already been returned")
+	errRenewalLimit     = errors.New("the loan cannot be renewed any more")
+	errHoldsWaiting     = errors.New("other patrons are waiting for this book")
+	errDuplicateHold    = errors.New("the patron already holds this book")
+	errAlreadyBorrowing = errors.New("the patron already has this book on loan")
+)
+
+// policy holds the lending rules: LOAN_DAYS (21) for a loan and for each
+// renewal, MAX_RENEWALS (2) per loan, and HOLD_PICKUP_DAYS (7) for a ready
+// hold to be picked up.
+type policy struct {
+	loanDays    int
+	maxRenewals int
+	pickupDays  int
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+func loadPolicy() policy {
+	return policy{
+		loanDays:    envInt("LOAN_DAYS", 21),
+		maxRenewals: envInt("MAX_RENEWALS", 2),
+		pickupDays:  envInt("HOLD_PICKUP_DAYS", 7),
+	}
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// checkRenewal reports why a loan cannot be renewed, if it cannot. Loans
+// of books other patrons are waiting for go back at the due date.
+func (p policy) checkRenewal(loan Loan, waiting int64) error {
+	if loan.ReturnedAt != nil {
+		return errAlreadyReturned
+	}
+	if loan.Renewals >= p.maxRenewals {
+		return errRenewalLimit
+	}
+	if waiting > 0 {
+		return errHoldsWaiting
+	}
+	return nil
+}
+
+// available is the number of copies that can be checked out right away.
+func available(copies int64, c Circulation) int64 {
+	if n := copies - c.OnLoan - c.Ready; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// daysOverdue counts the started days since a loan was due.
+func daysOverdue(due, now time.Time) int {
+	if !now.After(due) {
+		return 0
+	}
+	return int((now.Sub(due) + days(1) - 1) / days(1))
+}
+
+type loanStore struct {
+	client      *mongo.Client
+	loans       *mongo.Collection
+	holds       *mongo.Collection
+	circulation *mongo.Collection
+	stock       *mongo.Collection
+	books       *mongo.Collection
+	policy      policy
+}
+
+func (s *loanStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.loans.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "bookid", Value: 1}, {Key: "returnedat", Value: 1}}},
+		{Keys: bson.D{{Key: "patron", Value: 1}, {Key: "checkedoutat", Value: -1}}},
+		{Keys: bson.D{{Key: "dueat", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.holds.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "bookid", Value: 1}, {Key: "status", Value: 1}, {Key: "placedat", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "bookid", Value: 1}, {Key: "patron", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"active": true}),
+		},
+	})
+	return err
+}
+
+// transaction runs fn in a transaction and returns its result.
+func (s *loanStore) transaction(ctx context.Context, fn func(sc mongo.SessionContext) (interface{}, error)) (interface{}, error) {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+	return session.WithTransaction(ctx, fn)
+}
+
+// copies is the number of copies of a book the inventory holds over all
+// locations.
+func (s *loanStore) copies(ctx context.Context, bookID string) (int64, error) {
+	totals, err := s.copyCounts(ctx, []string{bookID})
+	return totals[bookID], err
+}
+
+func (s *loanStore) copyCounts(ctx context.Context, bookIDs []string) (map[string]int64, error) {
+	cursor, err := s.stock.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookid": bson.M{"$in": bookIDs}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookid", "total": bson.M{"$sum": "$quantity"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		BookID string `bson:"_id"`
+		Total  int64  `bson:"total"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	totals := map[string]int64{}
+	for _, row := range rows {
+		totals[row.BookID] = row.Total
+	}
+	return totals, nil
+}
+
+func (s *loanStore) checkBook(ctx context.Context, bookID string) error {
+	count, err := s.books.CountDocuments(ctx, bson.M{"id": bookID, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownBook
+	}
+	return nil
+}
+
+func (s *loanStore) changeCirculation(ctx context.Context, bookID string, onLoan, ready int64) error {
+	_, err := s.circulation.UpdateOne(ctx, bson.M{"_id": bookID},
+		bson.M{"$inc": bson.M{"onloan": onLoan, "ready": ready}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// promoteNext makes the oldest waiting hold on a book ready for pickup.
+func (s *loanStore) promoteNext(ctx context.Context, bookID string, now time.Time) (bool, error) {
+	expires := now.Add(days(s.policy.pickupDays))
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "placedat", Value: 1}, {Key: "_id", Value: 1}})
+	err := s.holds.FindOneAndUpdate(ctx,
+		bson.M{"bookid": bookID, "status": HoldWaiting},
+		bson.M{"$set": bson.M{"status": HoldReady, "readyat": now, "expiresat": expires}},
+		opts).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.changeCirculation(ctx, bookID, 0, 1)
+}
+
+// freeCopies counts the copies of a book that are neither on loan nor
+// kept for a ready hold.
+func (s *loanStore) freeCopies(ctx context.Context, bookID string) (int64, error) {
+	copies, err := s.copies(ctx, bookID)
+	if err != nil {
+		return 0, err
+	}
+	var circ Circulation
+	err = s.circulation.FindOne(ctx, bson.M{"_id": bookID}).Decode(&circ)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	return available(copies, circ), nil
+}
+
+// promoteIfFree hands a copy that came back to the next waiting patron,
+// unless the stock went down meanwhile and the copy is not free after all.
+func (s *loanStore) promoteIfFree(ctx context.Context, bookID string, now time.Time) error {
+	free, err := s.freeCopies(ctx, bookID)
+	if err != nil || free == 0 {
+		return err
+	}
+	_, err = s.promoteNext(ctx, bookID, now)
+	return err
+}
+
+// refreshHolds expires ready holds that were not picked up in time and
+// hands free copies to waiting patrons, e.g. after the inventory received
+// more copies.
+func (s *loanStore) refreshHolds(ctx context.Context, bookID string, now time.Time) error {
+	_, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		result, err := s.holds.UpdateMany(sc,
+			bson.M{"bookid": bookID, "status": HoldReady, "expiresat": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"status": HoldExpired}, "$unset": bson.M{"active": ""}})
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount > 0 {
+			if err := s.changeCirculation(sc, bookID, 0, -result.ModifiedCount); err != nil {
+				return nil, err
+			}
+		}
+
+		free, err := s.freeCopies(sc, bookID)
+		if err != nil {
+			return nil, err
+		}
+		for ; free > 0; free-- {
+			promoted, err := s.promoteNext(sc, bookID, now)
+			if err != nil || !promoted {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// checkout lends a copy of a book to a patron. A patron whose hold is
+// ready takes the copy kept for them; anybody else needs a free copy.
+func (s *loanStore) checkout(ctx context.Context, bookID, patron, actor string, now time.Time) (*Loan, error) {
+	if err := s.checkBook(ctx, bookID); err != nil {
+		return nil, err
+	}
+	if err := s.refreshHolds(ctx, bookID, now); err != nil {
+		return nil, err
+	}
+
+	result, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		count, err := s.loans.CountDocuments(sc, bson.M{"bookid": bookID, "patron": patron, "returnedat": bson.M{"$exists": false}})
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errAlreadyBorrowing
+		}
+
+		held, err := s.holds.UpdateOne(sc,
+			bson.M{"bookid": bookID, "patron": patron, "status": HoldReady},
+			bson.M{"$set": bson.M{"status": HoldFulfilled}, "$unset": bson.M{"active": ""}})
+		if err != nil {
+			return nil, err
+		}
+		if held.ModifiedCount > 0 {
+			if err := s.changeCirculation(sc, bookID, 1, -1); err != nil {
+				return nil, err
+			}
+		} else {
+			copies, err := s.copies(sc, bookID)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.changeCirculation(sc, bookID, 0, 0); err != nil {
+				return nil, err
+			}
+			taken, err := s.circulation.UpdateOne(sc,
+				bson.M{"_id": bookID, "$expr": bson.M{"$lt": bson.A{bson.M{"$add": bson.A{"$onloan", "$ready"}}, copies}}},
+				bson.M{"$inc": bson.M{"onloan": 1}})
+			if err != nil {
+				return nil, err
+			}
+			if taken.ModifiedCount == 0 {
+				return nil, errNoCopyAvailable
+			}
+		}
+
+		loan := &Loan{
+			MongoID:      primitive.NewObjectID(),
+			BookID:       bookID,
+			Patron:       patron,
+			CheckedOutAt: now,
+			CheckedOutBy: actor,
+			DueAt:        now.Add(days(s.policy.loanDays)),
+		}
+		if _, err := s.loans.InsertOne(sc, loan); err != nil {
+			return nil, err
+		}
+		return loan, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Loan), nil
+}
+
+// returnLoan checks a copy back in. When patrons are waiting for the book
+// the copy is kept for the first of them.
+func (s *loanStore) returnLoan(ctx context.Context, id primitive.ObjectID, actor string, now time.Time) (*Loan, error) {
+	result, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var loan Loan
+		err := s.loans.FindOneAndUpdate(sc,
+			bson.M{"_id": id, "returnedat": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"returnedat": now, "returnedby": actor}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&loan)
+		if err == mongo.ErrNoDocuments {
+			return nil, s.missingLoan(sc, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.changeCirculation(sc, loan.BookID, -1, 0); err != nil {
+			return nil, err
+		}
+		if err := s.promoteIfFree(sc, loan.BookID, now); err != nil {
+			return nil, err
+		}
+		return &loan, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Loan), nil
+}
+
+// missingLoan tells a loan that does not exist from one already returned.
+func (s *loanStore) missingLoan(ctx context.Context, id primitive.ObjectID) error {
+	count, err := s.loans.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownLoan
+	}
+	return errAlreadyReturned
+}
+
+func (s *loanStore) findLoan(ctx context.Context, id primitive.ObjectID) (*Loan, error) {
+	var loan Loan
+	err := s.loans.FindOne(ctx, bson.M{"_id": id}).Decode(&loan)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownLoan
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+// renew extends a loan by another loan period from its due date. The
+// update only applies to the loan as it was checked, so two renewals at
+// once count twice or not at all.
+func (s *loanStore) renew(ctx context.Context, loan *Loan) (*Loan, error) {
+	waiting, err := s.holds.CountDocuments(ctx, bson.M{"bookid": loan.BookID, "status": HoldWaiting})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.policy.checkRenewal(*loan, waiting); err != nil {
+		return nil, err
+	}
+
+	var renewed Loan
+	err = s.loans.FindOneAndUpdate(ctx,
+		bson.M{"_id": loan.MongoID, "renewals": loan.Renewals, "returnedat": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"dueat": loan.DueAt.Add(days(s.policy.loanDays))}, "$inc": bson.M{"renewals": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&renewed)
+	if err == mongo.ErrNoDocuments {
+		return nil, errRenewalLimit
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &renewed, nil
+}
+
+// placeHold queues a patron for a book whose copies are all out.
+func (s *loanStore) placeHold(ctx context.Context, bookID, patron string, now time.Time) (*Hold, error) {
+	if err := s.checkBook(ctx, bookID); err != nil {
+		return nil, err
+	}
+	if err := s.refreshHolds(ctx, bookID, now); err != nil {
+		return nil, err
+	}
+	count, err := s.loans.CountDocuments(ctx, bson.M{"bookid": bookID, "patron": patron, "returnedat": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errAlreadyBorrowing
+	}
+	copies, err := s.copies(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	var circ Circulation
+	err = s.circulation.FindOne(ctx, bson.M{"_id": bookID}).Decode(&circ)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if available(copies, circ) > 0 {
+		return nil, errCopyAvailable
+	}
+
+	hold := &Hold{
+		MongoID:  primitive.NewObjectID(),
+		BookID:   bookID,
+		Patron:   patron,
+		Status:   HoldWaiting,
+		Active:   true,
+		PlacedAt: now,
+	}
+	if _, err := s.holds.InsertOne(ctx, hold); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errDuplicateHold
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+func (s *loanStore) findHold(ctx context.Context, id primitive.ObjectID) (*Hold, error) {
+	var hold Hold
+	err := s.holds.FindOne(ctx, bson.M{"_id": id}).Decode(&hold)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownHold
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// cancelHold takes a patron out of the queue. A copy kept for a ready hold
+// goes to the next patron.
+func (s *loanStore) cancelHold(ctx context.Context, id primitive.ObjectID, now time.Time) error {
+	_, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var hold Hold
+		err := s.holds.FindOneAndUpdate(sc,
+			bson.M{"_id": id, "active": true},
+			bson.M{"$set": bson.M{"status": HoldCancelled}, "$unset": bson.M{"active": ""}}).Decode(&hold)
+		if err == mongo.ErrNoDocuments {
+			return nil, errUnknownHold
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hold.Status != HoldReady {
+			return nil, nil
+		}
+		if err := s.changeCirculation(sc, hold.BookID, 0, -1); err != nil {
+			return nil, err
+		}
+		return nil, s.promoteIfFree(sc, hold.BookID, now)
+	})
+	return err
+}
+
+func (s *loanStore) findLoans(ctx context.Context, filter bson.M, sort bson.D, limit, offset int64) ([]Loan, error) {
+	opts := options.Find().SetSort(sort).SetLimit(limit).SetSkip(offset)
+	cursor, err := s.loans.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var loans []Loan
+	err = cursor.All(ctx, &loans)
+	return loans, err
+}
+
+func (s *loanStore) findHolds(ctx context.Context, filter bson.M) ([]Hold, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "placedat", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.holds.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var holds []Hold
+	err = cursor.All(ctx, &holds)
+	return holds, err
+}
+
+// Availability sums up the circulation of a book.
+type Availability struct {
+	BookID  string     `json:"bookId"`
+	Copies  int64      `json:"copies"`
+	OnLoan  int64      `json:"onLoan"`
+	OnHold  int64      `json:"onHold"`
+	Free    int64      `json:"available"`
+	Waiting int64      `json:"waiting"`
+	NextDue *time.Time `json:"nextDue"`
+}
+
+// availability reports the circulation of several books at once.
+func (s *loanStore) availability(ctx context.Context, bookIDs []string) ([]Availability, error) {
+	copies, err := s.copyCounts(ctx, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.circulation.Find(ctx, bson.M{"_id": bson.M{"$in": bookIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var circs []Circulation
+	if err := cursor.All(ctx, &circs); err != nil {
+		return nil, err
+	}
+	circulation := map[string]Circulation{}
+	for _, c := range circs {
+		circulation[c.BookID] = c
+	}
+
+	cursor, err = s.holds.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookid": bson.M{"$in": bookIDs}, "status": HoldWaiting}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookid", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var waitingRows []struct {
+		BookID string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &waitingRows); err != nil {
+		return nil, err
+	}
+	waiting := map[string]int64{}
+	for _, row := range waitingRows {
+		waiting[row.BookID] = row.Count
+	}
+
+	cursor, err = s.loans.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookid": bson.M{"$in": bookIDs}, "returnedat": bson.M{"$exists": false}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$bookid", "due": bson.M{"$min": "$dueat"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var dueRows []struct {
+		BookID string    `bson:"_id"`
+		Due    time.Time `bson:"due"`
+	}
+	if err := cursor.All(ctx, &dueRows); err != nil {
+		return nil, err
+	}
+	nextDue := map[string]time.Time{}
+	for _, row := range dueRows {
+		nextDue[row.BookID] = row.Due
+	}
+
+	ret := []Availability{}
+	for _, id := range bookIDs {
+		circ := circulation[id]
+		a := Availability{
+			BookID:  id,
+			Copies:  copies[id],
+			OnLoan:  circ.OnLoan,
+			OnHold:  circ.Ready,
+			Free:    available(copies[id], circ),
+			Waiting: waiting[id],
+		}
+		if due, ok := nextDue[id]; ok {
+			a.NextDue = &due
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
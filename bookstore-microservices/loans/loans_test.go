@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRenewal(t *testing.T) {
+	p := policy{loanDays: 21, maxRenewals: 2, pickupDays: 7}
+	returned := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		loan    Loan
+		waiting int64
+		want    error
+	}{
+		{"first renewal", Loan{}, 0, nil},
+		{"last renewal", Loan{Renewals: 1}, 0, nil},
+		{"limit reached", Loan{Renewals: 2}, 0, errRenewalLimit},
+		{"holds waiting", Loan{}, 1, errHoldsWaiting},
+		{"returned", Loan{ReturnedAt: &returned}, 0, errAlreadyReturned},
+	}
+	for _, tt := range tests {
+		if got := p.checkRenewal(tt.loan, tt.waiting); got != tt.want {
+			t.Errorf("%s: checkRenewal() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	tests := []struct {
+		copies int64
+		circ   Circulation
+		want   int64
+	}{
+		{3, Circulation{}, 3},
+		{3, Circulation{OnLoan: 2}, 1},
+		{3, Circulation{OnLoan: 2, Ready: 1}, 0},
+		// Copies taken out of the inventory while on loan.
+		{1, Circulation{OnLoan: 2}, 0},
+		{0, Circulation{}, 0},
+	}
+	for _, tt := range tests {
+		if got := available(tt.copies, tt.circ); got != tt.want {
+			t.Errorf("available(%d, %+v) = %d, want %d", tt.copies, tt.circ, got, tt.want)
+		}
+	}
+}
+
+func TestDaysOverdue(t *testing.T) {
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		now  time.Time
+		want int
+	}{
+		{due.Add(-time.Hour), 0},
+		{due, 0},
+		{due.Add(time.Minute), 1},
+		{due.Add(24 * time.Hour), 1},
+		{due.Add(24*time.Hour + time.Second), 2},
+		{due.Add(10 * 24 * time.Hour), 10},
+	}
+	for _, tt := range tests {
+		if got := daysOverdue(due, tt.now); got != tt.want {
+			t.Errorf("daysOverdue(%v) = %d, want %d", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	t.Setenv("LOAN_DAYS", "14")
+	t.Setenv("MAX_RENEWALS", "not a number")
+	t.Setenv("HOLD_PICKUP_DAYS", "-3")
+
+	got := loadPolicy()
+	want := policy{loanDays: 14, maxRenewals: 2, pickupDays: 7}
+	if got != want {
+		t.Errorf("loadPolicy() = %+v, want %+v", got, want)
+	}
+}
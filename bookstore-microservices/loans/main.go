@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoanResponse struct {
+	ID           string     `json:"id"`
+	BookID       string     `json:"bookId"`
+	Patron       string     `json:"patron"`
+	CheckedOutAt time.Time  `json:"checkedOutAt"`
+	CheckedOutBy string     `json:"checkedOutBy"`
+	DueAt        time.Time  `json:"dueAt"`
+	Renewals     int        `json:"renewals"`
+	ReturnedAt   *time.Time `json:"returnedAt"`
+	ReturnedBy   string     `json:"returnedBy,omitempty"`
+	DaysOverdue  int        `json:"daysOverdue"`
+}
+
+type HoldResponse struct {
+	ID        string     `json:"id"`
+	BookID    string     `json:"bookId"`
+	Patron    string     `json:"patron"`
+	Status    string     `json:"status"`
+	PlacedAt  time.Time  `json:"placedAt"`
+	ReadyAt   *time.Time `json:"readyAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// maxAvailabilityIDs bounds the books asked about in one availability
+// request; the book list asks for one page at a time.
+const maxAvailabilityIDs = 200
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *loanStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := client.Database("exercise-1")
+	store := &loanStore{
+		client:      client,
+		loans:       db.Collection("loans"),
+		holds:       db.Collection("holds"),
+		circulation: db.Collection("circulation"),
+		stock:       db.Collection("stock"),
+		books:       db.Collection("information"),
+		policy:      loadPolicy(),
+	}
+	if err := store.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, store, nil
+}
+
+// pagination reads limit and offset from the query string.
+func pagination(c echo.Context, defaultLimit, maxLimit int64) (int64, int64) {
+	limit, err := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// isStaff reports whether the caller works the desk: checks books out and
+// in, and sees the loans and holds of every patron.
+func isStaff(c echo.Context) bool {
+	p, ok := c.Get("principal").(*Principal)
+	return ok && p.HasRole(RoleEditor)
+}
+
+func toLoanResponse(loan Loan, now time.Time) LoanResponse {
+	ret := LoanResponse{
+		ID:           loan.MongoID.Hex(),
+		BookID:       loan.BookID,
+		Patron:       loan.Patron,
+		CheckedOutAt: loan.CheckedOutAt,
+		CheckedOutBy: loan.CheckedOutBy,
+		DueAt:        loan.DueAt,
+		Renewals:     loan.Renewals,
+		ReturnedAt:   loan.ReturnedAt,
+		ReturnedBy:   loan.ReturnedBy,
+	}
+	if loan.ReturnedAt == nil {
+		ret.DaysOverdue = daysOverdue(loan.DueAt, now)
+	}
+	return ret
+}
+
+func toHoldResponse(hold Hold) HoldResponse {
+	return HoldResponse{
+		ID:        hold.MongoID.Hex(),
+		BookID:    hold.BookID,
+		Patron:    hold.Patron,
+		Status:    hold.Status,
+		PlacedAt:  hold.PlacedAt,
+		ReadyAt:   hold.ReadyAt,
+		ExpiresAt: hold.ExpiresAt,
+	}
+}
+
+func loanError(c echo.Context, err error) error {
+	if err == errUnknownLoan || err == errUnknownHold || err == errUnknownBook {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	switch err {
+	case errNoCopyAvailable, errCopyAvailable, errAlreadyReturned, errRenewalLimit,
+		errHoldsWaiting, errDuplicateHold, errAlreadyBorrowing:
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var store *loanStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, store, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		store = &loanStore{}
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	keys := newKeyStore(collection(client, "apikeys"))
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	// Patrons look after their own loans and holds; staff after everyone's.
+	patron := authorize(auth, keys, RoleReader)
+	staff := authorize(auth, keys, RoleEditor)
+
+	e.GET("/api/loans/books/:bookId/availability", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		ret, err := store.availability(ctx, []string{c.Param("bookId")})
+		if err != nil {
+			return loanError(c, err)
+		}
+		return c.JSON(http.StatusOK, ret[0])
+	}, optionalAPIKey(keys))
+
+	// The availability of several books at once, e.g. ?ids=1,2,3 for a page
+	// of the book list.
+	e.GET("/api/loans/availability", func(c echo.Context) error {
+		ids := []string{}
+		for _, id := range strings.Split(c.QueryParam("ids"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > maxAvailabilityIDs {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("at most %d ids can be asked for at once", maxAvailabilityIDs),
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		ret, err := store.availability(ctx, ids)
+		if err != nil {
+			return loanError(c, err)
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, optionalAPIKey(keys))
+
+	e.POST("/api/loans", func(c echo.Context) error {
+		var req struct {
+			BookID string `json:"bookId"`
+			Patron string `json:"patron"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		req.BookID = strings.TrimSpace(req.BookID)
+		req.Patron = strings.TrimSpace(req.Patron)
+		if req.BookID == "" || req.Patron == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "bookId and patron are required",
+			})
+		}
+
+		now := time.Now().UTC()
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		loan, err := store.checkout(ctx, req.BookID, req.Patron, actor(c), now)
+		if err != nil {
+			return loanError(c, err)
+		}
+		return c.JSON(http.StatusCreated, toLoanResponse(*loan, now))
+	}, staff)
+
+	e.POST("/api/loans/:id/return", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return loanError(c, errUnknownLoan)
+		}
+
+		now := time.Now().UTC()
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		loan, err := store.returnLoan(ctx, id, actor(c), now)
+		if err != nil {
+			return loanError(c, err)
+		}
+		return c.JSON(http.StatusOK, toLoanResponse(*loan, now))
+	}, staff)
+
+	// Patrons can renew their own loans; loans of other patrons are reported
+	// as missing unless the caller is staff.
+	e.POST("/api/loans/:id/renew", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return loanError(c, errUnknownLoan)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		loan, err := store.findLoan(ctx, id)
+		if err != nil {
+			return loanError(c, err)
+		}
+		if loan.Patron != actor(c) && !isStaff(c) {
+			return loanError(c, errUnknownLoan)
+		}
+		loan, err = store.renew(ctx, loan)
+		if err != nil {
+			return loanError(c, err)
+		}
+		return c.JSON(http.StatusOK, toLoanResponse(*loan, time.Now().UTC()))
+	}, patron)
+
+	// The loans of the caller, newest first; current ones only unless
+	// ?all=true. Staff can look at another patron with ?patron= or at a
+	// book with ?bookId=.
+	e.GET("/api/loans", func(c echo.Context) error {
+		filter := bson.M{"patron": actor(c)}
+		if isStaff(c) {
+			if bookID := strings.TrimSpace(c.QueryParam("bookId")); bookID != "" {
+				filter = bson.M{"bookid": bookID}
+			}
+			if p := strings.TrimSpace(c.QueryParam("patron")); p != "" {
+				filter["patron"] = p
+			}
+		}
+		if c.QueryParam("all") != "true" {
+			filter["returnedat"] = bson.M{"$exists": false}
+		}
+		limit, offset := pagination(c, 50, 500)
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		loans, err := store.findLoans(ctx, filter, bson.D{{Key: "checkedoutat", Value: -1}}, limit, offset)
+		if err != nil {
+			return loanError(c, err)
+		}
+		now := time.Now().UTC()
+		ret := []LoanResponse{}
+		for _, loan := range loans {
+			ret = append(ret, toLoanResponse(loan, now))
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, patron)
+
+	// Loans past their due date, longest overdue first.
+	e.GET("/api/loans/overdue", func(c echo.Context) error {
+		now := time.Now().UTC()
+		filter := bson.M{"returnedat": bson.M{"$exists": false}, "dueat": bson.M{"$lt": now}}
+		limit, offset := pagination(c, 100, 1000)
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		loans, err := store.findLoans(ctx, filter, bson.D{{Key: "dueat", Value: 1}}, limit, offset)
+		if err != nil {
+			return loanError(c, err)
+		}
+		ret := []LoanResponse{}
+		for _, loan := range loans {
+			ret = append(ret, toLoanResponse(loan, now))
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, staff)
+
+	// The active holds of the caller. Staff see the queue of a book with
+	// ?bookId=, first in line first.
+	e.GET("/api/loans/holds", func(c echo.Context) error {
+		filter := bson.M{"patron": actor(c), "active": true}
+		if bookID := strings.TrimSpace(c.QueryParam("bookId")); bookID != "" && isStaff(c) {
+			filter = bson.M{"bookid": bookID, "active": true}
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		holds, err := store.findHolds(ctx, filter)
+		if err != nil {
+			return loanError(c, err)
+		}
+		ret := []HoldResponse{}
+		for _, hold := range holds {
+			ret = append(ret, toHoldResponse(hold))
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, patron)
+
+	// Patrons join the queue for a book themselves; staff can place a hold
+	// for another patron.
+	e.POST("/api/loans/holds", func(c echo.Context) error {
+		var req struct {
+			BookID string `json:"bookId"`
+			Patron string `json:"patron"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		req.BookID = strings.TrimSpace(req.BookID)
+		if req.BookID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "bookId is required",
+			})
+		}
+		who := actor(c)
+		if p := strings.TrimSpace(req.Patron); p != "" && isStaff(c) {
+			who = p
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		hold, err := store.placeHold(ctx, req.BookID, who, time.Now().UTC())
+		if err != nil {
+			return loanError(c, err)
+		}
+		return c.JSON(http.StatusCreated, toHoldResponse(*hold))
+	}, patron)
+
+	e.DELETE("/api/loans/holds/:id", func(c echo.Context) error {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return loanError(c, errUnknownHold)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		hold, err := store.findHold(ctx, id)
+		if err != nil {
+			return loanError(c, err)
+		}
+		if hold.Patron != actor(c) && !isStaff(c) {
+			return loanError(c, errUnknownHold)
+		}
+		if err := store.cancelHold(ctx, id, time.Now().UTC()); err != nil {
+			return loanError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, patron)
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("Loans service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
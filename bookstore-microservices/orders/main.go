@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CartItemResponse struct {
+	BookID   string `json:"bookId"`
+	Quantity int64  `json:"quantity"`
+	Digital  bool   `json:"digital"`
+}
+
+type CartResponse struct {
+	Items []CartItemResponse `json:"items"`
+}
+
+type ReservationResponse struct {
+	Location string `json:"location"`
+	Quantity int64  `json:"quantity"`
+}
+
+type OrderItemResponse struct {
+	BookID       string                `json:"bookId"`
+	Title        string                `json:"title"`
+	Quantity     int64                 `json:"quantity"`
+	UnitPrice    Money                 `json:"unitPrice"`
+	Digital      bool                  `json:"digital"`
+	Reservations []ReservationResponse `json:"reservations"`
+}
+
+type StatusChangeResponse struct {
+	Status    string    `json:"status"`
+	Actor     string    `json:"actor"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type OrderResponse struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"userId"`
+	Items     []OrderItemResponse    `json:"items"`
+	Total     Money                  `json:"total"`
+	Status    string                 `json:"status"`
+	History   []StatusChangeResponse `json:"history"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *orderStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := client.Database("exercise-1")
+	store := &orderStore{
+		client:    client,
+		carts:     db.Collection("carts"),
+		orders:    db.Collection("orders"),
+		books:     db.Collection("information"),
+		stock:     db.Collection("stock"),
+		movements: db.Collection("stockmovements"),
+	}
+	if err := store.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, store, nil
+}
+
+// pagination reads limit and offset from the query string.
+func pagination(c echo.Context, defaultLimit, maxLimit int64) (int64, int64) {
+	limit, err := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// isStaff reports whether the caller may see and manage the orders of
+// other users.
+func isStaff(c echo.Context) bool {
+	p, ok := c.Get("principal").(*Principal)
+	return ok && p.HasRole(RoleEditor)
+}
+
+func toCartResponse(cart Cart) CartResponse {
+	ret := CartResponse{Items: []CartItemResponse{}}
+	for _, item := range cart.Items {
+		ret.Items = append(ret.Items, CartItemResponse{BookID: item.BookID, Quantity: item.Quantity, Digital: item.Digital})
+	}
+	return ret
+}
+
+func toOrderResponse(order Order) OrderResponse {
+	ret := OrderResponse{
+		ID:        order.MongoID.Hex(),
+		UserID:    order.UserID,
+		Items:     []OrderItemResponse{},
+		Total:     order.Total,
+		Status:    order.Status,
+		History:   []StatusChangeResponse{},
+		CreatedAt: order.CreatedAt,
+		UpdatedAt: order.UpdatedAt,
+	}
+	for _, item := range order.Items {
+		line := OrderItemResponse{
+			BookID:       item.BookID,
+			Title:        item.Title,
+			Quantity:     item.Quantity,
+			UnitPrice:    item.UnitPrice,
+			Digital:      item.Digital,
+			Reservations: []ReservationResponse{},
+		}
+		for _, r := range item.Reservations {
+			line.Reservations = append(line.Reservations, ReservationResponse{Location: r.Location, Quantity: r.Quantity})
+		}
+		ret.Items = append(ret.Items, line)
+	}
+	for _, h := range order.History {
+		ret.History = append(ret.History, StatusChangeResponse{Status: h.Status, Actor: h.Actor, Timestamp: h.Timestamp})
+	}
+	return ret
+}
+
+func orderError(c echo.Context, err error) error {
+	if err == errUnknownOrder {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Order not found",
+		})
+	}
+	if err == errEmptyCart || errors.Is(err, errUnknownBook) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, errUnpriced) || errors.Is(err, errNoEbook) || errors.Is(err, errInsufficientStock) || err == errMixedCurrencies || err == errInvalidTransition {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var store *orderStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, store, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		store = &orderStore{}
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	keys := newKeyStore(collection(client, "apikeys"))
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	// Every caller shops with a cart of their own.
+	customer := authorize(auth, keys, RoleReader)
+
+	// loadOrder finds an order the caller may see. Orders of other users
+	// are reported as missing unless the caller is staff.
+	loadOrder := func(ctx context.Context, c echo.Context) (*Order, error) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return nil, errUnknownOrder
+		}
+		order, err := store.find(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if order.UserID != actor(c) && !isStaff(c) {
+			return nil, errUnknownOrder
+		}
+		return order, nil
+	}
+
+	e.GET("/api/orders/cart", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		cart, err := store.cart(ctx, actor(c))
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusOK, toCartResponse(cart))
+	}, customer)
+
+	e.PUT("/api/orders/cart/items/:bookId", func(c echo.Context) error {
+		var req struct {
+			Quantity int64 `json:"quantity"`
+			Digital  bool  `json:"digital"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		if req.Quantity < 0 || req.Quantity > maxLineQuantity {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("quantity must be between 0 and %d", maxLineQuantity),
+			})
+		}
+		if req.Digital && req.Quantity > 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "an e-book is bought once",
+			})
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		cart, err := store.setQuantity(ctx, actor(c), c.Param("bookId"), req.Digital, req.Quantity)
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusOK, toCartResponse(cart))
+	}, customer)
+
+	e.DELETE("/api/orders/cart/items/:bookId", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		cart, err := store.setQuantity(ctx, actor(c), c.Param("bookId"), c.QueryParam("digital") == "true", 0)
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusOK, toCartResponse(cart))
+	}, customer)
+
+	e.DELETE("/api/orders/cart", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		if err := store.clearCart(ctx, actor(c)); err != nil {
+			return orderError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, customer)
+
+	e.POST("/api/orders/checkout", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "checkout")
+		defer cancel()
+		order, err := store.checkout(ctx, actor(c))
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusCreated, toOrderResponse(*order))
+	}, customer)
+
+	// The order history of the caller, newest first. Staff can look at the
+	// orders of another user with ?user=.
+	e.GET("/api/orders", func(c echo.Context) error {
+		filter := bson.M{"userid": actor(c)}
+		if user := strings.TrimSpace(c.QueryParam("user")); user != "" && isStaff(c) {
+			filter["userid"] = user
+		}
+		if status := strings.TrimSpace(c.QueryParam("status")); status != "" {
+			filter["status"] = status
+		}
+		limit, offset := pagination(c, 50, 500)
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		orders, err := store.list(ctx, filter, limit, offset)
+		if err != nil {
+			return orderError(c, err)
+		}
+		ret := []OrderResponse{}
+		for _, order := range orders {
+			ret = append(ret, toOrderResponse(order))
+		}
+		return c.JSON(http.StatusOK, ret)
+	}, customer)
+
+	e.GET("/api/orders/:id", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		order, err := loadOrder(ctx, c)
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusOK, toOrderResponse(*order))
+	}, customer)
+
+	// Customers can cancel their own orders until they ship; the copies go
+	// back into stock.
+	e.POST("/api/orders/:id/cancel", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		order, err := loadOrder(ctx, c)
+		if err != nil {
+			return orderError(c, err)
+		}
+		order, err = store.setStatus(ctx, order.MongoID, StatusCancelled, actor(c))
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusOK, toOrderResponse(*order))
+	}, customer)
+
+	// Staff move orders through their lifecycle: pending, paid, shipped, or
+	// cancelled before shipping.
+	e.PUT("/api/orders/:id/status", func(c echo.Context) error {
+		var req struct {
+			Status string `json:"status"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		if len(sourcesOf(req.Status)) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("cannot move an order to status %q", req.Status),
+			})
+		}
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return orderError(c, errUnknownOrder)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		order, err := store.setStatus(ctx, id, req.Status, actor(c))
+		if err != nil {
+			return orderError(c, err)
+		}
+		return c.JSON(http.StatusOK, toOrderResponse(*order))
+	}, requireRole(auth, RoleEditor))
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("Orders service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cart holds the books a user is about to buy. There is one cart per user,
+// keyed by the subject of the caller.
+type Cart struct {
+	UserID    string     `bson:"_id"`
+	Items     []CartItem `bson:"items"`
+	UpdatedAt time.Time  `bson:"updatedat"`
+}
+
+// CartItem is a book in a cart. Digital items buy the e-books of the book
+// and are kept apart from printed copies of it.
+type CartItem struct {
+	BookID   string `bson:"bookid"`
+	Quantity int64  `bson:"quantity"`
+	Digital  bool   `bson:"digital,omitempty"`
+}
+
+// Order is a checked out cart. Prices are those in effect at checkout, and
+// Reservations record which stock locations each line was taken from so
+// cancelling can put the copies back.
+type Order struct {
+	MongoID   primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"userid"`
+	Items     []OrderItem        `bson:"items"`
+	Total     Money              `bson:"total"`
+	Status    string             `bson:"status"`
+	History   []StatusChange     `bson:"history"`
+	CreatedAt time.Time          `bson:"createdat"`
+	UpdatedAt time.Time          `bson:"updatedat"`
+}
+
+type OrderItem struct {
+	BookID       string        `bson:"bookid"`
+	Title        string        `bson:"title"`
+	Quantity     int64         `bson:"quantity"`
+	UnitPrice    Money         `bson:"unitprice"`
+	Digital      bool          `bson:"digital,omitempty"`
+	Reservations []Reservation `bson:"reservations"`
+}
+
+type Reservation struct {
+	Location string `bson:"location"`
+	Quantity int64  `bson:"quantity"`
+}
+
+type StatusChange struct {
+	Status    string    `bson:"status"`
+	Actor     string    `bson:"actor"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+const (
+	StatusPending   = "pending"
+	StatusPaid      = "paid"
+	StatusShipped   = "shipped"
+	StatusCancelled = "cancelled"
+)
+
+// transitions lists the statuses an order can move to from each status.
+// Shipped and cancelled orders are final.
+var transitions = map[string][]string{
+	StatusPending: {StatusPaid, StatusCancelled},
+	StatusPaid:    {StatusShipped, StatusCancelled},
+}
+
+// Stock movement kinds written by checkout and cancellation, next to those
+// of the inventory service.
+const (
+	MovementReserve = "reserve"
+	MovementRelease = "release"
+)
+
+// maxLineQuantity caps the copies of one book in a cart.
+const maxLineQuantity = 99
+
+var (
+	errEmptyCart         = errors.New("the cart is empty")
+	errUnknownBook       = errors.New("book not found")
+	errUnpriced          = errors.New("book has no price")
+	errNoEbook           = errors.New("book has no e-book")
+	errMixedCurrencies   = errors.New("all books of an order must be priced in the same currency")
+	errInsufficientStock = errors.New("not enough copies in stock")
+	errInvalidTransition = errors.New("invalid status change")
+	errUnknownOrder      = errors.New("order not found")
+)
+
+// canTransition reports whether an order may move from one status to
+// another.
+func canTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// sourcesOf lists the statuses an order can move to status from.
+func sourcesOf(status string) []string {
+	var from []string
+	for s := range transitions {
+		if canTransition(s, status) {
+			from = append(from, s)
+		}
+	}
+	return from
+}
+
+// setCartItem returns the items with the quantity of a book set, removing
+// the book at zero. Books keep the place they were first added at. The
+// printed copies and the e-book of a book are separate items.
+func setCartItem(items []CartItem, bookID string, digital bool, quantity int64) []CartItem {
+	ret := []CartItem{}
+	found := false
+	for _, item := range items {
+		if item.BookID == bookID && item.Digital == digital {
+			found = true
+			item.Quantity = quantity
+		}
+		if item.Quantity > 0 {
+			ret = append(ret, item)
+		}
+	}
+	if !found && quantity > 0 {
+		ret = append(ret, CartItem{BookID: bookID, Quantity: quantity, Digital: digital})
+	}
+	return ret
+}
+
+// StockLevel is the stock of a book at one location, as the inventory
+// service keeps it.
+type StockLevel struct {
+	Location string `bson:"location"`
+	Quantity int64  `bson:"quantity"`
+}
+
+// planReservation picks the locations to take quantity copies from,
+// emptying the best stocked locations first so an order is split over as
+// few of them as possible.
+func planReservation(levels []StockLevel, quantity int64) ([]Reservation, error) {
+	var plan []Reservation
+	for _, level := range levels {
+		if quantity == 0 {
+			break
+		}
+		take := level.Quantity
+		if take > quantity {
+			take = quantity
+		}
+		if take <= 0 {
+			continue
+		}
+		plan = append(plan, Reservation{Location: level.Location, Quantity: take})
+		quantity -= take
+	}
+	if quantity > 0 {
+		return nil, errInsufficientStock
+	}
+	return plan, nil
+}
+
+// bookRecord is the part of a catalog book an order needs.
+type bookRecord struct {
+	ID        string        `bson:"id"`
+	Title     string        `bson:"bookname"`
+	Price     *Money        `bson:"price"`
+	Discounts []Discount    `bson:"discounts"`
+	Ebooks    []ebookRecord `bson:"ebooks"`
+}
+
+// ebookRecord is an e-book attached to a catalog book.
+type ebookRecord struct {
+	Format string `bson:"format"`
+}
+
+// checkLine reports why a book cannot be bought as item, if it cannot:
+// every book needs a price, and digital items a book with an e-book.
+func checkLine(book bookRecord, item CartItem) error {
+	if book.Price == nil {
+		return fmt.Errorf("%w: %s", errUnpriced, item.BookID)
+	}
+	if item.Digital && len(book.Ebooks) == 0 {
+		return fmt.Errorf("%w: %s", errNoEbook, item.BookID)
+	}
+	return nil
+}
+
+type orderStore struct {
+	client    *mongo.Client
+	carts     *mongo.Collection
+	orders    *mongo.Collection
+	books     *mongo.Collection
+	stock     *mongo.Collection
+	movements *mongo.Collection
+}
+
+func (s *orderStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.orders.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userid", Value: 1}, {Key: "createdat", Value: -1}},
+	})
+	return err
+}
+
+func (s *orderStore) cart(ctx context.Context, userID string) (Cart, error) {
+	cart := Cart{UserID: userID, Items: []CartItem{}}
+	err := s.carts.FindOne(ctx, bson.M{"_id": userID}).Decode(&cart)
+	if err == mongo.ErrNoDocuments {
+		return cart, nil
+	}
+	return cart, err
+}
+
+// setQuantity sets the quantity of a book, or of its e-book, in the cart
+// of a user and returns the cart.
+func (s *orderStore) setQuantity(ctx context.Context, userID, bookID string, digital bool, quantity int64) (Cart, error) {
+	if quantity > 0 {
+		var book bookRecord
+		err := s.books.FindOne(ctx, bson.M{"id": bookID, "deletedat": bson.M{"$exists": false}},
+			options.FindOne().SetProjection(bson.M{"id": 1, "ebooks.format": 1})).Decode(&book)
+		if err == mongo.ErrNoDocuments {
+			return Cart{}, fmt.Errorf("%w: %s", errUnknownBook, bookID)
+		}
+		if err != nil {
+			return Cart{}, err
+		}
+		if digital && len(book.Ebooks) == 0 {
+			return Cart{}, fmt.Errorf("%w: %s", errNoEbook, bookID)
+		}
+	}
+	cart, err := s.cart(ctx, userID)
+	if err != nil {
+		return cart, err
+	}
+	cart.Items = setCartItem(cart.Items, bookID, digital, quantity)
+	cart.UpdatedAt = time.Now().UTC()
+	_, err = s.carts.ReplaceOne(ctx, bson.M{"_id": userID}, cart, options.Replace().SetUpsert(true))
+	return cart, err
+}
+
+func (s *orderStore) clearCart(ctx context.Context, userID string) error {
+	_, err := s.carts.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+// checkout turns the cart of a user into a pending order. The copies are
+// taken out of stock, the order stored and the cart emptied in one
+// transaction, so either all of it happens or none of it.
+func (s *orderStore) checkout(ctx context.Context, userID string) (*Order, error) {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// The cart is read inside the transaction: a checkout that lost a
+		// race is retried against the emptied cart instead of ordering the
+		// same books twice.
+		cart, err := s.cart(sc, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(cart.Items) == 0 {
+			return nil, errEmptyCart
+		}
+
+		now := time.Now().UTC()
+		order := &Order{
+			MongoID:   primitive.NewObjectID(),
+			UserID:    userID,
+			Status:    StatusPending,
+			History:   []StatusChange{{Status: StatusPending, Actor: userID, Timestamp: now}},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		for _, item := range cart.Items {
+			line, err := s.reserve(sc, order.MongoID.Hex(), item, userID, now)
+			if err != nil {
+				return nil, err
+			}
+			if order.Total.Currency == "" {
+				order.Total.Currency = line.UnitPrice.Currency
+			}
+			if line.UnitPrice.Currency != order.Total.Currency {
+				return nil, errMixedCurrencies
+			}
+			order.Total.Amount += line.UnitPrice.Amount * line.Quantity
+			order.Items = append(order.Items, *line)
+		}
+		if _, err := s.orders.InsertOne(sc, order); err != nil {
+			return nil, err
+		}
+		if _, err := s.carts.DeleteOne(sc, bson.M{"_id": userID}); err != nil {
+			return nil, err
+		}
+		return order, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Order), nil
+}
+
+// reserve prices one cart line and takes its copies out of stock. Each
+// decrement only applies while the copies are there, so concurrent
+// checkouts cannot oversell; a conflict aborts the transaction. E-books
+// are not stocked, so digital lines reserve nothing.
+func (s *orderStore) reserve(sc mongo.SessionContext, orderID string, item CartItem, userID string, now time.Time) (*OrderItem, error) {
+	var book bookRecord
+	err := s.books.FindOne(sc, bson.M{"id": item.BookID, "deletedat": bson.M{"$exists": false}}).Decode(&book)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w: %s", errUnknownBook, item.BookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLine(book, item); err != nil {
+		return nil, err
+	}
+	line := &OrderItem{
+		BookID:    item.BookID,
+		Title:     book.Title,
+		Quantity:  item.Quantity,
+		UnitPrice: effectivePrice(*book.Price, book.Discounts, now),
+		Digital:   item.Digital,
+	}
+	if item.Digital {
+		return line, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "quantity", Value: -1}, {Key: "location", Value: 1}})
+	cursor, err := s.stock.Find(sc, bson.M{"bookid": item.BookID, "quantity": bson.M{"$gt": 0}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var levels []StockLevel
+	if err := cursor.All(sc, &levels); err != nil {
+		return nil, err
+	}
+	plan, err := planReservation(levels, item.Quantity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, item.BookID)
+	}
+
+	for _, r := range plan {
+		result, err := s.stock.UpdateOne(sc,
+			bson.M{"bookid": item.BookID, "location": r.Location, "quantity": bson.M{"$gte": r.Quantity}},
+			bson.M{"$inc": bson.M{"quantity": -r.Quantity}, "$set": bson.M{"updatedby": userID, "updatedat": now}})
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount == 0 {
+			return nil, fmt.Errorf("%w: %s", errInsufficientStock, item.BookID)
+		}
+		if err := s.recordMovement(sc, item.BookID, r.Location, -r.Quantity, MovementReserve, orderID, userID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	line.Reservations = plan
+	return line, nil
+}
+
+func (s *orderStore) recordMovement(ctx context.Context, bookID, location string, delta int64, kind, reference, actor string, now time.Time) error {
+	_, err := s.movements.InsertOne(ctx, bson.M{
+		"bookid":    bookID,
+		"location":  location,
+		"delta":     delta,
+		"kind":      kind,
+		"reference": reference,
+		"actor":     actor,
+		"timestamp": now,
+	})
+	return err
+}
+
+// setStatus moves an order to a new status. The status is checked in the
+// update itself, so two concurrent changes cannot both apply. Cancelling
+// puts the reserved copies back into stock in the same transaction.
+func (s *orderStore) setStatus(ctx context.Context, id primitive.ObjectID, status, actor string) (*Order, error) {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now().UTC()
+		var order Order
+		err := s.orders.FindOneAndUpdate(sc,
+			bson.M{"_id": id, "status": bson.M{"$in": sourcesOf(status)}},
+			bson.M{
+				"$set":  bson.M{"status": status, "updatedat": now},
+				"$push": bson.M{"history": StatusChange{Status: status, Actor: actor, Timestamp: now}},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			count, countErr := s.orders.CountDocuments(sc, bson.M{"_id": id})
+			if countErr != nil {
+				return nil, countErr
+			}
+			if count == 0 {
+				return nil, errUnknownOrder
+			}
+			return nil, errInvalidTransition
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if status == StatusCancelled {
+			for _, item := range order.Items {
+				for _, r := range item.Reservations {
+					_, err := s.stock.UpdateOne(sc,
+						bson.M{"bookid": item.BookID, "location": r.Location},
+						bson.M{"$inc": bson.M{"quantity": r.Quantity}, "$set": bson.M{"updatedby": actor, "updatedat": now}},
+						options.Update().SetUpsert(true))
+					if err != nil {
+						return nil, err
+					}
+					if err := s.recordMovement(sc, item.BookID, r.Location, r.Quantity, MovementRelease, id.Hex(), actor, now); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		return &order, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Order), nil
+}
+
+func (s *orderStore) find(ctx context.Context, id primitive.ObjectID) (*Order, error) {
+	var order Order
+	err := s.orders.FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownOrder
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// list returns the orders matching filter, newest first.
+func (s *orderStore) list(ctx context.Context, filter bson.M, limit, offset int64) ([]Order, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit).
+		SetSkip(offset)
+	cursor, err := s.orders.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var orders []Order
+	err = cursor.All(ctx, &orders)
+	return orders, err
+}
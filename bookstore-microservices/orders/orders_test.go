@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	allowed := [][2]string{
+		{StatusPending, StatusPaid},
+		{StatusPending, StatusCancelled},
+		{StatusPaid, StatusShipped},
+		{StatusPaid, StatusCancelled},
+	}
+	for _, tc := range allowed {
+		if !canTransition(tc[0], tc[1]) {
+			t.Errorf("%s -> %s should be allowed", tc[0], tc[1])
+		}
+	}
+	refused := [][2]string{
+		{StatusPending, StatusShipped},
+		{StatusShipped, StatusCancelled},
+		{StatusCancelled, StatusPending},
+		{StatusPaid, StatusPending},
+	}
+	for _, tc := range refused {
+		if canTransition(tc[0], tc[1]) {
+			t.Errorf("%s -> %s should be refused", tc[0], tc[1])
+		}
+	}
+
+	from := sourcesOf(StatusCancelled)
+	sort.Strings(from)
+	if !reflect.DeepEqual(from, []string{StatusPaid, StatusPending}) {
+		t.Errorf("sourcesOf(cancelled) = %v", from)
+	}
+	if len(sourcesOf(StatusPending)) != 0 {
+		t.Error("nothing moves back to pending")
+	}
+}
+
+func TestSetCartItem(t *testing.T) {
+	items := setCartItem(nil, "b1", false, 2)
+	items = setCartItem(items, "b2", false, 1)
+	items = setCartItem(items, "b1", false, 3)
+	if !reflect.DeepEqual(items, []CartItem{{"b1", 3, false}, {"b2", 1, false}}) {
+		t.Errorf("items = %v", items)
+	}
+	items = setCartItem(items, "b1", false, 0)
+	if !reflect.DeepEqual(items, []CartItem{{"b2", 1, false}}) {
+		t.Errorf("removing a book left %v", items)
+	}
+	if items := setCartItem(nil, "b3", false, 0); len(items) != 0 {
+		t.Errorf("a zero quantity should not add a book, got %v", items)
+	}
+	items = setCartItem(items, "b2", true, 1)
+	if !reflect.DeepEqual(items, []CartItem{{"b2", 1, false}, {"b2", 1, true}}) {
+		t.Errorf("the e-book should be an item of its own, got %v", items)
+	}
+}
+
+func TestCheckLine(t *testing.T) {
+	price := &Money{Amount: 999, Currency: "EUR"}
+	ebookOnly := bookRecord{ID: "b1", Price: price, Ebooks: []ebookRecord{{Format: "epub"}}}
+	if err := checkLine(ebookOnly, CartItem{BookID: "b1", Quantity: 1, Digital: true}); err != nil {
+		t.Errorf("an e-book only title should be buyable as an e-book, got %v", err)
+	}
+	printed := bookRecord{ID: "b2", Price: price}
+	if err := checkLine(printed, CartItem{BookID: "b2", Quantity: 1, Digital: true}); !errors.Is(err, errNoEbook) {
+		t.Errorf("buying a missing e-book gave %v", err)
+	}
+	if err := checkLine(bookRecord{ID: "b3"}, CartItem{BookID: "b3", Quantity: 1}); !errors.Is(err, errUnpriced) {
+		t.Errorf("buying an unpriced book gave %v", err)
+	}
+}
+
+func TestPlanReservation(t *testing.T) {
+	levels := []StockLevel{{"main", 3}, {"back-room", 2}, {"annex", 0}}
+	plan, err := planReservation(levels, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plan, []Reservation{{"main", 3}, {"back-room", 1}}) {
+		t.Errorf("plan = %v", plan)
+	}
+	if _, err := planReservation(levels, 6); err != errInsufficientStock {
+		t.Errorf("reserving more than in stock gave %v", err)
+	}
+}
+
+func TestEffectivePrice(t *testing.T) {
+	now := time.Now()
+	past, promo := now.Add(-time.Hour), int64(700)
+	list := Money{Amount: 1000, Currency: "EUR"}
+	if got := effectivePrice(list, []Discount{{Percent: 25, EndsAt: &past}}, now); got != list {
+		t.Errorf("an expired discount still applies: %v", got)
+	}
+	got := effectivePrice(list, []Discount{{Percent: 25}, {Price: &promo}}, now)
+	if got != (Money{700, "EUR"}) {
+		t.Errorf("the lowest running price should apply, got %v", got)
+	}
+}
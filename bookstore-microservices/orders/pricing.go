@@ -0,0 +1,50 @@
+package main
+
+import "time"
+
+// Money is an amount in the minor units of an ISO 4217 currency.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+// Discount lowers the price of a book while it runs, by a percentage or to
+// a fixed promotional price, as the books services store it.
+type Discount struct {
+	Label    string     `bson:"label,omitempty"`
+	Percent  int64      `bson:"percent,omitempty"`
+	Price    *int64     `bson:"price,omitempty"`
+	StartsAt *time.Time `bson:"startsat,omitempty"`
+	EndsAt   *time.Time `bson:"endsat,omitempty"`
+}
+
+// active reports whether a discount runs at the given time.
+func (d Discount) active(now time.Time) bool {
+	if d.StartsAt != nil && now.Before(*d.StartsAt) {
+		return false
+	}
+	return d.EndsAt == nil || now.Before(*d.EndsAt)
+}
+
+// apply returns the amount after the discount, rounding halves up.
+func (d Discount) apply(amount int64) int64 {
+	if d.Price != nil {
+		return *d.Price
+	}
+	return (amount*(100-d.Percent) + 50) / 100
+}
+
+// effectivePrice is the price of a book at the given time: the lowest of
+// the list price and the prices after its running discounts.
+func effectivePrice(price Money, discounts []Discount, now time.Time) Money {
+	ret := price
+	for _, d := range discounts {
+		if !d.active(now) {
+			continue
+		}
+		if amount := d.apply(price.Amount); amount >= 0 && amount < ret.Amount {
+			ret.Amount = amount
+		}
+	}
+	return ret
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/time/rate"
+)
+
+// APIKey is a machine credential issued by the api-keys service. Only the
+// SHA-256 hash of the key is stored. Scopes limit the methods a key may use
+// and Role the routes, like the role of a bearer token; keys issued before
+// keys had roles are readers.
+type APIKey struct {
+	MongoID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	Hash       string             `bson:"hash"`
+	Scopes     []string           `bson:"scopes"`
+	Role       string             `bson:"role,omitempty"`
+	RateLimit  float64            `bson:"ratelimit"`
+	Burst      int                `bson:"burst"`
+	CreatedBy  string             `bson:"createdby,omitempty"`
+	CreatedAt  time.Time          `bson:"createdat"`
+	RotatedAt  time.Time          `bson:"rotatedat,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedat,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastusedat,omitempty"`
+}
+
+func (k *APIKey) HasScope(method string) bool {
+	for _, s := range k.Scopes {
+		if strings.EqualFold(s, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// role is the role the key acts with.
+func (k *APIKey) role() string {
+	if roleRank[k.Role] == 0 {
+		return RoleReader
+	}
+	return k.Role
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastUsedInterval limits how often the last-used timestamp is written.
+const lastUsedInterval = time.Minute
+
+// keyStore verifies API keys and enforces their per-key token-bucket rate
+// limits. Buckets live in memory, so each service instance limits
+// independently.
+type keyStore struct {
+	coll     *mongo.Collection
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	touched  map[string]time.Time
+}
+
+func newKeyStore(coll *mongo.Collection) *keyStore {
+	return &keyStore{
+		coll:     coll,
+		limiters: map[string]*rate.Limiter{},
+		touched:  map[string]time.Time{},
+	}
+}
+
+func (s *keyStore) lookup(ctx context.Context, key string) (*APIKey, error) {
+	var apiKey APIKey
+	err := s.coll.FindOne(ctx, bson.M{
+		"hash":      hashAPIKey(key),
+		"revokedat": bson.M{"$exists": false},
+	}).Decode(&apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// limiter returns the bucket for a key, replacing it when the key's limits
+// have been changed.
+func (s *keyStore) limiter(key *APIKey) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := key.MongoID.Hex()
+	limit := rate.Limit(key.RateLimit)
+	if key.RateLimit <= 0 {
+		limit = rate.Inf
+	}
+	burst := key.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(key.RateLimit)))
+	}
+
+	l, ok := s.limiters[id]
+	if !ok || l.Limit() != limit || l.Burst() != burst {
+		l = rate.NewLimiter(limit, burst)
+		s.limiters[id] = l
+	}
+	return l
+}
+
+// touch records the last-used time, at most once per lastUsedInterval.
+func (s *keyStore) touch(key *APIKey) {
+	now := time.Now().UTC()
+	s.mu.Lock()
+	id := key.MongoID.Hex()
+	if now.Sub(s.touched[id]) < lastUsedInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.touched[id] = now
+	s.mu.Unlock()
+
+	go func() {
+		ctx, cancel := dbContext(context.Background(), "touch")
+		defer cancel()
+		if _, err := s.coll.UpdateByID(ctx, key.MongoID, bson.M{"$set": bson.M{"lastusedat": now}}); err != nil {
+			fmt.Printf("Error updating API key last use: %v\n", err)
+		}
+	}()
+}
+
+// apiKeyFromRequest reads the API key from the X-API-Key header or the
+// Authorization header. Clients that only speak Basic auth, such as
+// e-reader apps, send the key as the password with any user name.
+func apiKeyFromRequest(c echo.Context) string {
+	if key := c.Request().Header.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "ApiKey ") {
+		return strings.TrimSpace(header[7:])
+	}
+	if _, key, ok := c.Request().BasicAuth(); ok {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// verify checks the key in the request against its scopes and rate limit.
+// It writes the error response itself and returns ok=false on failure.
+func (s *keyStore) verify(c echo.Context, key string) (*Principal, bool, error) {
+	ctx, cancel := dbContext(c.Request().Context(), "apikey")
+	defer cancel()
+
+	apiKey, err := s.lookup(ctx, key)
+	if err == mongo.ErrNoDocuments {
+		return nil, false, problem(c, http.StatusUnauthorized, "Invalid or revoked API key")
+	}
+	if err != nil {
+		if isTimeout(err) {
+			return nil, false, dbTimeoutProblem(c)
+		}
+		return nil, false, problem(c, http.StatusInternalServerError, "Failed to verify API key")
+	}
+
+	method := c.Request().Method
+	if !apiKey.HasScope(method) {
+		return nil, false, problem(c, http.StatusForbidden, fmt.Sprintf("API key is not allowed to use %s", method))
+	}
+
+	reservation := s.limiter(apiKey).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		retryAfter := int(math.Ceil(delay.Seconds()))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		return nil, false, problem(c, http.StatusTooManyRequests, "API key rate limit exceeded")
+	}
+
+	s.touch(apiKey)
+	return &Principal{Subject: "apikey:" + apiKey.MongoID.Hex(), Name: apiKey.Name, Roles: []string{apiKey.role()}}, true, nil
+}
+
+// authorize accepts either an API key whose scopes include the request
+// method or a bearer token, as long as either holds role.
+func authorize(auth *authConfig, keys *keyStore, role string) echo.MiddlewareFunc {
+	bearer := requireRole(auth, role)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withToken := bearer(next)
+		return func(c echo.Context) error {
+			key := apiKeyFromRequest(c)
+			if key == "" {
+				return withToken(c)
+			}
+			principal, ok, err := keys.verify(c, key)
+			if !ok {
+				return err
+			}
+			if !principal.HasRole(role) {
+				return problem(c, http.StatusForbidden, fmt.Sprintf("The %s role is required", role))
+			}
+			c.Set("principal", principal)
+			return next(c)
+		}
+	}
+}
+
+// optionalAPIKey verifies and rate-limits an API key when one is sent, and
+// lets anonymous requests through unchanged.
+func optionalAPIKey(keys *keyStore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := apiKeyFromRequest(c)
+			if key == "" {
+				return next(c)
+			}
+			principal, ok, err := keys.verify(c, key)
+			if !ok {
+				return err
+			}
+			c.Set("principal", principal)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReviewRequest struct {
+	Rating int    `json:"rating"`
+	Text   string `json:"text"`
+}
+
+type ReviewResponse struct {
+	ID          string     `json:"id"`
+	BookID      string     `json:"bookId"`
+	UserID      string     `json:"userId"`
+	Rating      int        `json:"rating"`
+	Text        string     `json:"text"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	ModeratedBy string     `json:"moderatedBy,omitempty"`
+	ModeratedAt *time.Time `json:"moderatedAt,omitempty"`
+	Note        string     `json:"note,omitempty"`
+}
+
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *reviewStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := client.Database("exercise-1")
+	store := &reviewStore{
+		client:  client,
+		reviews: db.Collection("reviews"),
+		books:   db.Collection("information"),
+	}
+	if err := store.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, store, nil
+}
+
+// pagination reads limit and offset from the query string.
+func pagination(c echo.Context, defaultLimit, maxLimit int64) (int64, int64) {
+	limit, err := strconv.ParseInt(c.QueryParam("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// isModerator reports whether the caller may moderate reviews and remove
+// the reviews of other users.
+func isModerator(c echo.Context) bool {
+	p, ok := c.Get("principal").(*Principal)
+	return ok && p.HasRole(RoleEditor)
+}
+
+func toReviewResponse(review Review) ReviewResponse {
+	return ReviewResponse{
+		ID:          review.MongoID.Hex(),
+		BookID:      review.BookID,
+		UserID:      review.UserID,
+		Rating:      review.Rating,
+		Text:        review.Text,
+		Status:      review.Status,
+		CreatedAt:   review.CreatedAt,
+		UpdatedAt:   review.UpdatedAt,
+		ModeratedBy: review.ModeratedBy,
+		ModeratedAt: review.ModeratedAt,
+		Note:        review.Note,
+	}
+}
+
+func toReviewResponses(reviews []Review) []ReviewResponse {
+	ret := []ReviewResponse{}
+	for _, review := range reviews {
+		ret = append(ret, toReviewResponse(review))
+	}
+	return ret
+}
+
+func reviewError(c echo.Context, err error) error {
+	if err == errUnknownReview || err == errUnknownBook {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err == errInvalidRating || err == errReviewTooLong || err == errInvalidModeration {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err == errDuplicateReview {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if isTimeout(err) {
+		return dbTimeoutProblem(c)
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+func main() {
+	fmt.Println("Waiting for MongoDB to be ready...")
+
+	var client *mongo.Client
+	var store *reviewStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, store, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		store = &reviewStore{}
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
+	keys := newKeyStore(collection(client, "apikeys"))
+
+	auth, err := loadAuthConfig()
+	if err != nil {
+		log.Fatalf("Failed to load authentication config: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Logger())
+	e.Use(middleware.CORS())
+
+	// Any signed-in user can review; editors moderate.
+	reviewer := authorize(auth, keys, RoleReader)
+	moderator := authorize(auth, keys, RoleEditor)
+
+	// loadReview finds a review the caller may change. Reviews of other
+	// users are reported as missing unless the caller moderates.
+	loadReview := func(ctx context.Context, c echo.Context) (*Review, error) {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return nil, errUnknownReview
+		}
+		review, err := store.find(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if review.UserID != actor(c) && !isModerator(c) {
+			return nil, errUnknownReview
+		}
+		return review, nil
+	}
+
+	// The approved reviews of a book, newest first.
+	e.GET("/api/reviews/books/:bookId", func(c echo.Context) error {
+		filter := bson.M{"bookid": c.Param("bookId"), "status": StatusApproved}
+		limit, offset := pagination(c, 50, 500)
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		reviews, err := store.list(ctx, filter, bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}, limit, offset)
+		if err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusOK, toReviewResponses(reviews))
+	}, optionalAPIKey(keys))
+
+	// Each user can review a book once; later changes go through PUT.
+	e.POST("/api/reviews/books/:bookId", func(c echo.Context) error {
+		var req ReviewRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		text, err := checkReview(req.Rating, req.Text)
+		if err != nil {
+			return reviewError(c, err)
+		}
+
+		now := time.Now().UTC()
+		review := &Review{
+			MongoID:   primitive.NewObjectID(),
+			BookID:    c.Param("bookId"),
+			UserID:    actor(c),
+			Rating:    req.Rating,
+			Text:      text,
+			Status:    StatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "create")
+		defer cancel()
+		if err := store.create(ctx, review); err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusCreated, toReviewResponse(*review))
+	}, reviewer)
+
+	// The reviews of the caller in every state, newest first.
+	e.GET("/api/reviews/mine", func(c echo.Context) error {
+		limit, offset := pagination(c, 50, 500)
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		reviews, err := store.list(ctx, bson.M{"userid": actor(c)}, bson.D{{Key: "createdat", Value: -1}}, limit, offset)
+		if err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusOK, toReviewResponses(reviews))
+	}, reviewer)
+
+	// The moderation queue: pending reviews, oldest first. ?status= shows
+	// approved or rejected ones instead and ?bookId= narrows to one book.
+	e.GET("/api/reviews", func(c echo.Context) error {
+		status := c.QueryParam("status")
+		if status == "" {
+			status = StatusPending
+		}
+		if status != StatusPending && status != StatusApproved && status != StatusRejected {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "status must be pending, approved or rejected",
+			})
+		}
+		filter := bson.M{"status": status}
+		if bookID := strings.TrimSpace(c.QueryParam("bookId")); bookID != "" {
+			filter["bookid"] = bookID
+		}
+		limit, offset := pagination(c, 50, 500)
+
+		ctx, cancel := dbContext(c.Request().Context(), "list")
+		defer cancel()
+		reviews, err := store.list(ctx, filter, bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}, limit, offset)
+		if err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusOK, toReviewResponses(reviews))
+	}, moderator)
+
+	e.GET("/api/reviews/:id", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "get")
+		defer cancel()
+		review, err := loadReview(ctx, c)
+		if err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusOK, toReviewResponse(*review))
+	}, reviewer)
+
+	// Authors edit their own reviews, which go back to moderation.
+	e.PUT("/api/reviews/:id", func(c echo.Context) error {
+		var req ReviewRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		text, err := checkReview(req.Rating, req.Text)
+		if err != nil {
+			return reviewError(c, err)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		review, err := loadReview(ctx, c)
+		if err != nil {
+			return reviewError(c, err)
+		}
+		if review.UserID != actor(c) {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": "only the author can edit a review",
+			})
+		}
+		review, err = store.edit(ctx, review.MongoID, req.Rating, text, time.Now().UTC())
+		if err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusOK, toReviewResponse(*review))
+	}, reviewer)
+
+	e.PUT("/api/reviews/:id/status", func(c echo.Context) error {
+		var req struct {
+			Status string `json:"status"`
+			Note   string `json:"note"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return reviewError(c, errUnknownReview)
+		}
+
+		ctx, cancel := dbContext(c.Request().Context(), "update")
+		defer cancel()
+		review, err := store.moderate(ctx, id, req.Status, req.Note, actor(c), time.Now().UTC())
+		if err != nil {
+			return reviewError(c, err)
+		}
+		return c.JSON(http.StatusOK, toReviewResponse(*review))
+	}, moderator)
+
+	e.DELETE("/api/reviews/:id", func(c echo.Context) error {
+		ctx, cancel := dbContext(c.Request().Context(), "delete")
+		defer cancel()
+		review, err := loadReview(ctx, c)
+		if err != nil {
+			return reviewError(c, err)
+		}
+		if err := store.remove(ctx, review.MongoID); err != nil {
+			return reviewError(c, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, reviewer)
+
+	// Health check endpoint
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "healthy"})
+	})
+
+	fmt.Println("Reviews service starting on port 8080")
+	e.Logger.Fatal(e.Start(":8080"))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Review is what one user thinks of one book. New and edited reviews wait
+// for moderation; only approved ones are shown and counted in the rating.
+type Review struct {
+	MongoID     primitive.ObjectID `bson:"_id,omitempty"`
+	BookID      string             `bson:"bookid"`
+	UserID      string             `bson:"userid"`
+	Rating      int                `bson:"rating"`
+	Text        string             `bson:"text"`
+	Status      string             `bson:"status"`
+	CreatedAt   time.Time          `bson:"createdat"`
+	UpdatedAt   time.Time          `bson:"updatedat"`
+	ModeratedBy string             `bson:"moderatedby,omitempty"`
+	ModeratedAt *time.Time         `bson:"moderatedat,omitempty"`
+	Note        string             `bson:"note,omitempty"`
+}
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Rating is the summary of the approved reviews of a book, kept on the
+// book itself so the catalog can show and sort by it without a join.
+type Rating struct {
+	Average float64 `bson:"average"`
+	Count   int64   `bson:"count"`
+}
+
+const (
+	minRating     = 1
+	maxRating     = 5
+	maxReviewText = 5000
+)
+
+var (
+	errUnknownBook       = errors.New("book not found")
+	errUnknownReview     = errors.New("review not found")
+	errDuplicateReview   = errors.New("you have already reviewed this book")
+	errInvalidRating     = fmt.Errorf("rating must be a whole number from %d to %d", minRating, maxRating)
+	errReviewTooLong     = fmt.Errorf("review text can be at most %d characters", maxReviewText)
+	errInvalidModeration = errors.New("status must be approved or rejected")
+)
+
+// checkReview validates a review and returns its text trimmed.
+func checkReview(rating int, text string) (string, error) {
+	if rating < minRating || rating > maxRating {
+		return "", errInvalidRating
+	}
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > maxReviewText {
+		return "", errReviewTooLong
+	}
+	return text, nil
+}
+
+// roundRating keeps two decimals of an average, which is as much as
+// anybody reads off a star rating.
+func roundRating(average float64) float64 {
+	return math.Round(average*100) / 100
+}
+
+type reviewStore struct {
+	client  *mongo.Client
+	reviews *mongo.Collection
+	books   *mongo.Collection
+}
+
+func (s *reviewStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.reviews.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "bookid", Value: 1}, {Key: "userid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "bookid", Value: 1}, {Key: "status", Value: 1}, {Key: "createdat", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdat", Value: 1}}},
+		{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "createdat", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+	// books-get lists the catalog by rating with ?sort=rating.
+	_, err = s.books.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "rating.average", Value: -1}, {Key: "rating.count", Value: -1}, {Key: "id", Value: 1}},
+	})
+	return err
+}
+
+// transaction runs fn in a transaction, so that a review and the rating of
+// its book change together.
+func (s *reviewStore) transaction(ctx context.Context, fn func(sc mongo.SessionContext) (interface{}, error)) (interface{}, error) {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+	return session.WithTransaction(ctx, fn)
+}
+
+func (s *reviewStore) checkBook(ctx context.Context, bookID string) error {
+	count, err := s.books.CountDocuments(ctx, bson.M{"id": bookID, "deletedat": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownBook
+	}
+	return nil
+}
+
+// refreshRating recomputes the rating of a book from its approved reviews.
+// A book without approved reviews has no rating.
+func (s *reviewStore) refreshRating(ctx context.Context, bookID string) error {
+	cursor, err := s.reviews.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookid": bookID, "status": StatusApproved}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "average": bson.M{"$avg": "$rating"}, "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var rows []Rating
+	if err := cursor.All(ctx, &rows); err != nil {
+		return err
+	}
+
+	update := bson.M{"$unset": bson.M{"rating": ""}}
+	if len(rows) > 0 && rows[0].Count > 0 {
+		rating := Rating{Average: roundRating(rows[0].Average), Count: rows[0].Count}
+		update = bson.M{"$set": bson.M{"rating": rating}}
+	}
+	_, err = s.books.UpdateMany(ctx, bson.M{"id": bookID}, update)
+	return err
+}
+
+func (s *reviewStore) create(ctx context.Context, review *Review) error {
+	if err := s.checkBook(ctx, review.BookID); err != nil {
+		return err
+	}
+	_, err := s.reviews.InsertOne(ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return errDuplicateReview
+	}
+	return err
+}
+
+func (s *reviewStore) find(ctx context.Context, id primitive.ObjectID) (*Review, error) {
+	var review Review
+	err := s.reviews.FindOne(ctx, bson.M{"_id": id}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownReview
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// edit replaces the rating and text of a review, which sends it back to
+// moderation. An approved review stops counting until it is approved
+// again.
+func (s *reviewStore) edit(ctx context.Context, id primitive.ObjectID, rating int, text string, now time.Time) (*Review, error) {
+	result, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var review Review
+		err := s.reviews.FindOneAndUpdate(sc, bson.M{"_id": id},
+			bson.M{
+				"$set":   bson.M{"rating": rating, "text": text, "status": StatusPending, "updatedat": now},
+				"$unset": bson.M{"moderatedby": "", "moderatedat": "", "note": ""},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&review)
+		if err == mongo.ErrNoDocuments {
+			return nil, errUnknownReview
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &review, s.refreshRating(sc, review.BookID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Review), nil
+}
+
+// moderate approves or rejects a review. Approved reviews can still be
+// taken down and rejected ones approved on a second look.
+func (s *reviewStore) moderate(ctx context.Context, id primitive.ObjectID, status, note, actor string, now time.Time) (*Review, error) {
+	if status != StatusApproved && status != StatusRejected {
+		return nil, errInvalidModeration
+	}
+	result, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		set := bson.M{"status": status, "moderatedby": actor, "moderatedat": now}
+		update := bson.M{"$set": set}
+		if note = strings.TrimSpace(note); note != "" {
+			set["note"] = note
+		} else {
+			update["$unset"] = bson.M{"note": ""}
+		}
+		var review Review
+		err := s.reviews.FindOneAndUpdate(sc, bson.M{"_id": id}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&review)
+		if err == mongo.ErrNoDocuments {
+			return nil, errUnknownReview
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &review, s.refreshRating(sc, review.BookID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Review), nil
+}
+
+func (s *reviewStore) remove(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var review Review
+		err := s.reviews.FindOneAndDelete(sc, bson.M{"_id": id}).Decode(&review)
+		if err == mongo.ErrNoDocuments {
+			return nil, errUnknownReview
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.refreshRating(sc, review.BookID)
+	})
+	return err
+}
+
+func (s *reviewStore) list(ctx context.Context, filter bson.M, sort bson.D, limit, offset int64) ([]Review, error) {
+	opts := options.Find().SetSort(sort).SetLimit(limit).SetSkip(offset)
+	cursor, err := s.reviews.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var reviews []Review
+	err = cursor.All(ctx, &reviews)
+	return reviews, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckReview(t *testing.T) {
+	tests := []struct {
+		rating int
+		text   string
+		want   string
+		err    error
+	}{
+		{5, "  Loved it.\n", "Loved it.", nil},
+		{1, "", "", nil},
+		{0, "Meh", "", errInvalidRating},
+		{6, "Best ever", "", errInvalidRating},
+		{3, strings.Repeat("é", maxReviewText), strings.Repeat("é", maxReviewText), nil},
+		{3, strings.Repeat("a", maxReviewText+1), "", errReviewTooLong},
+	}
+	for _, tt := range tests {
+		got, err := checkReview(tt.rating, tt.text)
+		if err != tt.err || got != tt.want {
+			t.Errorf("checkReview(%d, %.10q) = %.10q, %v; want %.10q, %v", tt.rating, tt.text, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestRoundRating(t *testing.T) {
+	tests := map[float64]float64{
+		4:            4,
+		4.333333333:  4.33,
+		3.6666666667: 3.67,
+	}
+	for in, want := range tests {
+		if got := roundRating(in); got != want {
+			t.Errorf("roundRating(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// BookRequest is the body the write services accept.
+type BookRequest struct {
+	ID             string   `json:"id,omitempty"`
+	Title          string   `json:"title"`
+	Author         string   `json:"author"`
+	Pages          string   `json:"pages,omitempty"`
+	Edition        string   `json:"edition,omitempty"`
+	Year           string   `json:"year,omitempty"`
+	PublisherID    string   `json:"publisherId"`
+	Series         string   `json:"series"`
+	SeriesPosition *float64 `json:"seriesPosition,omitempty"`
+	Format         string   `json:"format"`
+	Language       string   `json:"language"`
+	// Without a price the book keeps the one it has.
+	Price *Money `json:"price,omitempty"`
+}
+
+// apiError carries the status and message a backend service answered with.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func serviceURL(env, fallback string) string {
+	if url := os.Getenv(env); url != "" {
+		return url
+	}
+	return fallback
+}
+
+// serviceToken mints a short-lived HS256 token carrying the logged-in
+// user's roles, signed with the JWT_HS256_SECRET the write services share.
+func serviceToken(user *SessionUser) (string, error) {
+	secret := os.Getenv("JWT_HS256_SECRET")
+	if secret == "" || secret == "change-me" {
+		return "", fmt.Errorf("JWT_HS256_SECRET is not configured")
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":   user.Username,
+		"name":  user.Username,
+		"roles": user.Roles,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		claims["iss"] = issuer
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		claims["aud"] = audience
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// callBooksAPI sends an authenticated request to one of the backend
+// services on behalf of user, decoding a successful response into out when
+// it is not nil.
+func callBooksAPI(method, endpoint string, user *SessionUser, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, err := serviceToken(user)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	var failure struct {
+		Error  string `json:"error"`
+		Detail string `json:"detail"`
+	}
+	json.NewDecoder(resp.Body).Decode(&failure)
+	msg := failure.Error
+	if msg == "" {
+		msg = failure.Detail
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return &apiError{Status: resp.StatusCode, Message: msg}
+}
+
+func createBookViaAPI(user *SessionUser, book BookRequest) error {
+	base := serviceURL("BOOKS_POST_URL", "http://books-post:8080")
+	return callBooksAPI(http.MethodPost, base+"/api/books", user, book, nil)
+}
+
+func updateBookViaAPI(user *SessionUser, id string, book BookRequest) error {
+	base := serviceURL("BOOKS_PUT_URL", "http://books-put:8080")
+	return callBooksAPI(http.MethodPut, base+"/api/books/"+url.PathEscape(id), user, book, nil)
+}
+
+func deleteBookViaAPI(user *SessionUser, id string) error {
+	base := serviceURL("BOOKS_DELETE_URL", "http://books-delete:8080")
+	return callBooksAPI(http.MethodDelete, base+"/api/books/"+url.PathEscape(id), user, nil, nil)
+}
+
+type AuditChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditEntry struct {
+	ID        string        `json:"id"`
+	BookID    string        `json:"bookId"`
+	Action    string        `json:"action"`
+	Actor     string        `json:"actor"`
+	RequestID string        `json:"requestId"`
+	Timestamp time.Time     `json:"timestamp"`
+	Changes   []AuditChange `json:"changes"`
+}
+
+// getAuditFromAPI fetches audit entries from books-get, passing the
+// filters through unchanged.
+func getAuditFromAPI(user *SessionUser, filters url.Values) ([]AuditEntry, error) {
+	base := serviceURL("BOOKS_GET_URL", "http://books-get:8080")
+	var entries []AuditEntry
+	err := callBooksAPI(http.MethodGet, base+"/api/audit?"+filters.Encode(), user, nil, &entries)
+	return entries, err
+}
+
+// LowStock is a book with few copies left over all locations.
+type LowStock struct {
+	BookID string `json:"bookId"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Total  int64  `json:"total"`
+}
+
+// getLowStockFromAPI fetches the low-stock report from the inventory
+// service. An empty threshold leaves the service default.
+func getLowStockFromAPI(user *SessionUser, threshold string) ([]LowStock, error) {
+	base := serviceURL("INVENTORY_URL", "http://inventory:8080")
+	query := url.Values{}
+	if threshold != "" {
+		query.Set("threshold", threshold)
+	}
+	var rows []LowStock
+	err := callBooksAPI(http.MethodGet, base+"/api/inventory/low-stock?"+query.Encode(), user, nil, &rows)
+	return rows, err
+}
+
+type BookRevision struct {
+	Revision  int64       `json:"revision"`
+	Action    string      `json:"action"`
+	Actor     string      `json:"actor"`
+	Timestamp time.Time   `json:"timestamp"`
+	Deleted   bool        `json:"deleted"`
+	Book      BookRequest `json:"book"`
+}
+
+func getRevisionsFromAPI(user *SessionUser, id string) ([]BookRevision, error) {
+	base := serviceURL("BOOKS_GET_URL", "http://books-get:8080")
+	var revisions []BookRevision
+	err := callBooksAPI(http.MethodGet, base+"/api/books/"+url.PathEscape(id)+"/revisions", user, nil, &revisions)
+	return revisions, err
+}
+
+func revertBookViaAPI(user *SessionUser, id string, revision int64) error {
+	base := serviceURL("BOOKS_PUT_URL", "http://books-put:8080")
+	body := map[string]int64{"revision": revision}
+	return callBooksAPI(http.MethodPut, base+"/api/books/"+url.PathEscape(id)+"/revert", user, body, nil)
+}
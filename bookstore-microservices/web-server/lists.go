@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserList is a wishlist or reading list of the lists service.
+type UserList struct {
+	ID          string         `json:"id"`
+	Owner       string         `json:"owner"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Visibility  string         `json:"visibility"`
+	ShareToken  string         `json:"shareToken"`
+	ItemCount   int            `json:"itemCount"`
+	Items       []UserListItem `json:"items"`
+}
+
+// UserListItem is a book on a list. Books deleted from the catalog are no
+// longer Available and cannot be linked to.
+type UserListItem struct {
+	BookID    string `json:"bookId"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Note      string `json:"note"`
+	Available bool   `json:"available"`
+}
+
+// ListRequest creates a list or changes its settings.
+type ListRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+}
+
+func listsURL() string {
+	return serviceURL("LISTS_URL", "http://lists:8080")
+}
+
+func getListsFromAPI(user *SessionUser) ([]UserList, error) {
+	var lists []UserList
+	err := callBooksAPI(http.MethodGet, listsURL()+"/api/lists", user, nil, &lists)
+	return lists, err
+}
+
+func getListFromAPI(user *SessionUser, id string) (*UserList, error) {
+	var list UserList
+	if err := callBooksAPI(http.MethodGet, listsURL()+"/api/lists/"+url.PathEscape(id), user, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// getSharedListFromAPI loads a public list by its share token. It returns
+// nil when there is no such public list.
+func getSharedListFromAPI(token string) (*UserList, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(listsURL() + "/api/lists/shared/" + url.PathEscape(token))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lists service answered %s", resp.Status)
+	}
+	var list UserList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func createListViaAPI(user *SessionUser, req ListRequest) error {
+	return callBooksAPI(http.MethodPost, listsURL()+"/api/lists", user, req, nil)
+}
+
+func updateListViaAPI(user *SessionUser, id string, req ListRequest) error {
+	return callBooksAPI(http.MethodPut, listsURL()+"/api/lists/"+url.PathEscape(id), user, req, nil)
+}
+
+func deleteListViaAPI(user *SessionUser, id string) error {
+	return callBooksAPI(http.MethodDelete, listsURL()+"/api/lists/"+url.PathEscape(id), user, nil, nil)
+}
+
+func addToListViaAPI(user *SessionUser, id, bookID, note string) error {
+	body := map[string]string{"bookId": bookID, "note": note}
+	return callBooksAPI(http.MethodPost, listsURL()+"/api/lists/"+url.PathEscape(id)+"/items", user, body, nil)
+}
+
+func moveListItemViaAPI(user *SessionUser, id, bookID string, position int) error {
+	body := map[string]int{"position": position}
+	return callBooksAPI(http.MethodPut, listsURL()+"/api/lists/"+url.PathEscape(id)+"/items/"+url.PathEscape(bookID), user, body, nil)
+}
+
+func removeListItemViaAPI(user *SessionUser, id, bookID string) error {
+	return callBooksAPI(http.MethodDelete, listsURL()+"/api/lists/"+url.PathEscape(id)+"/items/"+url.PathEscape(bookID), user, nil, nil)
+}
+
+// movePosition is where a book goes when it moves one place up or down a
+// list. It reports false when the book cannot move that way.
+func movePosition(items []UserListItem, bookID, direction string) (int, bool) {
+	for i, item := range items {
+		if item.BookID != bookID {
+			continue
+		}
+		switch {
+		case direction == "up" && i > 0:
+			return i - 1, true
+		case direction == "down" && i < len(items)-1:
+			return i + 1, true
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// renderLists shows the lists of the signed-in user with the form for a
+// new one.
+func renderLists(c echo.Context, status int, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	lists, err := getListsFromAPI(currentUser(c))
+	if err != nil {
+		log.Printf("Error fetching lists: %v", err)
+		data["Error"] = "Could not load your lists"
+	}
+	data["Lists"] = lists
+	return renderPage(c, status, "lists-page", data)
+}
+
+// renderList shows one list of the signed-in user, with message as the
+// error of the last change if there was one.
+func renderList(c echo.Context, id, message string) error {
+	list, err := getListFromAPI(currentUser(c), id)
+	if err != nil {
+		log.Printf("Error fetching list: %v", err)
+		return c.String(http.StatusNotFound, "List not found")
+	}
+	status := http.StatusOK
+	if message != "" {
+		status = http.StatusUnprocessableEntity
+	}
+	return renderPage(c, status, "list-detail", map[string]interface{}{"List": list, "Error": message})
+}
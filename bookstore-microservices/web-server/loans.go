@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Availability is the circulation of a book as the loans service reports
+// it.
+type Availability struct {
+	BookID    string     `json:"bookId"`
+	Copies    int64      `json:"copies"`
+	Available int64      `json:"available"`
+	Waiting   int64      `json:"waiting"`
+	NextDue   *time.Time `json:"nextDue"`
+}
+
+// Status sums the availability up for the book table, e.g. "2 of 3
+// available" or "Due back 2 Mar 2024".
+func (a Availability) Status() string {
+	switch {
+	case a.Copies == 0:
+		return "Not in stock"
+	case a.Available > 0:
+		return fmt.Sprintf("%d of %d available", a.Available, a.Copies)
+	}
+	status := "All copies out"
+	if a.NextDue != nil {
+		status = "Due back " + a.NextDue.Format("2 Jan 2006")
+	}
+	if a.Waiting > 0 {
+		status += fmt.Sprintf(" (%d waiting)", a.Waiting)
+	}
+	return status
+}
+
+// availabilityBatch is the most books the loans service answers for in
+// one request.
+const availabilityBatch = 200
+
+// loansEnabled reports whether the loans service is configured. Without it
+// the book table leaves out the availability column.
+func loansEnabled() bool {
+	return os.Getenv("LOANS_URL") != ""
+}
+
+// getAvailabilityFromAPI asks the loans service about several books at once.
+func getAvailabilityFromAPI(ids []string) (map[string]Availability, error) {
+	endpoint := os.Getenv("LOANS_URL") + "/api/loans/availability?" + url.Values{"ids": {strings.Join(ids, ",")}}.Encode()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("loans service returned %s", resp.Status)
+	}
+
+	var rows []Availability
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, err
+	}
+	ret := map[string]Availability{}
+	for _, row := range rows {
+		ret[row.BookID] = row
+	}
+	return ret, nil
+}
+
+// attachAvailability fills in the availability of the books. A loans
+// service that cannot be reached leaves the column empty rather than
+// failing the page.
+func attachAvailability(books []BookStore) error {
+	if !loansEnabled() || len(books) == 0 {
+		return nil
+	}
+	for start := 0; start < len(books); start += availabilityBatch {
+		batch := books[start:min(start+availabilityBatch, len(books))]
+		ids := make([]string, len(batch))
+		for i, book := range batch {
+			ids[i] = book.ID
+		}
+		availability, err := getAvailabilityFromAPI(ids)
+		if err != nil {
+			return err
+		}
+		for i := range batch {
+			if a, ok := availability[batch[i].ID]; ok {
+				batch[i].Availability = &a
+			}
+		}
+	}
+	return nil
+}
@@ -1,34 +1,112 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type BookStore struct {
-	ID          string `json:"ID"`
-	BookName    string `json:"BookName"`
-	BookAuthor  string `json:"BookAuthor"`
-	BookEdition string `json:"BookEdition"`
-	BookPages   string `json:"BookPages"`
+	ID             string        `json:"ID"`
+	BookName       string        `json:"BookName"`
+	BookAuthor     string        `json:"BookAuthor"`
+	BookEdition    string        `json:"BookEdition"`
+	BookPages      string        `json:"BookPages"`
+	BookYear       string        `json:"BookYear"`
+	PublisherID    string        `json:"PublisherID"`
+	Series         string        `json:"Series"`
+	SeriesPosition *float64      `json:"SeriesPosition"`
+	WorkID         string        `json:"WorkID"`
+	Format         string        `json:"Format"`
+	Language       string        `json:"Language"`
+	Price          *Money        `json:"Price"`
+	EffectivePrice *Money        `json:"EffectivePrice"`
+	Discount       *Discount     `json:"Discount"`
+	Availability   *Availability `json:"Availability"`
+	Rating         Rating        `json:"Rating"`
+	CoverURL       string        `json:"CoverURL"`
+}
+
+// Thumbnail is the URL of the small cover of the book, or "" without one.
+func (b BookStore) Thumbnail() string {
+	if b.CoverURL == "" {
+		return ""
+	}
+	return b.CoverURL + "&size=small"
+}
+
+// OnSale reports whether a discount makes the book cheaper right now.
+func (b BookStore) OnSale() bool {
+	return b.Price != nil && b.EffectivePrice != nil && b.EffectivePrice.Amount < b.Price.Amount
 }
 
 type BookResponse struct {
-	ID      string `json:"id"`
-	Title   string `json:"title"`
-	Author  string `json:"author"`
-	Pages   string `json:"pages"`
-	Edition string `json:"edition"`
-	Year    string `json:"year"`
+	ID             string    `json:"id"`
+	Title          string    `json:"title"`
+	Author         string    `json:"author"`
+	Pages          string    `json:"pages"`
+	Edition        string    `json:"edition"`
+	Year           string    `json:"year"`
+	PublisherID    string    `json:"publisherId"`
+	Series         string    `json:"series"`
+	SeriesPosition *float64  `json:"seriesPosition"`
+	WorkID         string    `json:"workId"`
+	Format         string    `json:"format"`
+	Language       string    `json:"language"`
+	Price          *Money    `json:"price"`
+	EffectivePrice *Money    `json:"effectivePrice"`
+	Discount       *Discount `json:"discount"`
+	Rating         Rating    `json:"rating"`
+	CoverURL       string    `json:"coverUrl"`
+}
+
+// SeriesInfo is the series of a book in reading order, with the books
+// before and after it.
+type SeriesInfo struct {
+	Series   string         `json:"series"`
+	Books    []BookResponse `json:"books"`
+	Previous *BookResponse  `json:"previous"`
+	Next     *BookResponse  `json:"next"`
+}
+
+// Recommendation is a book related to another one, as books-get ranks
+// them for the "You might also like" strip.
+type Recommendation struct {
+	Book    BookResponse `json:"book"`
+	Score   int          `json:"score"`
+	Reasons []string     `json:"reasons"`
+}
+
+// Edition is a book as listed among the editions of its work.
+type Edition struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	ISBN     string `json:"isbn"`
+	Format   string `json:"format"`
+	Language string `json:"language"`
+	Year     string `json:"year"`
+}
+
+type Publisher struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Website string `json:"website"`
+	Books   int64  `json:"books"`
 }
 
 type Template struct {
@@ -36,8 +114,9 @@ type Template struct {
 }
 
 func loadTemplates() *Template {
+	funcs := template.FuncMap{"pathEscape": url.PathEscape}
 	return &Template{
-		tmpl: template.Must(template.ParseGlob("views/*.html")),
+		tmpl: template.Must(template.New("").Funcs(funcs).ParseGlob("views/*.html")),
 	}
 }
 
@@ -45,69 +124,288 @@ func (t *Template) Render(w io.Writer, name string, data interface{}, ctx echo.C
 	return t.tmpl.ExecuteTemplate(w, name, data)
 }
 
-func getBooksFromAPI() ([]BookStore, error) {
+func getMongoURI() string {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://mongo:27017"
+	}
+	return uri
+}
+
+func connectToMongoDB() (*mongo.Client, *accountStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := client.Database("exercise-1")
+	accounts := &accountStore{
+		users:    db.Collection("users"),
+		sessions: db.Collection("sessions"),
+	}
+	if err := accounts.ensureIndexes(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := accounts.bootstrapAdmin(ctx); err != nil {
+		return nil, nil, err
+	}
+	return client, accounts, nil
+}
+
+// auditActions are the actions offered by the audit log filter.
+var auditActions = []string{"create", "update", "patch", "delete", "restore", "revert"}
+
+// page adds the logged-in user and CSRF token every template needs to data.
+func page(c echo.Context, data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	user := currentUser(c)
+	data["User"] = user
+	data["CanEdit"] = user.HasRole(RoleEditor)
+	data["IsAdmin"] = user.HasRole(RoleAdmin)
+	data["CSRF"], _ = c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+	return data
+}
+
+// renderHistory shows the revision history of a book, with message as the
+// error above it if there is one.
+func renderHistory(c echo.Context, id, message string) error {
+	data := map[string]interface{}{"BookID": id, "Error": message}
+	revisions, err := getRevisionsFromAPI(currentUser(c), id)
+	if err != nil {
+		log.Printf("Error fetching revisions: %v", err)
+		data["Error"] = "Could not load the revision history"
+	}
+	data["Revisions"] = revisions
+	return c.Render(200, "history-table", page(c, data))
+}
+
+// renderPage renders a page fragment for HTMX requests. Direct loads, such
+// as following a deep link, get the whole page with the fragment already
+// in place.
+func renderPage(c echo.Context, status int, name string, data map[string]interface{}) error {
+	data = page(c, data)
+	if c.Request().Header.Get("HX-Request") == "true" {
+		return c.Render(status, name, data)
+	}
+	var content bytes.Buffer
+	if err := c.Echo().Renderer.Render(&content, name, data, c); err != nil {
+		return err
+	}
+	data["Content"] = template.HTML(content.String())
+	return c.Render(status, "index", data)
+}
+
+// renderBookList shows the books matching query under a heading, for the
+// author and year detail pages.
+func renderBookList(c echo.Context, heading string, query url.Values) error {
+	data := map[string]interface{}{"Heading": heading}
+	books, err := getFilteredBooksFromAPI(query)
+	if err != nil {
+		log.Printf("Error fetching books: %v", err)
+		data["Error"] = "Could not load the books"
+		books = []BookStore{}
+	}
+	if err := attachAvailability(books); err != nil {
+		log.Printf("Error fetching availability: %v", err)
+	}
+	data["Books"] = books
+	data["ShowAvailability"] = loansEnabled()
+	return renderPage(c, http.StatusOK, "book-list", data)
+}
+
+// renderBookTable shows the catalog, sorted by rating when the table asks
+// for ?sort=rating.
+func renderBookTable(c echo.Context, status int) error {
+	var query url.Values
+	byRating := c.QueryParam("sort") == "rating"
+	if byRating {
+		query = url.Values{"sort": {"rating"}}
+	}
+	books, err := getFilteredBooksFromAPI(query)
+	if err != nil {
+		log.Printf("Error fetching books: %v", err)
+		books = []BookStore{}
+	}
+	if err := attachAvailability(books); err != nil {
+		log.Printf("Error fetching availability: %v", err)
+	}
+	return c.Render(status, "book-table", page(c, map[string]interface{}{
+		"Books":            books,
+		"ShowAvailability": loansEnabled(),
+		"SortByRating":     byRating,
+	}))
+}
+
+// findBook fetches a single book from books-get. It returns nil when
+// there is no such book.
+func findBook(id string) (*BookStore, error) {
 	booksGetURL := os.Getenv("BOOKS_GET_URL")
 	if booksGetURL == "" {
 		booksGetURL = "http://books-get:8080"
 	}
 
 	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(booksGetURL + "/api/books")
+	resp, err := client.Get(booksGetURL + "/api/books/" + url.PathEscape(id))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("books-get answered %s", resp.Status)
+	}
 
-	var books []BookResponse
-	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+	var book BookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&book); err != nil {
 		return nil, err
 	}
+	ret := toBookStore(book)
+	return &ret, nil
+}
 
-	// Convert BookResponse to BookStore for template compatibility
-	var result []BookStore
-	for _, book := range books {
-		result = append(result, BookStore{
-			ID:          book.ID,
-			BookName:    book.Title,
-			BookAuthor:  book.Author,
-			BookEdition: book.Edition,
-			BookPages:   book.Pages,
-		})
+// bookFormRequest reads the book form. It fails when the series position
+// is not a number or the price cannot be read.
+func bookFormRequest(c echo.Context) (BookRequest, error) {
+	book := BookRequest{
+		ID:          strings.TrimSpace(c.FormValue("id")),
+		Title:       strings.TrimSpace(c.FormValue("title")),
+		Author:      strings.TrimSpace(c.FormValue("author")),
+		Pages:       strings.TrimSpace(c.FormValue("pages")),
+		Edition:     strings.TrimSpace(c.FormValue("edition")),
+		Year:        strings.TrimSpace(c.FormValue("year")),
+		PublisherID: strings.TrimSpace(c.FormValue("publisherId")),
+		Series:      strings.TrimSpace(c.FormValue("series")),
+		Format:      strings.TrimSpace(c.FormValue("format")),
+		Language:    strings.TrimSpace(c.FormValue("language")),
 	}
-
-	return result, nil
+	if position := strings.TrimSpace(c.FormValue("seriesPosition")); position != "" {
+		p, err := strconv.ParseFloat(position, 64)
+		if err != nil {
+			return book, fmt.Errorf("Series position must be a number")
+		}
+		book.SeriesPosition = &p
+	}
+	price, err := parsePrice(c.FormValue("price"), c.FormValue("currency"))
+	if err != nil {
+		return book, err
+	}
+	book.Price = price
+	return book, nil
 }
 
-func getAuthorsFromAPI() ([]map[string]interface{}, error) {
-	books, err := getBooksFromAPI()
+// renderBookForm shows the create or edit form; a non-empty message is
+// rendered with status 422 so HTMX swaps the form back in with the error.
+func renderBookForm(c echo.Context, editing bool, book BookRequest, message string) error {
+	status := http.StatusOK
+	if message != "" {
+		status = http.StatusUnprocessableEntity
+	}
+	publishers, err := getPublishersFromAPI()
 	if err != nil {
-		return nil, err
+		log.Printf("Error fetching publishers: %v", err)
 	}
+	return c.Render(status, "book-form", page(c, map[string]interface{}{
+		"Editing":    editing,
+		"Book":       book,
+		"Publishers": publishers,
+		"Error":      message,
+	}))
+}
 
-	// Extract unique authors
-	authorsMap := make(map[string]bool)
-	for _, book := range books {
-		authorsMap[book.BookAuthor] = true
+// renderBookDetail shows a book with its publisher, the other editions of
+// its work and, for books in a series, links to the previous and next book.
+func renderBookDetail(c echo.Context, id string) error {
+	book, err := findBook(id)
+	if err != nil {
+		log.Printf("Error fetching book: %v", err)
+		return c.String(http.StatusBadGateway, "Could not load the book")
+	}
+	if book == nil {
+		return c.String(http.StatusNotFound, "Book not found")
+	}
+	data := map[string]interface{}{"Book": book}
+	if book.PublisherID != "" {
+		var publisher Publisher
+		if err := getJSONFromAPI("/api/publishers/"+url.PathEscape(book.PublisherID), &publisher); err != nil {
+			log.Printf("Error fetching publisher: %v", err)
+		} else {
+			data["Publisher"] = publisher
+		}
+	}
+	if book.Series != "" {
+		var series SeriesInfo
+		if err := getJSONFromAPI("/api/books/"+url.PathEscape(id)+"/series", &series); err != nil {
+			log.Printf("Error fetching series: %v", err)
+		} else {
+			data["Series"] = series
+		}
+	}
+	if book.WorkID != "" {
+		var editions []Edition
+		if err := getJSONFromAPI("/api/works/"+url.PathEscape(book.WorkID)+"/editions", &editions); err != nil {
+			log.Printf("Error fetching editions: %v", err)
+		} else {
+			data["Editions"] = otherEditions(editions, id)
+		}
+	}
+	var recommendations []Recommendation
+	if err := getJSONFromAPI("/api/books/"+url.PathEscape(id)+"/recommendations?limit=6", &recommendations); err != nil {
+		log.Printf("Error fetching recommendations: %v", err)
+	} else {
+		data["Recommendations"] = recommendations
+	}
+	if user := currentUser(c); user != nil {
+		lists, err := getListsFromAPI(user)
+		if err != nil {
+			log.Printf("Error fetching lists: %v", err)
+		} else {
+			data["Lists"] = lists
+		}
 	}
+	return renderPage(c, http.StatusOK, "book-detail", data)
+}
 
-	var authors []map[string]interface{}
-	for author := range authorsMap {
-		authors = append(authors, map[string]interface{}{
-			"Author": author,
-		})
+// otherEditions drops the edition with the given ID from editions.
+func otherEditions(editions []Edition, id string) []Edition {
+	others := []Edition{}
+	for _, edition := range editions {
+		if edition.ID != id {
+			others = append(others, edition)
+		}
 	}
+	return others
+}
 
-	return authors, nil
+func getBooksFromAPI() ([]BookStore, error) {
+	return getFilteredBooksFromAPI(nil)
 }
 
-func getYearsFromAPI() ([]map[string]interface{}, error) {
+// getFilteredBooksFromAPI lists the books matching the books-get filters in
+// query, such as author, authorId or year.
+func getFilteredBooksFromAPI(query url.Values) ([]BookStore, error) {
 	booksGetURL := os.Getenv("BOOKS_GET_URL")
 	if booksGetURL == "" {
 		booksGetURL = "http://books-get:8080"
 	}
+	endpoint := booksGetURL + "/api/books"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
 
 	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(booksGetURL + "/api/books")
+	resp, err := client.Get(endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -118,71 +416,511 @@ func getYearsFromAPI() ([]map[string]interface{}, error) {
 		return nil, err
 	}
 
-	// Extract unique years
-	yearsMap := make(map[string]bool)
+	// Convert BookResponse to BookStore for template compatibility
+	var result []BookStore
 	for _, book := range books {
-		yearsMap[book.Year] = true
+		result = append(result, toBookStore(book))
+	}
+
+	return result, nil
+}
+
+// toBookStore converts a books-get book to the form the templates use.
+func toBookStore(book BookResponse) BookStore {
+	return BookStore{
+		ID:             book.ID,
+		BookName:       book.Title,
+		BookAuthor:     book.Author,
+		BookEdition:    book.Edition,
+		BookPages:      book.Pages,
+		BookYear:       book.Year,
+		PublisherID:    book.PublisherID,
+		Series:         book.Series,
+		SeriesPosition: book.SeriesPosition,
+		WorkID:         book.WorkID,
+		Format:         book.Format,
+		Language:       book.Language,
+		Price:          book.Price,
+		EffectivePrice: book.EffectivePrice,
+		Discount:       book.Discount,
+		Rating:         book.Rating,
+		CoverURL:       book.CoverURL,
+	}
+}
+
+// Author is a row of the authors statistics of books-get.
+type Author struct {
+	ID          string   `json:"authorId"`
+	Name        string   `json:"name"`
+	SortName    string   `json:"sortName"`
+	BirthYear   *int     `json:"birthYear"`
+	DeathYear   *int     `json:"deathYear"`
+	Nationality string   `json:"nationality"`
+	Aliases     []string `json:"aliases"`
+	Books       int64    `json:"books"`
+}
+
+type YearCount struct {
+	Year  int64 `json:"year"`
+	Books int64 `json:"books"`
+}
+
+// getJSONFromAPI decodes one of the public books-get read endpoints, such
+// as the statistics, the publishers or the series of a book.
+func getJSONFromAPI(path string, out interface{}) error {
+	booksGetURL := os.Getenv("BOOKS_GET_URL")
+	if booksGetURL == "" {
+		booksGetURL = "http://books-get:8080"
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(booksGetURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("books-get answered %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func getAuthorsFromAPI() ([]Author, error) {
+	var authors []Author
+	err := getJSONFromAPI("/api/stats/authors?limit=1000", &authors)
+	return authors, err
+}
+
+// findAuthorID looks an author up by name or alias in the authors service
+// and returns its ID, or "" when there is no such author.
+func findAuthorID(name string) (string, error) {
+	authorsURL := os.Getenv("AUTHORS_URL")
+	if authorsURL == "" {
+		authorsURL = "http://authors:8080"
 	}
 
-	var years []map[string]interface{}
-	for year := range yearsMap {
-		years = append(years, map[string]interface{}{
-			"Year": year,
-		})
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(authorsURL + "/api/authors?" + url.Values{"q": {name}}.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var authors []struct {
+		ID       string   `json:"id"`
+		Name     string   `json:"name"`
+		SortName string   `json:"sortName"`
+		Aliases  []string `json:"aliases"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&authors); err != nil {
+		return "", err
+	}
+	for _, a := range authors {
+		for _, candidate := range append([]string{a.Name, a.SortName}, a.Aliases...) {
+			if strings.EqualFold(candidate, name) {
+				return a.ID, nil
+			}
+		}
 	}
+	return "", nil
+}
+
+func getPublishersFromAPI() ([]Publisher, error) {
+	var publishers []Publisher
+	err := getJSONFromAPI("/api/publishers", &publishers)
+	return publishers, err
+}
 
-	return years, nil
+func getYearsFromAPI() ([]YearCount, error) {
+	var years []YearCount
+	err := getJSONFromAPI("/api/stats/years?limit=1000", &years)
+	return years, err
 }
 
 func main() {
 	fmt.Println("Web server starting...")
 
+	var client *mongo.Client
+	var accounts *accountStore
+	var err error
+
+	// Retry connection to MongoDB with shorter intervals
+	for i := 0; i < 5; i++ {
+		client, accounts, err = connectToMongoDB()
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to connect to MongoDB (attempt %d/5): %v\n", i+1, err)
+		time.Sleep(2 * time.Second)
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: Failed to connect to MongoDB after 5 attempts: %v\n", err)
+		fmt.Println("Login is disabled until the web server is restarted with a database")
+	}
+
+	defer func() {
+		if client == nil {
+			return
+		}
+		if err := client.Disconnect(context.TODO()); err != nil {
+			fmt.Printf("Error disconnecting from MongoDB: %v\n", err)
+		}
+	}()
+
 	e := echo.New()
 	e.Renderer = loadTemplates()
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
+	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
+		TokenLookup:    "header:X-CSRF-Token,form:_csrf",
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSecure:   secureCookies(),
+		CookieSameSite: http.SameSiteStrictMode,
+	}))
+	e.Use(loadSession(accounts))
 	e.Static("/css", "css")
 
+	reader := requireUserRole(RoleReader)
+	editor := requireUserRole(RoleEditor)
+	admin := requireUserRole(RoleAdmin)
+
 	e.GET("/", func(c echo.Context) error {
-		return c.Render(200, "index", nil)
+		return c.Render(200, "index", page(c, nil))
 	})
 
 	e.GET("/books", func(c echo.Context) error {
-		books, err := getBooksFromAPI()
-		if err != nil {
-			log.Printf("Error fetching books: %v", err)
-			// Return empty page instead of error for testing
-			return c.Render(200, "book-table", []BookStore{})
-		}
-		return c.Render(200, "book-table", books)
+		return renderBookTable(c, http.StatusOK)
 	})
 
 	e.GET("/authors", func(c echo.Context) error {
 		authors, err := getAuthorsFromAPI()
 		if err != nil {
 			log.Printf("Error fetching authors: %v", err)
-			return c.Render(200, "authors-table", []map[string]interface{}{})
+			authors = []Author{}
+		}
+		return c.Render(200, "authors-table", page(c, map[string]interface{}{"Authors": authors}))
+	})
+
+	e.GET("/authors/:name", func(c echo.Context) error {
+		name := c.Param("name")
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+		query := url.Values{"author": {name}}
+		authorID, err := findAuthorID(name)
+		if err != nil {
+			log.Printf("Error looking up author: %v", err)
+		} else if authorID != "" {
+			query = url.Values{"authorId": {authorID}}
 		}
-		return c.Render(200, "authors-table", authors)
+		return renderBookList(c, name, query)
+	})
+
+	e.GET("/years/:year", func(c echo.Context) error {
+		year := c.Param("year")
+		return renderBookList(c, "Published in "+year, url.Values{"year": {year}})
 	})
 
 	e.GET("/years", func(c echo.Context) error {
 		years, err := getYearsFromAPI()
 		if err != nil {
 			log.Printf("Error fetching years: %v", err)
-			return c.Render(200, "years-table", []map[string]interface{}{})
+			years = []YearCount{}
 		}
-		return c.Render(200, "years-table", years)
+		return c.Render(200, "years-table", page(c, map[string]interface{}{"Years": years}))
 	})
 
 	e.GET("/search", func(c echo.Context) error {
-		return c.Render(200, "search-bar", nil)
+		return c.Render(200, "search-bar", page(c, nil))
 	})
 
 	e.GET("/create", func(c echo.Context) error {
-		return c.NoContent(http.StatusNoContent)
+		return renderBookForm(c, false, BookRequest{}, "")
+	}, editor)
+
+	e.POST("/books", func(c echo.Context) error {
+		book, err := bookFormRequest(c)
+		if err != nil {
+			return renderBookForm(c, false, book, err.Error())
+		}
+		if book.ID == "" || book.Title == "" || book.Author == "" {
+			return renderBookForm(c, false, book, "ID, title, and author are required fields")
+		}
+		if err := createBookViaAPI(currentUser(c), book); err != nil {
+			return renderBookForm(c, false, book, err.Error())
+		}
+		return renderBookTable(c, http.StatusCreated)
+	}, editor)
+
+	e.GET("/books/:id/edit", func(c echo.Context) error {
+		book, err := findBook(c.Param("id"))
+		if err != nil {
+			log.Printf("Error fetching book: %v", err)
+			return c.String(http.StatusBadGateway, "Could not load the book")
+		}
+		if book == nil {
+			return c.String(http.StatusNotFound, "Book not found")
+		}
+		return renderBookForm(c, true, BookRequest{
+			ID:             book.ID,
+			Title:          book.BookName,
+			Author:         book.BookAuthor,
+			Pages:          book.BookPages,
+			Edition:        book.BookEdition,
+			Year:           book.BookYear,
+			PublisherID:    book.PublisherID,
+			Series:         book.Series,
+			SeriesPosition: book.SeriesPosition,
+			Format:         book.Format,
+			Language:       book.Language,
+			Price:          book.Price,
+		}, "")
+	}, editor)
+
+	e.GET("/books/:id", func(c echo.Context) error {
+		return renderBookDetail(c, c.Param("id"))
 	})
 
+	e.PUT("/books/:id", func(c echo.Context) error {
+		book, err := bookFormRequest(c)
+		book.ID = c.Param("id")
+		if err != nil {
+			return renderBookForm(c, true, book, err.Error())
+		}
+		if book.Title == "" || book.Author == "" {
+			return renderBookForm(c, true, book, "Title and author are required fields")
+		}
+		if err := updateBookViaAPI(currentUser(c), book.ID, book); err != nil {
+			return renderBookForm(c, true, book, err.Error())
+		}
+		return renderBookTable(c, http.StatusOK)
+	}, editor)
+
+	e.DELETE("/books/:id", func(c echo.Context) error {
+		if err := deleteBookViaAPI(currentUser(c), c.Param("id")); err != nil {
+			log.Printf("Error deleting book: %v", err)
+			return c.String(http.StatusBadGateway, err.Error())
+		}
+		return renderBookTable(c, http.StatusOK)
+	}, admin)
+
+	e.GET("/books/:id/history", func(c echo.Context) error {
+		return renderHistory(c, c.Param("id"), "")
+	}, editor)
+
+	e.PUT("/books/:id/revert/:rev", func(c echo.Context) error {
+		id := c.Param("id")
+		revision, err := strconv.ParseInt(c.Param("rev"), 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid revision")
+		}
+		if err := revertBookViaAPI(currentUser(c), id, revision); err != nil {
+			return renderHistory(c, id, err.Error())
+		}
+		return renderHistory(c, id, "")
+	}, editor)
+
+	e.GET("/audit", func(c echo.Context) error {
+		filters := url.Values{}
+		for _, key := range []string{"bookId", "actor", "action"} {
+			if value := strings.TrimSpace(c.QueryParam(key)); value != "" {
+				filters.Set(key, value)
+			}
+		}
+		data := map[string]interface{}{
+			"BookID":  filters.Get("bookId"),
+			"Actor":   filters.Get("actor"),
+			"Action":  filters.Get("action"),
+			"Actions": auditActions,
+		}
+		entries, err := getAuditFromAPI(currentUser(c), filters)
+		if err != nil {
+			log.Printf("Error fetching audit entries: %v", err)
+			data["Error"] = "Could not load the audit log"
+		}
+		data["Entries"] = entries
+		return c.Render(200, "audit-table", page(c, data))
+	}, editor)
+
+	e.GET("/inventory", func(c echo.Context) error {
+		threshold := strings.TrimSpace(c.QueryParam("threshold"))
+		if _, err := strconv.ParseUint(threshold, 10, 32); err != nil {
+			threshold = ""
+		}
+		data := map[string]interface{}{"Threshold": threshold}
+		rows, err := getLowStockFromAPI(currentUser(c), threshold)
+		if err != nil {
+			log.Printf("Error fetching low stock: %v", err)
+			data["Error"] = "Could not load the stock report"
+		}
+		data["Rows"] = rows
+		return c.Render(200, "low-stock-table", page(c, data))
+	}, editor)
+
+	e.GET("/lists", func(c echo.Context) error {
+		return renderLists(c, http.StatusOK, nil)
+	}, reader)
+
+	e.POST("/lists", func(c echo.Context) error {
+		req := ListRequest{
+			Name:        strings.TrimSpace(c.FormValue("name")),
+			Description: strings.TrimSpace(c.FormValue("description")),
+			Visibility:  c.FormValue("visibility"),
+		}
+		if err := createListViaAPI(currentUser(c), req); err != nil {
+			return renderLists(c, http.StatusUnprocessableEntity, map[string]interface{}{"Error": err.Error(), "Name": req.Name})
+		}
+		return renderLists(c, http.StatusOK, nil)
+	}, reader)
+
+	e.GET("/lists/:id", func(c echo.Context) error {
+		return renderList(c, c.Param("id"), "")
+	}, reader)
+
+	e.PUT("/lists/:id", func(c echo.Context) error {
+		id := c.Param("id")
+		req := ListRequest{
+			Name:        strings.TrimSpace(c.FormValue("name")),
+			Description: strings.TrimSpace(c.FormValue("description")),
+			Visibility:  c.FormValue("visibility"),
+		}
+		if err := updateListViaAPI(currentUser(c), id, req); err != nil {
+			return renderList(c, id, err.Error())
+		}
+		return renderList(c, id, "")
+	}, reader)
+
+	e.DELETE("/lists/:id", func(c echo.Context) error {
+		if err := deleteListViaAPI(currentUser(c), c.Param("id")); err != nil {
+			log.Printf("Error deleting list: %v", err)
+			return c.String(http.StatusBadGateway, err.Error())
+		}
+		return renderLists(c, http.StatusOK, nil)
+	}, reader)
+
+	e.PUT("/lists/:id/items/:bookId/move", func(c echo.Context) error {
+		id, bookID := c.Param("id"), c.Param("bookId")
+		list, err := getListFromAPI(currentUser(c), id)
+		if err != nil {
+			return renderList(c, id, err.Error())
+		}
+		if position, ok := movePosition(list.Items, bookID, c.QueryParam("direction")); ok {
+			if err := moveListItemViaAPI(currentUser(c), id, bookID, position); err != nil {
+				return renderList(c, id, err.Error())
+			}
+		}
+		return renderList(c, id, "")
+	}, reader)
+
+	e.DELETE("/lists/:id/items/:bookId", func(c echo.Context) error {
+		id := c.Param("id")
+		if err := removeListItemViaAPI(currentUser(c), id, c.Param("bookId")); err != nil {
+			return renderList(c, id, err.Error())
+		}
+		return renderList(c, id, "")
+	}, reader)
+
+	// Adds a book to one of the lists of the signed-in user from the book
+	// page.
+	e.POST("/books/:id/lists", func(c echo.Context) error {
+		listID := c.FormValue("listId")
+		note := strings.TrimSpace(c.FormValue("note"))
+		data := map[string]interface{}{"Message": "Added to the list"}
+		status := http.StatusOK
+		if err := addToListViaAPI(currentUser(c), listID, c.Param("id"), note); err != nil {
+			data = map[string]interface{}{"Error": err.Error()}
+			status = http.StatusUnprocessableEntity
+		}
+		return c.Render(status, "list-add-result", data)
+	}, reader)
+
+	// A public list as anybody with the share link sees it; no login needed.
+	e.GET("/shared/:token", func(c echo.Context) error {
+		list, err := getSharedListFromAPI(c.Param("token"))
+		if err != nil {
+			log.Printf("Error fetching shared list: %v", err)
+			return c.String(http.StatusBadGateway, "Could not load the list")
+		}
+		if list == nil {
+			return c.String(http.StatusNotFound, "List not found")
+		}
+		return renderPage(c, http.StatusOK, "shared-list", map[string]interface{}{"List": list})
+	})
+
+	e.GET("/login", func(c echo.Context) error {
+		return c.Render(200, "login-form", page(c, nil))
+	})
+
+	e.POST("/login", func(c echo.Context) error {
+		if accounts == nil {
+			return c.Render(http.StatusUnprocessableEntity, "login-form", page(c, map[string]interface{}{
+				"Error": "Login is currently unavailable",
+			}))
+		}
+		ctx := c.Request().Context()
+		username := strings.TrimSpace(c.FormValue("username"))
+		user, err := accounts.checkPassword(ctx, username, c.FormValue("password"))
+		if err != nil {
+			if err != errInvalidLogin {
+				log.Printf("Error checking password: %v", err)
+			}
+			return c.Render(http.StatusUnprocessableEntity, "login-form", page(c, map[string]interface{}{
+				"Error":    "Invalid username or password",
+				"Username": username,
+			}))
+		}
+		token, expires, err := accounts.startSession(ctx, user)
+		if err != nil {
+			log.Printf("Error starting session: %v", err)
+			return c.String(http.StatusInternalServerError, "Could not log in")
+		}
+		setSessionCookie(c, token, expires)
+		// Reload the whole page so the navigation reflects the new user.
+		c.Response().Header().Set("HX-Redirect", "/")
+		return c.Redirect(http.StatusSeeOther, "/")
+	})
+
+	e.POST("/logout", func(c echo.Context) error {
+		if cookie, err := c.Cookie(sessionCookie); err == nil && accounts != nil {
+			if err := accounts.endSession(c.Request().Context(), cookie.Value); err != nil {
+				log.Printf("Error ending session: %v", err)
+			}
+		}
+		clearSessionCookie(c)
+		c.Response().Header().Set("HX-Redirect", "/")
+		return c.Redirect(http.StatusSeeOther, "/")
+	})
+
+	e.GET("/users", func(c echo.Context) error {
+		return c.Render(200, "user-form", page(c, nil))
+	}, admin)
+
+	e.POST("/users", func(c echo.Context) error {
+		username := strings.TrimSpace(c.FormValue("username"))
+		password := c.FormValue("password")
+		role := c.FormValue("role")
+		data := map[string]interface{}{"Username": username}
+		switch {
+		case username == "" || len(password) < 8:
+			data["Error"] = "A username and a password of at least 8 characters are required"
+		case roleRank[role] == 0:
+			data["Error"] = "Unknown role"
+		default:
+			if err := accounts.createUser(c.Request().Context(), username, password, []string{role}); err != nil {
+				data["Error"] = err.Error()
+			} else {
+				data = map[string]interface{}{"Message": fmt.Sprintf("User %s created", username)}
+			}
+		}
+		status := http.StatusOK
+		if data["Error"] != nil {
+			status = http.StatusUnprocessableEntity
+		}
+		return c.Render(status, "user-form", page(c, data))
+	}, admin)
+
 	fmt.Println("Web server ready on port 8080")
 	e.Logger.Fatal(e.Start(":8080"))
-}
\ No newline at end of file
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func render(t *testing.T, name string, data map[string]interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := loadTemplates().tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		t.Fatalf("rendering %s: %v", name, err)
+	}
+	return buf.String()
+}
+
+func TestBookTableHidesControlsForAnonymousUsers(t *testing.T) {
+	books := []BookStore{{ID: "b1", BookName: "Frankenstein", BookAuthor: "Mary Shelley"}}
+
+	anon := render(t, "book-table", map[string]interface{}{"Books": books})
+	if strings.Contains(anon, "hx-delete") || strings.Contains(anon, "/edit") {
+		t.Error("anonymous users must not see edit or delete controls")
+	}
+
+	editor := render(t, "book-table", map[string]interface{}{"Books": books, "CanEdit": true})
+	if !strings.Contains(editor, `hx-get="/books/b1/edit"`) || strings.Contains(editor, "hx-delete") {
+		t.Error("editors should see edit controls but not delete, which needs an admin")
+	}
+
+	admin := render(t, "book-table", map[string]interface{}{"Books": books, "CanEdit": true, "IsAdmin": true})
+	if !strings.Contains(admin, `hx-delete="/books/b1"`) {
+		t.Error("admins should see delete controls")
+	}
+}
+
+func TestBookTableShowsPriceAndSaleBadge(t *testing.T) {
+	books := []BookStore{
+		{ID: "b1", BookName: "Dune", Price: &Money{1299, "EUR"}, EffectivePrice: &Money{999, "EUR"}, Discount: &Discount{Label: "Summer sale"}},
+		{ID: "b2", BookName: "Emma", Price: &Money{1500, "JPY"}, EffectivePrice: &Money{1500, "JPY"}},
+	}
+	out := render(t, "book-table", map[string]interface{}{"Books": books})
+	for _, want := range []string{"9.99 EUR", "<s>12.99 EUR</s>", `title="Summer sale"`, "1500 JPY"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("book table is missing %q", want)
+		}
+	}
+	if strings.Count(out, "sale-badge") != 1 {
+		t.Error("only discounted books get a sale badge")
+	}
+}
+
+func TestBookTableShowsAvailability(t *testing.T) {
+	due := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	books := []BookStore{
+		{ID: "b1", BookName: "Dune", Availability: &Availability{Copies: 3, Available: 2}},
+		{ID: "b2", BookName: "Emma", Availability: &Availability{Copies: 1, NextDue: &due, Waiting: 2}},
+	}
+
+	out := render(t, "book-table", map[string]interface{}{"Books": books})
+	if strings.Contains(out, "Availability") {
+		t.Error("the availability column needs the loans service")
+	}
+
+	out = render(t, "book-table", map[string]interface{}{"Books": books, "ShowAvailability": true})
+	for _, want := range []string{`<span class="in">2 of 3 available</span>`, `<span class="out">Due back 2 Mar 2024 (2 waiting)</span>`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("book table is missing %q", want)
+		}
+	}
+}
+
+func TestBookTableShowsRatingAndSortLink(t *testing.T) {
+	books := []BookStore{
+		{ID: "b1", BookName: "Dune", Rating: Rating{Average: 4.25, Count: 12}},
+		{ID: "b2", BookName: "Emma", Rating: Rating{Average: 5, Count: 1}},
+		{ID: "b3", BookName: "Ulysses"},
+	}
+
+	out := render(t, "book-table", map[string]interface{}{"Books": books})
+	for _, want := range []string{"4.2 (12 reviews)", "5.0 (1 review)", "No reviews", `hx-get="/books?sort=rating"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("book table is missing %q", want)
+		}
+	}
+
+	out = render(t, "book-table", map[string]interface{}{"Books": books, "SortByRating": true})
+	if strings.Contains(out, `hx-get="/books?sort=rating"`) || !strings.Contains(out, `hx-get="/books"`) {
+		t.Error("the sorted table should link back to the catalog order")
+	}
+}
+
+func TestBookTableShowsCoverThumbnails(t *testing.T) {
+	books := []BookStore{
+		{ID: "b1", BookName: "Dune", CoverURL: "/api/books/b1/cover?v=abc"},
+		{ID: "b2", BookName: "Emma"},
+	}
+	out := render(t, "book-table", map[string]interface{}{"Books": books})
+	if !strings.Contains(out, `<img src="/api/books/b1/cover?v=abc&amp;size=small"`) {
+		t.Error("books with a cover show its small thumbnail")
+	}
+	if strings.Count(out, "<img") != 1 {
+		t.Error("books without a cover show no image")
+	}
+
+	detail := render(t, "book-detail", map[string]interface{}{"Book": &books[0]})
+	if !strings.Contains(detail, "size=medium") {
+		t.Error("the book page shows the medium cover")
+	}
+}
+
+func TestListPagesHandleDeletedBooks(t *testing.T) {
+	list := &UserList{
+		ID:         "l1",
+		Owner:      "ana",
+		Name:       "To read",
+		Visibility: "public",
+		ShareToken: "tok",
+		Items: []UserListItem{
+			{BookID: "b1", Title: "Dune", Available: true, Note: "Signed"},
+			{BookID: "b2", Title: "Gone Book"},
+		},
+	}
+
+	out := render(t, "list-detail", map[string]interface{}{"List": list})
+	for _, want := range []string{`hx-get="/books/b1"`, "Signed", "Gone Book", "no longer in the catalog", `href="/shared/tok"`, `hx-delete="/lists/l1/items/b2"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list page is missing %q", want)
+		}
+	}
+	if strings.Contains(out, `hx-get="/books/b2"`) {
+		t.Error("deleted books must not link to the catalog")
+	}
+
+	shared := render(t, "shared-list", map[string]interface{}{"List": list})
+	if strings.Contains(shared, "hx-delete") || strings.Contains(shared, "hx-put") || strings.Contains(shared, "tok") {
+		t.Error("the shared page must not offer changes or repeat the share token")
+	}
+}
+
+func TestMovePosition(t *testing.T) {
+	items := []UserListItem{{BookID: "a"}, {BookID: "b"}, {BookID: "c"}}
+	tests := []struct {
+		bookID, direction string
+		want              int
+		ok                bool
+	}{
+		{"b", "up", 0, true},
+		{"b", "down", 2, true},
+		{"a", "up", 0, false},
+		{"c", "down", 0, false},
+		{"z", "up", 0, false},
+		{"b", "sideways", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := movePosition(items, tt.bookID, tt.direction)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("movePosition(%s, %s) = %d, %v; want %d, %v", tt.bookID, tt.direction, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIndexCarriesCSRFToken(t *testing.T) {
+	out := render(t, "index", map[string]interface{}{"CSRF": "tok123"})
+	if !strings.Contains(out, "tok123") {
+		t.Error("index does not pass the CSRF token to HTMX requests")
+	}
+	if strings.Contains(out, `hx-get="/create"`) {
+		t.Error("Create is shown to anonymous users")
+	}
+
+	out = render(t, "index", map[string]interface{}{"CanEdit": true, "User": &SessionUser{Username: "ed"}})
+	if !strings.Contains(out, `hx-get="/create"`) {
+		t.Error("Create is hidden from editors")
+	}
+}
+
+func TestSessionUserHasRole(t *testing.T) {
+	var nobody *SessionUser
+	if nobody.HasRole(RoleReader) {
+		t.Error("nil user has no roles")
+	}
+	admin := &SessionUser{Roles: []string{RoleAdmin}}
+	if !admin.HasRole(RoleEditor) {
+		t.Error("admins are editors")
+	}
+	reader := &SessionUser{Roles: []string{RoleReader}}
+	if reader.HasRole(RoleEditor) {
+		t.Error("readers are not editors")
+	}
+}
+
+func TestAuditTableShowsChanges(t *testing.T) {
+	entries := []AuditEntry{{
+		BookID:  "b1",
+		Action:  "update",
+		Actor:   "ed",
+		Changes: []AuditChange{{Field: "bookname", Before: "Dune", After: "Dune Messiah"}},
+	}}
+	out := render(t, "audit-table", map[string]interface{}{
+		"Entries": entries,
+		"Action":  "update",
+		"Actions": auditActions,
+	})
+	if !strings.Contains(out, "Dune Messiah") || !strings.Contains(out, "<b>bookname</b>") {
+		t.Error("audit table does not show field changes")
+	}
+	if !strings.Contains(out, `value="update" selected`) {
+		t.Error("selected action filter is not kept")
+	}
+}
+
+func TestLowStockTableLinksBooks(t *testing.T) {
+	out := render(t, "low-stock-table", map[string]interface{}{
+		"Threshold": "2",
+		"Rows":      []LowStock{{BookID: "b 1", Title: "Dune", Author: "Herbert", Total: 1}},
+	})
+	if !strings.Contains(out, `href="/books/b%201"`) || !strings.Contains(out, "Dune") {
+		t.Error("low stock table does not link the book")
+	}
+	if !strings.Contains(out, `value="2"`) {
+		t.Error("threshold filter is not kept")
+	}
+	out = render(t, "low-stock-table", map[string]interface{}{})
+	if !strings.Contains(out, "No books are low on stock.") {
+		t.Error("empty report is not explained")
+	}
+}
+
+func TestHistoryTableOffersRevert(t *testing.T) {
+	revisions := []BookRevision{
+		{Revision: 1, Action: "create", Book: BookRequest{Title: "Dune"}},
+		{Revision: 2, Action: "delete", Deleted: true, Book: BookRequest{Title: "Dune"}},
+	}
+	out := render(t, "history-table", map[string]interface{}{"BookID": "b1", "Revisions": revisions})
+	if !strings.Contains(out, `hx-put="/books/b1/revert/1"`) {
+		t.Error("revert is not offered for an earlier revision")
+	}
+	if strings.Contains(out, `/books/b1/revert/2"`) {
+		t.Error("revert is offered for a deletion")
+	}
+}
+
+func TestAuthorsTable(t *testing.T) {
+	born := 1809
+	authors := []Author{{Name: "Edgar Allan Poe", SortName: "Poe, Edgar Allan", BirthYear: &born, Aliases: []string{"E. A. Poe", "Poe"}, Books: 7}}
+	out := render(t, "authors-table", map[string]interface{}{"Authors": authors})
+	for _, want := range []string{"Poe, Edgar Allan", "1809", "E. A. Poe, Poe", "<th> 7 </th>", `href="/authors/Edgar%20Allan%20Poe"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("authors table is missing %q", want)
+		}
+	}
+}
+
+func TestRenderPageEmbedsFragmentOnDirectLoad(t *testing.T) {
+	e := echo.New()
+	e.Renderer = loadTemplates()
+	data := map[string]interface{}{
+		"Heading": "Mary Shelley",
+		"Books":   []BookStore{{ID: "b1", BookName: "Frankenstein", BookAuthor: "Mary Shelley"}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/authors/Mary%20Shelley", nil)
+	rec := httptest.NewRecorder()
+	if err := renderPage(e.NewContext(req, rec), http.StatusOK, "book-list", data); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "<!DOCTYPE html>") || !strings.Contains(body, "Frankenstein") || !strings.Contains(body, "1 book") {
+		t.Error("direct loads should get the full page with the book list")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/authors/Mary%20Shelley", nil)
+	req.Header.Set("HX-Request", "true")
+	rec = httptest.NewRecorder()
+	if err := renderPage(e.NewContext(req, rec), http.StatusOK, "book-list", data); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "<!DOCTYPE html>") || !strings.Contains(body, "Frankenstein") {
+		t.Error("HTMX requests should get only the fragment")
+	}
+}
+
+func TestBookDetailShowsSeriesNavigation(t *testing.T) {
+	two := 2.0
+	book := &BookStore{ID: "b2", BookName: "Dune Messiah", BookAuthor: "Frank Herbert", Series: "Dune", SeriesPosition: &two}
+	out := render(t, "book-detail", map[string]interface{}{
+		"Book":      book,
+		"Publisher": Publisher{Code: "ace", Name: "Ace Books"},
+		"Series": SeriesInfo{
+			Series:   "Dune",
+			Previous: &BookResponse{ID: "b1", Title: "Dune"},
+			Next:     &BookResponse{ID: "b3", Title: "Children of Dune"},
+		},
+	})
+	for _, want := range []string{"Ace Books", "Dune, book 2", `hx-get="/books/b1"`, "Children of Dune &rarr;"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("book detail is missing %q", want)
+		}
+	}
+
+	solo := render(t, "book-detail", map[string]interface{}{"Book": &BookStore{ID: "b9", BookName: "Solo"}})
+	if strings.Contains(solo, "series-nav") {
+		t.Error("books outside a series should have no series navigation")
+	}
+}
+
+func TestBookDetailShowsRecommendations(t *testing.T) {
+	out := render(t, "book-detail", map[string]interface{}{
+		"Book": &BookStore{ID: "b1", BookName: "Dune"},
+		"Recommendations": []Recommendation{
+			{Book: BookResponse{ID: "b2", Title: "Dune Messiah", Author: "Frank Herbert", Year: "1969"}, Score: 7},
+		},
+	})
+	for _, want := range []string{"You might also like", `hx-get="/books/b2"`, "Frank Herbert, 1969"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("book detail is missing %q", want)
+		}
+	}
+
+	none := render(t, "book-detail", map[string]interface{}{"Book": &BookStore{ID: "b1", BookName: "Dune"}, "Recommendations": []Recommendation{}})
+	if strings.Contains(none, "You might also like") {
+		t.Error("the strip is hidden when there is nothing to recommend")
+	}
+}
+
+func TestBookDetailListsOtherEditions(t *testing.T) {
+	editions := otherEditions([]Edition{
+		{ID: "b1", Title: "Frankenstein", Year: "1818"},
+		{ID: "b2", Title: "Frankenstein", Year: "1831", Format: "paperback", ISBN: "978-0-14-143947-1"},
+	}, "b1")
+	if len(editions) != 1 || editions[0].ID != "b2" {
+		t.Fatalf("the shown edition should be left out, got %+v", editions)
+	}
+	out := render(t, "book-detail", map[string]interface{}{
+		"Book":     &BookStore{ID: "b1", BookName: "Frankenstein", WorkID: "w1"},
+		"Editions": editions,
+	})
+	for _, want := range []string{"Other editions", `hx-get="/books/b2"`, "1831, paperback", "978-0-14-143947-1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("book detail is missing %q", want)
+		}
+	}
+}
+
+func TestFindBookAsksForASingleBook(t *testing.T) {
+	books := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/books/b1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"b1","title":"Frankenstein","author":"Mary Shelley"}`))
+	}))
+	defer books.Close()
+	t.Setenv("BOOKS_GET_URL", books.URL)
+
+	book, err := findBook("b1")
+	if err != nil || book == nil || book.BookName != "Frankenstein" || book.BookAuthor != "Mary Shelley" {
+		t.Fatalf("findBook(b1) = %+v, %v", book, err)
+	}
+	if book, err := findBook("missing"); err != nil || book != nil {
+		t.Errorf("findBook(missing) = %+v, %v, want no book", book, err)
+	}
+}
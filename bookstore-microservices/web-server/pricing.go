@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor units of an ISO 4217 currency, as the
+// books services store it.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Discount is the running discount that makes a book cheaper.
+type Discount struct {
+	Label   string `json:"label"`
+	Percent int64  `json:"percent"`
+}
+
+// currencyDigits maps currencies to their number of minor unit digits.
+var currencyDigits = map[string]int{
+	"AUD": 2, "CAD": 2, "CHF": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2,
+	"HUF": 2, "JPY": 0, "KRW": 0, "NOK": 2, "PLN": 2, "SEK": 2, "USD": 2,
+}
+
+// String writes the amount in major units, e.g. "12.99 EUR".
+func (m Money) String() string {
+	digits := currencyDigits[m.Currency]
+	if digits == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%d.%0*d %s", m.Amount/scale, digits, m.Amount%scale, m.Currency)
+}
+
+// Decimal writes the amount in major units without the currency, as the
+// book form shows it.
+func (m Money) Decimal() string {
+	s := m.String()
+	return s[:strings.LastIndex(s, " ")]
+}
+
+// parsePrice reads a price such as "12.99" in the given currency into
+// minor units. An empty amount means no price.
+func parsePrice(amount, currency string) (*Money, error) {
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return nil, nil
+	}
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	digits, ok := currencyDigits[currency]
+	if !ok {
+		return nil, fmt.Errorf("Unknown currency %q", currency)
+	}
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if len(fraction) > digits {
+		return nil, fmt.Errorf("Price can have at most %d decimals in %s", digits, currency)
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil || value < 0 || strings.HasPrefix(whole, "+") {
+		return nil, fmt.Errorf("Price must be an amount such as 12.99")
+	}
+	return &Money{Amount: value, Currency: currency}, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParsePrice(t *testing.T) {
+	cases := []struct {
+		amount, currency string
+		want             Money
+	}{
+		{"12.99", "eur", Money{1299, "EUR"}},
+		{"12.5", "USD", Money{1250, "USD"}},
+		{"7", "GBP", Money{700, "GBP"}},
+		{"1500", "JPY", Money{1500, "JPY"}},
+	}
+	for _, tc := range cases {
+		got, err := parsePrice(tc.amount, tc.currency)
+		if err != nil || got == nil || *got != tc.want {
+			t.Errorf("parsePrice(%q, %q) = %v, %v, want %v", tc.amount, tc.currency, got, err, tc.want)
+		}
+		if got != nil && got.String() != tc.want.String() {
+			t.Errorf("%v does not print back", got)
+		}
+	}
+
+	if got, err := parsePrice(" ", "EUR"); got != nil || err != nil {
+		t.Errorf("an empty price should mean none, got %v, %v", got, err)
+	}
+	for _, bad := range [][2]string{{"12.999", "EUR"}, {"1.5", "JPY"}, {"-1", "EUR"}, {"abc", "EUR"}, {"5", "XYZ"}} {
+		if _, err := parsePrice(bad[0], bad[1]); err == nil {
+			t.Errorf("parsePrice(%q, %q) should fail", bad[0], bad[1])
+		}
+	}
+}
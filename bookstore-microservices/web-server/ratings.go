@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+// Rating sums up the approved reviews of a book, as books-get reports it.
+type Rating struct {
+	Average float64 `json:"average"`
+	Count   int64   `json:"count"`
+}
+
+// String writes the rating for the book table, e.g. "4.3 (12 reviews)".
+func (r Rating) String() string {
+	if r.Count == 0 {
+		return "No reviews"
+	}
+	if r.Count == 1 {
+		return fmt.Sprintf("%.1f (1 review)", r.Average)
+	}
+	return fmt.Sprintf("%.1f (%d reviews)", r.Average, r.Count)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{RoleReader: 1, RoleEditor: 2, RoleAdmin: 3}
+
+const (
+	sessionCookie = "session"
+	sessionTTL    = 12 * time.Hour
+)
+
+// User is a local account. Passwords are stored as bcrypt hashes.
+type User struct {
+	Username     string    `bson:"username"`
+	PasswordHash string    `bson:"passwordhash"`
+	Roles        []string  `bson:"roles"`
+	CreatedAt    time.Time `bson:"createdat"`
+}
+
+// Session is a logged-in browser. The cookie holds a random token and only
+// its SHA-256 hash is stored.
+type Session struct {
+	TokenHash string    `bson:"_id"`
+	Username  string    `bson:"username"`
+	Roles     []string  `bson:"roles"`
+	CreatedAt time.Time `bson:"createdat"`
+	ExpiresAt time.Time `bson:"expiresat"`
+}
+
+type SessionUser struct {
+	Username string
+	Roles    []string
+}
+
+// HasRole reports whether the user holds role or a role above it.
+func (u *SessionUser) HasRole(role string) bool {
+	if u == nil {
+		return false
+	}
+	for _, r := range u.Roles {
+		if roleRank[r] >= roleRank[role] && roleRank[r] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type accountStore struct {
+	users    *mongo.Collection
+	sessions *mongo.Collection
+}
+
+func (s *accountStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.sessions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresat", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *accountStore) createUser(ctx context.Context, username, password string, roles []string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = s.users.InsertOne(ctx, User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Roles:        roles,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("user %s already exists", username)
+	}
+	return err
+}
+
+// bootstrapAdmin creates the ADMIN_USERNAME account with ADMIN_PASSWORD
+// when no users exist yet.
+func (s *accountStore) bootstrapAdmin(ctx context.Context) error {
+	username, password := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		return nil
+	}
+	count, err := s.users.CountDocuments(ctx, bson.M{})
+	if err != nil || count > 0 {
+		return err
+	}
+	fmt.Printf("Creating initial admin user %s\n", username)
+	return s.createUser(ctx, username, password, []string{RoleAdmin})
+}
+
+var errInvalidLogin = errors.New("invalid username or password")
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-password"), bcrypt.DefaultCost)
+
+func (s *accountStore) checkPassword(ctx context.Context, username, password string) (*User, error) {
+	var user User
+	err := s.users.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		// Compare anyway so unknown users take as long as wrong passwords.
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errInvalidLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, errInvalidLogin
+	}
+	return &user, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *accountStore) startSession(ctx context.Context, user *User) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now().UTC()
+	expires := now.Add(sessionTTL)
+	_, err := s.sessions.InsertOne(ctx, Session{
+		TokenHash: hashToken(token),
+		Username:  user.Username,
+		Roles:     user.Roles,
+		CreatedAt: now,
+		ExpiresAt: expires,
+	})
+	return token, expires, err
+}
+
+func (s *accountStore) lookupSession(ctx context.Context, token string) (*SessionUser, error) {
+	var session Session
+	err := s.sessions.FindOne(ctx, bson.M{
+		"_id":       hashToken(token),
+		"expiresat": bson.M{"$gt": time.Now().UTC()},
+	}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionUser{Username: session.Username, Roles: session.Roles}, nil
+}
+
+func (s *accountStore) endSession(ctx context.Context, token string) error {
+	_, err := s.sessions.DeleteOne(ctx, bson.M{"_id": hashToken(token)})
+	return err
+}
+
+// secureCookies is true unless COOKIE_SECURE=false, which is only meant for
+// plain-HTTP development setups.
+func secureCookies() bool {
+	return os.Getenv("COOKIE_SECURE") != "false"
+}
+
+func setSessionCookie(c echo.Context, token string, expires time.Time) {
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loadSession puts the logged-in user, if any, under "user".
+func loadSession(accounts *accountStore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cookie, err := c.Cookie(sessionCookie)
+			if err != nil || cookie.Value == "" || accounts == nil {
+				return next(c)
+			}
+			ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+			defer cancel()
+			user, err := accounts.lookupSession(ctx, cookie.Value)
+			if err == nil {
+				c.Set("user", user)
+			} else if err != mongo.ErrNoDocuments {
+				fmt.Printf("Error loading session: %v\n", err)
+			}
+			return next(c)
+		}
+	}
+}
+
+func currentUser(c echo.Context) *SessionUser {
+	user, _ := c.Get("user").(*SessionUser)
+	return user
+}
+
+// requireUserRole only lets logged-in users holding role through.
+func requireUserRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := currentUser(c)
+			if user == nil {
+				return c.String(http.StatusUnauthorized, "Please log in first")
+			}
+			if !user.HasRole(role) {
+				return c.String(http.StatusForbidden, fmt.Sprintf("The %s role is required", role))
+			}
+			return next(c)
+		}
+	}
+}